@@ -4,7 +4,6 @@ package main
 
 import (
     "context"
-    "log"
     "net/http"
     "os"
     "os/signal"
@@ -14,6 +13,7 @@ import (
     "movie-microservices/notification-service/internal/config"
     "movie-microservices/notification-service/internal/controllers"
     "movie-microservices/notification-service/internal/database"
+    "movie-microservices/notification-service/internal/events"
     "movie-microservices/notification-service/internal/middleware"
     "movie-microservices/notification-service/internal/redis"
     "movie-microservices/notification-service/internal/repository"
@@ -61,14 +61,26 @@ func main() {
     notificationRepo := repository.NewNotificationRepository(db)
     templateRepo := repository.NewTemplateRepository(db)
     preferenceRepo := repository.NewPreferenceRepository(db)
+    ruleRepo := repository.NewRuleRepository(db)
+    deviceRepo := repository.NewDeviceRepository(db)
 
     // Initialize services
-    emailService := services.NewEmailService(cfg.Email)
-    pushService := services.NewPushService(cfg.Push)
+    emailService, err := services.NewEmailService(cfg.Email)
+    if err != nil {
+        log.Fatal().Err(err).Msg("Failed to initialize email provider")
+    }
+    pushService, err := services.NewPushService(cfg.Push)
+    if err != nil {
+        log.Fatal().Err(err).Msg("Failed to initialize push provider")
+    }
     notificationService := services.NewNotificationService(
         notificationRepo,
         templateRepo,
         preferenceRepo,
+        ruleRepo,
+        deviceRepo,
+        cfg.Push.DeviceMaxIdle,
+        services.NewHTTPUserDirectory(cfg.Users, cfg.JWT.Secret),
         emailService,
         pushService,
         rdb,
@@ -80,10 +92,37 @@ func main() {
     // Initialize preference service
     preferenceService := services.NewPreferenceService(preferenceRepo)
 
+    // Initialize rule service
+    ruleService := services.NewRuleService(ruleRepo)
+
+    // Initialize device service
+    deviceService := services.NewDeviceService(deviceRepo)
+
+    // Consume domain events into notifications in the background
+    eventsCtx, stopEvents := context.WithCancel(context.Background())
+    defer stopEvents()
+    go events.NewConsumer(notificationService, notificationRepo, rdb, cfg.Events).Run(eventsCtx)
+
+    // Send pending notifications in the background
+    dispatcher := services.NewDispatcher(notificationService, cfg.Dispatcher)
+    dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+    defer stopDispatcher()
+    dispatcherDone := make(chan struct{})
+    go func() {
+        dispatcher.Run(dispatcherCtx)
+        close(dispatcherDone)
+    }()
+    if cfg.Dispatcher.Listen {
+        go database.Listen(dispatcherCtx, cfg.Database, repository.PendingChannel, dispatcher.Wake)
+    }
+
     // Initialize controllers
     notificationController := controllers.NewNotificationController(notificationService)
     templateController := controllers.NewTemplateController(templateService)
     preferenceController := controllers.NewPreferenceController(preferenceService)
+    ruleController := controllers.NewRuleController(ruleService)
+    deviceController := controllers.NewDeviceController(deviceService)
+    dispatcherController := controllers.NewDispatcherController(dispatcher)
     healthController := controllers.NewHealthController(db, rdb)
 
     // Setup Gin router
@@ -114,6 +153,13 @@ func main() {
             notifications.DELETE("/:id", middleware.Authenticate("Admin"), notificationController.DeleteNotification)
         }
 
+        // Dead-letter routes
+        deadLetters := api.Group("/dead-letters")
+        {
+            deadLetters.GET("", middleware.Authenticate("Admin"), notificationController.GetDeadLetters)
+            deadLetters.POST("/:id/requeue", middleware.Authenticate("Admin"), notificationController.Requeue)
+        }
+
         // Template routes
         templates := api.Group("/templates")
         {
@@ -124,12 +170,37 @@ func main() {
             templates.DELETE("/:id", middleware.Authenticate("Admin"), templateController.DeleteTemplate)
         }
 
+        // Rule routes
+        rules := api.Group("/rules")
+        {
+            rules.GET("", middleware.Authenticate("Admin"), ruleController.GetRules)
+            rules.GET("/:id", middleware.Authenticate("Admin"), ruleController.GetRule)
+            rules.POST("", middleware.Authenticate("Admin"), ruleController.CreateRule)
+            rules.PUT("/:id", middleware.Authenticate("Admin"), ruleController.UpdateRule)
+            rules.DELETE("/:id", middleware.Authenticate("Admin"), ruleController.DeleteRule)
+        }
+
+        // Dispatcher routes
+        dispatch := api.Group("/dispatcher")
+        {
+            dispatch.GET("/status", middleware.Authenticate("Admin"), dispatcherController.GetStatus)
+        }
+
         // Preference routes
         preferences := api.Group("/preferences")
         {
             preferences.GET("", middleware.Authenticate(), preferenceController.GetPreferences)
             preferences.PUT("", middleware.Authenticate(), preferenceController.UpdatePreferences)
         }
+
+        // Device routes
+        devices := api.Group("/devices")
+        {
+            devices.GET("", middleware.Authenticate(), deviceController.GetDevices)
+            devices.POST("", middleware.Authenticate(), deviceController.RegisterDevice)
+            devices.PUT("/:id", middleware.Authenticate(), deviceController.RefreshDevice)
+            devices.DELETE("/:id", middleware.Authenticate(), deviceController.UnregisterDevice)
+        }
     }
 
     // Start server
@@ -152,6 +223,10 @@ func main() {
 
     log.Info().Msg("Shutting down server...")
 
+    // Stop consuming events and picking up notifications to send
+    stopEvents()
+    stopDispatcher()
+
     // Give outstanding requests a deadline for completion
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
@@ -160,5 +235,12 @@ func main() {
         log.Fatal().Err(err).Msg("Server forced to shutdown")
     }
 
+    // Let notifications already being sent finish
+    select {
+    case <-dispatcherDone:
+    case <-ctx.Done():
+        log.Warn().Msg("Dispatcher did not drain before the deadline")
+    }
+
     log.Info().Msg("Server exited")
 }
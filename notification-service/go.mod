@@ -11,7 +11,6 @@ require (
     github.com/jackc/pgx/v5 v5.4.3
     github.com/lib/pq v1.10.9
     github.com/rs/zerolog v1.30.0
-    //github.com/sendgrid/sendgrid-go/v3 v3.10.0
     github.com/spf13/viper v1.16.0
     golang.org/x/crypto v0.14.0
 )
@@ -32,7 +31,6 @@ require (
     github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
     github.com/modern-go/reflect2 v1.0.2 // indirect
     github.com/pelletier/go-toml/v2 v2.0.8 // indirect
-    github.com/sendgrid/rest/v2 v2.6.1 // indirect
     github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
     github.com/ugorji/go/codec v1.2.11 // indirect
     golang.org/x/arch v0.3.0 // indirect
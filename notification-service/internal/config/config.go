@@ -4,6 +4,9 @@ package config
 
 import (
     "fmt"
+    "strings"
+    "time"
+
     "github.com/spf13/viper"
 )
 
@@ -15,6 +18,9 @@ type Config struct {
     JWT         JWTConfig
     Email       EmailConfig
     Push        PushConfig
+    Events      EventsConfig
+    Dispatcher  DispatcherConfig
+    Users       UsersConfig
 }
 
 type DatabaseConfig struct {
@@ -37,17 +43,117 @@ type JWTConfig struct {
     Secret string
 }
 
+// UsersConfig points at user-service, which has the users' email
+// addresses. Requests carry a token signed with the JWT secret for
+// JWTIssuer and JWTAudience, the values user-service validates.
+type UsersConfig struct {
+    BaseURL     string `mapstructure:"base_url"`
+    JWTIssuer   string `mapstructure:"jwt_issuer"`
+    JWTAudience string `mapstructure:"jwt_audience"`
+    Timeout     time.Duration
+}
+
+// EmailConfig selects and configures the email provider: "sendgrid" or
+// "smtp". BaseURL points the SendGrid client elsewhere, e.g. at a test
+// server. Timeout bounds each request to SendGrid or SMTP conversation.
 type EmailConfig struct {
-    Provider   string
-    APIKey     string
-    FromEmail  string
-    FromName   string
+    Provider  string
+    APIKey    string `mapstructure:"api_key"`
+    FromEmail string `mapstructure:"from_email"`
+    FromName  string `mapstructure:"from_name"`
+    BaseURL   string `mapstructure:"base_url"`
+    Timeout   time.Duration
+    SMTP      SMTPConfig
 }
 
+// SMTPConfig configures the SMTP provider. TLS is "starttls", "tls" for
+// implicit TLS, or "none"; Auth is "plain", "login" or "none". Messages are
+// DKIM-signed when DKIMKeyFile names a PEM-encoded RSA private key.
+type SMTPConfig struct {
+    Host               string
+    Port               int
+    Username           string
+    Password           string
+    TLS                string
+    Auth               string
+    InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+    DKIMDomain         string `mapstructure:"dkim_domain"`
+    DKIMSelector       string `mapstructure:"dkim_selector"`
+    DKIMKeyFile        string `mapstructure:"dkim_key_file"`
+}
+
+// PushConfig selects the push provider: "fcm" to reach every device
+// through FCM, "apns" to reach only iOS devices through APNs, or "platform"
+// to reach iOS devices through APNs and the others through FCM. Devices
+// not seen for DeviceMaxIdle are no longer sent to; zero sends to every
+// registered device.
 type PushConfig struct {
-    Provider   string
-    APIKey     string
-    AuthToken  string
+    Provider      string
+    DeviceMaxIdle time.Duration `mapstructure:"device_max_idle"`
+    FCM           FCMConfig
+    APNs          APNsConfig
+}
+
+// FCMConfig configures the FCM HTTP v1 provider. CredentialsFile is a
+// service account JSON key; ProjectID and TokenURL default to the key's
+// project_id and token_uri.
+type FCMConfig struct {
+    CredentialsFile string `mapstructure:"credentials_file"`
+    ProjectID       string `mapstructure:"project_id"`
+    TokenURL        string `mapstructure:"token_url"`
+    BaseURL         string `mapstructure:"base_url"`
+    Timeout         time.Duration
+}
+
+// APNsConfig configures the APNs provider, which authenticates with
+// provider tokens signed by the .p8 key in KeyFile. Topic is the app's
+// bundle ID. Endpoint is https://api.push.apple.com in production and
+// https://api.sandbox.push.apple.com for development builds.
+type APNsConfig struct {
+    KeyFile            string `mapstructure:"key_file"`
+    KeyID              string `mapstructure:"key_id"`
+    TeamID             string `mapstructure:"team_id"`
+    Topic              string
+    Endpoint           string
+    Timeout            time.Duration
+    InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+// EventsConfig controls the consumer that turns domain events from Redis
+// Streams into notifications. Entries another consumer left unacknowledged
+// for longer than ClaimIdle are taken over, and processed event IDs are kept
+// for Retention to discard redeliveries.
+type EventsConfig struct {
+    Streams   []string
+    Group     string
+    Consumer  string
+    BatchSize int64 `mapstructure:"batch_size"`
+    Block     time.Duration
+    ClaimIdle time.Duration `mapstructure:"claim_idle"`
+    Retention time.Duration
+}
+
+// DispatcherConfig controls the background sending of pending
+// notifications. Up to BatchSize due notifications are picked up every
+// PollInterval and sent by at most Workers goroutines, with no more than
+// Concurrency[channel] of them sending on the same channel at once.
+// Channels without a limit get one worker. A send that fails with a
+// transient error is retried after a backoff doubling from RetryBaseDelay
+// up to RetryMaxDelay, until MaxAttempts attempts have been made. Picked up
+// notifications are leased for LeaseDuration, which must be longer than a
+// batch takes to send; after that other replicas may pick them up again.
+// With Listen, the dispatcher also runs as soon as a notification is
+// created, and polling only catches what it missed and delayed ones.
+type DispatcherConfig struct {
+    PollInterval   time.Duration `mapstructure:"poll_interval"`
+    BatchSize      int           `mapstructure:"batch_size"`
+    LeaseDuration  time.Duration `mapstructure:"lease_duration"`
+    Workers        int
+    Concurrency    map[string]int
+    Listen         bool
+    MaxAttempts    int           `mapstructure:"max_attempts"`
+    RetryBaseDelay time.Duration `mapstructure:"retry_base_delay"`
+    RetryMaxDelay  time.Duration `mapstructure:"retry_max_delay"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -74,9 +180,55 @@ func LoadConfig() (*Config, error) {
     viper.SetDefault("email.provider", "sendgrid")
     viper.SetDefault("email.from_email", "noreply@movie-microservices.com")
     viper.SetDefault("email.from_name", "Movie Microservices")
+    viper.SetDefault("email.base_url", "https://api.sendgrid.com")
+    viper.SetDefault("email.timeout", "10s")
+    viper.SetDefault("email.smtp.host", "")
+    viper.SetDefault("email.smtp.port", 587)
+    viper.SetDefault("email.smtp.username", "")
+    viper.SetDefault("email.smtp.password", "")
+    viper.SetDefault("email.smtp.tls", "starttls")
+    viper.SetDefault("email.smtp.auth", "plain")
+    viper.SetDefault("email.smtp.insecure_skip_verify", false)
+    viper.SetDefault("email.smtp.dkim_domain", "")
+    viper.SetDefault("email.smtp.dkim_selector", "")
+    viper.SetDefault("email.smtp.dkim_key_file", "")
     viper.SetDefault("push.provider", "fcm")
+    viper.SetDefault("push.device_max_idle", "1440h")
+    viper.SetDefault("push.fcm.credentials_file", "")
+    viper.SetDefault("push.fcm.project_id", "")
+    viper.SetDefault("push.fcm.token_url", "")
+    viper.SetDefault("push.fcm.base_url", "https://fcm.googleapis.com")
+    viper.SetDefault("push.fcm.timeout", "10s")
+    viper.SetDefault("push.apns.key_file", "")
+    viper.SetDefault("push.apns.key_id", "")
+    viper.SetDefault("push.apns.team_id", "")
+    viper.SetDefault("push.apns.topic", "")
+    viper.SetDefault("push.apns.endpoint", "https://api.push.apple.com")
+    viper.SetDefault("push.apns.timeout", "10s")
+    viper.SetDefault("push.apns.insecure_skip_verify", false)
+    viper.SetDefault("events.streams", []string{"events:watchlist"})
+    viper.SetDefault("events.group", "notification-service")
+    viper.SetDefault("events.consumer", "")
+    viper.SetDefault("events.batch_size", 50)
+    viper.SetDefault("events.block", "5s")
+    viper.SetDefault("events.claim_idle", "1m")
+    viper.SetDefault("events.retention", "168h")
+    viper.SetDefault("dispatcher.poll_interval", "5s")
+    viper.SetDefault("dispatcher.batch_size", 100)
+    viper.SetDefault("dispatcher.lease_duration", "5m")
+    viper.SetDefault("dispatcher.workers", 10)
+    viper.SetDefault("dispatcher.listen", true)
+    viper.SetDefault("dispatcher.concurrency", map[string]int{"email": 5, "push": 10})
+    viper.SetDefault("dispatcher.max_attempts", 5)
+    viper.SetDefault("dispatcher.retry_base_delay", "30s")
+    viper.SetDefault("dispatcher.retry_max_delay", "1h")
+    viper.SetDefault("users.base_url", "http://user-service:8080")
+    viper.SetDefault("users.jwt_issuer", "movie-microservices")
+    viper.SetDefault("users.jwt_audience", "movie-microservices-clients")
+    viper.SetDefault("users.timeout", "10s")
     
-    // Enable environment variable override
+    // Enable environment variable override, e.g. EMAIL_FROM_EMAIL for email.from_email
+    viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
     viper.AutomaticEnv()
     
     // Read configuration
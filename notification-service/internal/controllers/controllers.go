@@ -5,7 +5,9 @@ package controllers
 import (
     "context"
     "database/sql"
+    "errors"
     "movie-microservices/notification-service/internal/models"
+    "movie-microservices/notification-service/internal/repository"
     "movie-microservices/notification-service/internal/services"
     "net/http"
     "strconv"
@@ -45,7 +47,7 @@ func (ctrl *NotificationController) GetNotifications(c *gin.Context) {
 }
 
 func (ctrl *NotificationController) GetNotification(c *gin.Context) {
-    userID, exists := c.Get("userID")
+    _, exists := c.Get("userID")
     if !exists {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
         return
@@ -90,7 +92,7 @@ func (ctrl *NotificationController) CreateNotification(c *gin.Context) {
 }
 
 func (ctrl *NotificationController) MarkAsRead(c *gin.Context) {
-    userID, exists := c.Get("userID")
+    _, exists := c.Get("userID")
     if !exists {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
         return
@@ -118,7 +120,7 @@ func (ctrl *NotificationController) MarkAsRead(c *gin.Context) {
 
 func (ctrl *NotificationController) DeleteNotification(c *gin.Context) {
     idStr := c.Param("id")
-    id, err := strconv.Atoi(idStr)
+    _, err := strconv.Atoi(idStr)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
         return
@@ -132,6 +134,63 @@ func (ctrl *NotificationController) DeleteNotification(c *gin.Context) {
     })
 }
 
+// GetDeadLetters lists notifications that won't be retried, with the error
+// of their last attempt. It pages with limit (default 50, at most 100) and
+// offset.
+func (ctrl *NotificationController) GetDeadLetters(c *gin.Context) {
+    limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+    if err != nil || limit < 1 || limit > 100 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+        return
+    }
+    
+    offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+    if err != nil || offset < 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+        return
+    }
+    
+    notifications, err := ctrl.service.GetDeadLetters(limit, offset)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to get dead-lettered notifications")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dead-lettered notifications"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    notifications,
+    })
+}
+
+// Requeue puts a dead-lettered notification back to pending with its
+// attempts reset
+func (ctrl *NotificationController) Requeue(c *gin.Context) {
+    idStr := c.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+        return
+    }
+    
+    notification, err := ctrl.service.Requeue(id)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to requeue notification")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue notification"})
+        return
+    }
+    
+    if notification == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Dead-lettered notification not found"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    notification,
+    })
+}
+
 type TemplateController struct {
     service services.TemplateService
 }
@@ -258,6 +317,151 @@ func (ctrl *TemplateController) DeleteTemplate(c *gin.Context) {
     })
 }
 
+type RuleController struct {
+    service services.RuleService
+}
+
+func NewRuleController(service services.RuleService) *RuleController {
+    return &RuleController{service: service}
+}
+
+func (ctrl *RuleController) GetRules(c *gin.Context) {
+    rules, err := ctrl.service.GetAllRules()
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to get rules")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rules"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    rules,
+    })
+}
+
+func (ctrl *RuleController) GetRule(c *gin.Context) {
+    idStr := c.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+        return
+    }
+    
+    rule, err := ctrl.service.GetRuleByID(id)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to get rule")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rule"})
+        return
+    }
+    
+    if rule == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    rule,
+    })
+}
+
+func (ctrl *RuleController) CreateRule(c *gin.Context) {
+    var req models.CreateRuleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    rule, err := ctrl.service.CreateRule(ruleFromRequest(req))
+    if err != nil {
+        if errors.Is(err, repository.ErrRuleExists) {
+            c.JSON(http.StatusConflict, gin.H{"error": "A rule for this event type, template and channel already exists"})
+            return
+        }
+        log.Error().Err(err).Msg("Failed to create rule")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+        return
+    }
+    
+    c.JSON(http.StatusCreated, gin.H{
+        "success": true,
+        "data":    rule,
+    })
+}
+
+func (ctrl *RuleController) UpdateRule(c *gin.Context) {
+    idStr := c.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+        return
+    }
+    
+    var req models.CreateRuleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    rule, err := ctrl.service.UpdateRule(id, ruleFromRequest(req))
+    if err != nil {
+        if errors.Is(err, repository.ErrRuleExists) {
+            c.JSON(http.StatusConflict, gin.H{"error": "A rule for this event type, template and channel already exists"})
+            return
+        }
+        log.Error().Err(err).Msg("Failed to update rule")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+        return
+    }
+    
+    if rule == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    rule,
+    })
+}
+
+func (ctrl *RuleController) DeleteRule(c *gin.Context) {
+    idStr := c.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+        return
+    }
+    
+    err = ctrl.service.DeleteRule(id)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to delete rule")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "message": "Rule deleted",
+    })
+}
+
+// ruleFromRequest builds a rule from a request, enabled unless it says
+// otherwise
+func ruleFromRequest(req models.CreateRuleRequest) *models.Rule {
+    enabled := true
+    if req.Enabled != nil {
+        enabled = *req.Enabled
+    }
+    return &models.Rule{
+        EventType:    req.EventType,
+        TemplateName: req.TemplateName,
+        Channel:      req.Channel,
+        DelaySeconds: req.DelaySeconds,
+        Enabled:      enabled,
+    }
+}
+
 type PreferenceController struct {
     service services.PreferenceService
 }
@@ -312,6 +516,151 @@ func (ctrl *PreferenceController) UpdatePreferences(c *gin.Context) {
     })
 }
 
+// DeviceController handles the devices users register for push
+// notifications
+type DeviceController struct {
+    service services.DeviceService
+}
+
+func NewDeviceController(service services.DeviceService) *DeviceController {
+    return &DeviceController{service: service}
+}
+
+func (ctrl *DeviceController) GetDevices(c *gin.Context) {
+    userID, exists := c.Get("userID")
+    if !exists {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+        return
+    }
+    
+    devices, err := ctrl.service.GetDevices(userID.(int))
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to get devices")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get devices"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    devices,
+    })
+}
+
+// RegisterDevice registers a push token for the user. Registering a token
+// again updates its device rather than adding another.
+func (ctrl *DeviceController) RegisterDevice(c *gin.Context) {
+    userID, exists := c.Get("userID")
+    if !exists {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+        return
+    }
+    
+    var req models.RegisterDeviceRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    device, err := ctrl.service.Register(userID.(int), req)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to register device")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+        return
+    }
+    
+    c.JSON(http.StatusCreated, gin.H{
+        "success": true,
+        "data":    device,
+    })
+}
+
+// RefreshDevice records that a device is still in use, with the new push
+// token and app version if they changed
+func (ctrl *DeviceController) RefreshDevice(c *gin.Context) {
+    userID, exists := c.Get("userID")
+    if !exists {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+        return
+    }
+    
+    idStr := c.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+        return
+    }
+    
+    var req models.RefreshDeviceRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    device, err := ctrl.service.Refresh(userID.(int), id, req)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to refresh device")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh device"})
+        return
+    }
+    
+    if device == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    device,
+    })
+}
+
+func (ctrl *DeviceController) UnregisterDevice(c *gin.Context) {
+    userID, exists := c.Get("userID")
+    if !exists {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+        return
+    }
+    
+    idStr := c.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+        return
+    }
+    
+    found, err := ctrl.service.Unregister(userID.(int), id)
+    if err != nil {
+        log.Error().Err(err).Msg("Failed to unregister device")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unregister device"})
+        return
+    }
+    
+    if !found {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+        return
+    }
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "message": "Device unregistered",
+    })
+}
+
+type DispatcherController struct {
+    dispatcher *services.Dispatcher
+}
+
+func NewDispatcherController(dispatcher *services.Dispatcher) *DispatcherController {
+    return &DispatcherController{dispatcher: dispatcher}
+}
+
+func (ctrl *DispatcherController) GetStatus(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "data":    ctrl.dispatcher.Status(),
+    })
+}
+
 type HealthController struct {
     db  *sql.DB
     rdb *redis.Client
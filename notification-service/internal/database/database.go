@@ -9,10 +9,7 @@ import (
 )
 
 func Connect(cfg config.DatabaseConfig) (*sql.DB, error) {
-    connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-        cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
-
-    db, err := sql.Open("postgres", connStr)
+    db, err := sql.Open("postgres", connString(cfg))
     if err != nil {
         return nil, fmt.Errorf("failed to open database: %w", err)
     }
@@ -25,6 +22,11 @@ func Connect(cfg config.DatabaseConfig) (*sql.DB, error) {
     return db, nil
 }
 
+func connString(cfg config.DatabaseConfig) string {
+    return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+        cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
+}
+
 func RunMigrations(db *sql.DB) error {
     // Create notifications table
     if _, err := db.Exec(`
@@ -75,6 +77,85 @@ func RunMigrations(db *sql.DB) error {
         return fmt.Errorf("failed to create preferences table: %w", err)
     }
 
+    // Add event columns to notifications
+    if _, err := db.Exec(`
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS event_id VARCHAR(64);
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS send_after TIMESTAMP WITH TIME ZONE;
+    `); err != nil {
+        return fmt.Errorf("failed to add notifications event columns: %w", err)
+    }
+
+    // Add retry columns to notifications
+    if _, err := db.Exec(`
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+    `); err != nil {
+        return fmt.Errorf("failed to add notifications retry columns: %w", err)
+    }
+
+    // Add lease columns to notifications
+    if _, err := db.Exec(`
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS leased_until TIMESTAMP WITH TIME ZONE;
+        ALTER TABLE notifications ADD COLUMN IF NOT EXISTS lease_token VARCHAR(64);
+    `); err != nil {
+        return fmt.Errorf("failed to add notifications lease columns: %w", err)
+    }
+
+    // Create notification rules table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS notification_rules (
+            id SERIAL PRIMARY KEY,
+            event_type VARCHAR(100) NOT NULL,
+            template_name VARCHAR(100) NOT NULL,
+            channel VARCHAR(50) NOT NULL,
+            delay_seconds INTEGER NOT NULL DEFAULT 0 CHECK (delay_seconds >= 0),
+            enabled BOOLEAN NOT NULL DEFAULT true,
+            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(event_type, template_name, channel)
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create notification rules table: %w", err)
+    }
+
+    // Create processed events table, used to discard redelivered events
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS processed_events (
+            event_id VARCHAR(64) PRIMARY KEY,
+            event_type VARCHAR(100) NOT NULL,
+            processed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create processed events table: %w", err)
+    }
+
+    // Create devices table. A token belongs to one app install, so it is
+    // registered to one user at a time.
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS devices (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            platform VARCHAR(20) NOT NULL,
+            token TEXT NOT NULL UNIQUE,
+            app_version VARCHAR(50) NOT NULL DEFAULT '',
+            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create devices table: %w", err)
+    }
+
+    // Create seeds table, recording the default data already inserted
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS seeds (
+            name VARCHAR(100) PRIMARY KEY,
+            applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create seeds table: %w", err)
+    }
+
     // Create indexes
     if _, err := db.Exec(`
         CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id);
@@ -88,17 +169,78 @@ func RunMigrations(db *sql.DB) error {
         return fmt.Errorf("failed to create notifications status index: %w", err)
     }
 
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_notifications_pending ON notifications(created_at) WHERE status = 'pending';
+    `); err != nil {
+        return fmt.Errorf("failed to create notifications pending index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_notification_rules_event_type ON notification_rules(event_type) WHERE enabled;
+    `); err != nil {
+        return fmt.Errorf("failed to create notification rules event_type index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_processed_events_processed_at ON processed_events(processed_at);
+    `); err != nil {
+        return fmt.Errorf("failed to create processed events processed_at index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices(user_id);
+    `); err != nil {
+        return fmt.Errorf("failed to create devices user_id index: %w", err)
+    }
+
     // Insert default templates
     if _, err := db.Exec(`
         INSERT INTO templates (name, type, subject, content) VALUES
         ('movie_recommendation', 'email', 'New Movie Recommendation', 'Hi {{.Username}}, we have a new movie recommendation for you: {{.MovieTitle}}'),
         ('watchlist_reminder', 'email', 'Movies in Your Watchlist', 'Hi {{.Username}}, you have {{.Count}} movies in your watchlist. Why not watch one tonight?'),
         ('new_release', 'email', 'New Movie Release', 'Hi {{.Username}}, a new movie "{{.MovieTitle}}" has been released that you might like.'),
-        ('rating_reminder', 'email', 'Rate Your Watched Movies', 'Hi {{.Username}}, you recently watched "{{.MovieTitle}}". Would you like to rate it?')
+        ('rating_reminder', 'email', 'Rate Your Watched Movies', 'Hi {{.Username}}, you recently watched "{{.MovieTitle}}". Would you like to rate it?'),
+        ('rating_prompt', 'push', 'How was the movie?', 'You finished a movie. Tap to rate it.')
         ON CONFLICT (name, type) DO NOTHING;
     `); err != nil {
         return fmt.Errorf("failed to insert default templates: %w", err)
     }
 
+    // Insert default rules, disabled until an admin turns them on. They are
+    // only inserted once, so a rule an admin deleted stays deleted.
+    if err := seedOnce(db, "default_rules", `
+        INSERT INTO notification_rules (event_type, template_name, channel, delay_seconds, enabled) VALUES
+        ('history.finished', 'rating_prompt', 'push', 7200, false)
+        ON CONFLICT (event_type, template_name, channel) DO NOTHING;
+    `); err != nil {
+        return fmt.Errorf("failed to insert default rules: %w", err)
+    }
+
     return nil
 }
+
+// seedOnce runs query the first time it is called with name, recording
+// name in the seeds table in the same transaction
+func seedOnce(db *sql.DB, name, query string) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec(`
+        INSERT INTO seeds (name) VALUES ($1)
+        ON CONFLICT (name) DO NOTHING
+    `, name)
+    if err != nil {
+        return err
+    }
+    if n, err := res.RowsAffected(); err != nil || n == 0 {
+        return err
+    }
+
+    if _, err := tx.Exec(query); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+    "context"
+    "movie-microservices/notification-service/internal/config"
+    "time"
+
+    "github.com/lib/pq"
+    "github.com/rs/zerolog/log"
+)
+
+const (
+    // listenerPingInterval is how often an idle listener checks its
+    // connection is still alive
+    listenerPingInterval = 90 * time.Second
+
+    minReconnectInterval = time.Second
+    maxReconnectInterval = time.Minute
+)
+
+// Listen LISTENs on channel on its own connection and calls wake for every
+// notification, until ctx is cancelled. It waits for the first connection
+// to succeed. A lost connection is re-established in the background, and
+// wake is called once it is back since notifications sent meanwhile were
+// missed.
+func Listen(ctx context.Context, cfg config.DatabaseConfig, channel string, wake func()) {
+    listener := pq.NewListener(connString(cfg), minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+        switch event {
+        case pq.ListenerEventDisconnected:
+            log.Warn().Err(err).Str("channel", channel).Msg("Lost database listener connection")
+        case pq.ListenerEventConnectionAttemptFailed:
+            log.Warn().Err(err).Str("channel", channel).Msg("Failed to connect database listener")
+        case pq.ListenerEventReconnected:
+            log.Info().Str("channel", channel).Msg("Database listener reconnected")
+        }
+    })
+    defer listener.Close()
+
+    if err := listener.Listen(channel); err != nil {
+        log.Error().Err(err).Str("channel", channel).Msg("Failed to listen on database channel")
+        return
+    }
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-listener.Notify:
+            // A nil notification means the connection was re-established
+            wake()
+        case <-time.After(listenerPingInterval):
+            if err := listener.Ping(); err != nil {
+                log.Warn().Err(err).Str("channel", channel).Msg("Database listener ping failed")
+            }
+        }
+    }
+}
@@ -0,0 +1,231 @@
+// notification-service/internal/events/consumer.go
+
+package events
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "strings"
+    "time"
+
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/models"
+    "movie-microservices/notification-service/internal/repository"
+    "movie-microservices/notification-service/internal/services"
+
+    "github.com/go-redis/redis/v8"
+    "github.com/rs/zerolog/log"
+)
+
+const (
+    // eventVersion is the envelope version this consumer understands
+    eventVersion = 1
+
+    // maxBackoff caps the wait between attempts while Redis or the
+    // database is failing
+    maxBackoff = time.Minute
+
+    // cleanupInterval is how often old processed event IDs are pruned
+    cleanupInterval = time.Hour
+)
+
+// Consumer reads domain events from Redis Streams as a member of a consumer
+// group and hands them to NotificationService.HandleEvent. An entry is
+// acknowledged only once the notifications it produced are stored, so an
+// event is never lost if the service stops halfway; the event ID recorded
+// with them makes the redelivery a no-op. Entries that can't be decoded are
+// logged and acknowledged so they don't block the group.
+type Consumer struct {
+    service          services.NotificationService
+    notificationRepo repository.NotificationRepository
+    rdb              *redis.Client
+    cfg              config.EventsConfig
+    name             string
+}
+
+func NewConsumer(service services.NotificationService, notificationRepo repository.NotificationRepository, rdb *redis.Client, cfg config.EventsConfig) *Consumer {
+    name := cfg.Consumer
+    if name == "" {
+        name, _ = os.Hostname()
+    }
+    if name == "" {
+        name = "notification-service"
+    }
+
+    return &Consumer{
+        service:          service,
+        notificationRepo: notificationRepo,
+        rdb:              rdb,
+        cfg:              cfg,
+        name:             name,
+    }
+}
+
+// Run consumes events until ctx is cancelled. It starts with the entries
+// this consumer read but never acknowledged, then reads new ones, and every
+// ClaimIdle takes over entries other consumers have left pending that long.
+// After a failure it backs off exponentially and goes back to its own
+// pending entries, so events are retried in order.
+func (c *Consumer) Run(ctx context.Context) {
+    backoff := time.Second
+    groupsReady := false
+    pending := true
+    lastClaim := time.Now()
+    lastCleanup := time.Time{}
+
+    for {
+        var err error
+        switch {
+        case !groupsReady:
+            if err = c.createGroups(ctx); err == nil {
+                groupsReady = true
+            }
+        case pending:
+            var n int
+            if n, err = c.read(ctx, "0", -1); err == nil && n == 0 {
+                pending = false
+            }
+        default:
+            _, err = c.read(ctx, ">", c.cfg.Block)
+        }
+
+        if err == nil && groupsReady && time.Since(lastClaim) >= c.cfg.ClaimIdle {
+            lastClaim = time.Now()
+            err = c.claim(ctx)
+        }
+
+        if err == nil && time.Since(lastCleanup) >= cleanupInterval {
+            lastCleanup = time.Now()
+            if deleted, err := c.notificationRepo.DeleteProcessedEvents(time.Now().Add(-c.cfg.Retention)); err != nil {
+                log.Warn().Err(err).Msg("Failed to prune processed events")
+            } else if deleted > 0 {
+                log.Info().Int64("deleted", deleted).Msg("Pruned processed events")
+            }
+        }
+
+        if ctx.Err() != nil {
+            return
+        }
+
+        if err == nil {
+            backoff = time.Second
+            continue
+        }
+
+        log.Error().Err(err).Dur("retryIn", backoff).Msg("Failed to consume events")
+        pending = true
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+        if backoff > maxBackoff {
+            backoff = maxBackoff
+        }
+    }
+}
+
+// createGroups creates the consumer group on each stream, starting from new
+// entries, along with any stream that doesn't exist yet
+func (c *Consumer) createGroups(ctx context.Context) error {
+    for _, stream := range c.cfg.Streams {
+        err := c.rdb.XGroupCreateMkStream(ctx, stream, c.cfg.Group, "$").Err()
+        if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+            return err
+        }
+    }
+    return nil
+}
+
+// read reads up to BatchSize entries per stream from id onwards and handles
+// them: "0" re-reads this consumer's pending entries, ">" reads new ones,
+// waiting up to block for them. It returns the number of entries read.
+func (c *Consumer) read(ctx context.Context, id string, block time.Duration) (int, error) {
+    streams := make([]string, 0, 2*len(c.cfg.Streams))
+    streams = append(streams, c.cfg.Streams...)
+    for range c.cfg.Streams {
+        streams = append(streams, id)
+    }
+
+    res, err := c.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+        Group:    c.cfg.Group,
+        Consumer: c.name,
+        Streams:  streams,
+        Count:    c.cfg.BatchSize,
+        Block:    block,
+    }).Result()
+    if err == redis.Nil {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    n := 0
+    for _, s := range res {
+        for _, msg := range s.Messages {
+            if err := c.handle(ctx, s.Stream, msg); err != nil {
+                return n, err
+            }
+            n++
+        }
+    }
+    return n, nil
+}
+
+// claim takes over and handles entries that have been pending with other
+// consumers for at least ClaimIdle, e.g. because their replica died
+func (c *Consumer) claim(ctx context.Context) error {
+    for _, stream := range c.cfg.Streams {
+        start := "0-0"
+        for {
+            msgs, next, err := c.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+                Stream:   stream,
+                Group:    c.cfg.Group,
+                Consumer: c.name,
+                MinIdle:  c.cfg.ClaimIdle,
+                Start:    start,
+                Count:    c.cfg.BatchSize,
+            }).Result()
+            if err != nil {
+                return err
+            }
+
+            for _, msg := range msgs {
+                if err := c.handle(ctx, stream, msg); err != nil {
+                    return err
+                }
+            }
+
+            if next == "0-0" {
+                break
+            }
+            start = next
+        }
+    }
+    return nil
+}
+
+// handle processes one entry and acknowledges it once it needs no retry
+func (c *Consumer) handle(ctx context.Context, stream string, msg redis.XMessage) error {
+    payload, _ := msg.Values["payload"].(string)
+
+    var event models.Event
+    if err := json.Unmarshal([]byte(payload), &event); err != nil || event.ID == "" {
+        log.Error().Err(err).Str("stream", stream).Str("entryID", msg.ID).Msg("Discarding malformed event")
+        return c.rdb.XAck(ctx, stream, c.cfg.Group, msg.ID).Err()
+    }
+
+    if event.Version != eventVersion {
+        log.Warn().Str("eventID", event.ID).Int("version", event.Version).Msg("Discarding event with unsupported version")
+        return c.rdb.XAck(ctx, stream, c.cfg.Group, msg.ID).Err()
+    }
+
+    if err := c.service.HandleEvent(&event); err != nil {
+        return err
+    }
+
+    return c.rdb.XAck(ctx, stream, c.cfg.Group, msg.ID).Err()
+}
@@ -2,17 +2,41 @@ package models
 
 import "time"
 
+// Notification statuses. A pending notification whose send failed with a
+// retryable error stays pending until NextAttemptAt; one that failed
+// permanently or ran out of attempts is dead-lettered. Failed is only found
+// on notifications from before retries existed.
+const (
+    StatusPending = "pending"
+    StatusSent    = "sent"
+    StatusFailed  = "failed"
+    StatusDead    = "dead"
+)
+
+// Device platforms, which decide the push provider a device is reached
+// through
+const (
+    PlatformIOS     = "ios"
+    PlatformAndroid = "android"
+    PlatformWeb     = "web"
+)
+
 type Notification struct {
-    ID        int       `json:"id"`
-    UserID    int       `json:"userId"`
-    Type      string    `json:"type"`
-    Title     string    `json:"title"`
-    Content   string    `json:"content"`
-    Status    string    `json:"status"`
-    Channel   string    `json:"channel"`
-    CreatedAt time.Time `json:"createdAt"`
-    SentAt    time.Time `json:"sentAt"`
-    ReadAt    time.Time `json:"readAt"`
+    ID            int        `json:"id"`
+    UserID        int        `json:"userId"`
+    Type          string     `json:"type"`
+    Title         string     `json:"title"`
+    Content       string     `json:"content"`
+    Status        string     `json:"status"`
+    Channel       string     `json:"channel"`
+    SendAfter     *time.Time `json:"sendAfter,omitempty"`
+    Attempts      int        `json:"attempts"`
+    NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+    LastError     string     `json:"lastError,omitempty"`
+    CreatedAt     time.Time  `json:"createdAt"`
+    SentAt        *time.Time `json:"sentAt"`
+    ReadAt        *time.Time `json:"readAt"`
+    LeaseToken    string     `json:"-"`
 }
 
 type Template struct {
@@ -34,6 +58,63 @@ type Preference struct {
     UpdatedAt    time.Time `json:"updatedAt"`
 }
 
+// Device is an app install registered to receive a user's push
+// notifications. Token is the push token the platform's provider issued it.
+type Device struct {
+    ID         int       `json:"id"`
+    UserID     int       `json:"userId"`
+    Platform   string    `json:"platform"`
+    Token      string    `json:"token"`
+    AppVersion string    `json:"appVersion"`
+    CreatedAt  time.Time `json:"createdAt"`
+    LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+// Rule turns events of EventType into a notification rendered from the
+// template named TemplateName whose type is Channel. The notification is not
+// sent until DelaySeconds after the event occurred.
+type Rule struct {
+    ID           int       `json:"id"`
+    EventType    string    `json:"eventType"`
+    TemplateName string    `json:"templateName"`
+    Channel      string    `json:"channel"`
+    DelaySeconds int       `json:"delaySeconds"`
+    Enabled      bool      `json:"enabled"`
+    CreatedAt    time.Time `json:"createdAt"`
+    UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Event is the envelope of domain events read from the events streams, as
+// published by the other services. ID is unique per event.
+type Event struct {
+    Version    int                    `json:"version"`
+    ID         string                 `json:"id"`
+    Type       string                 `json:"type"`
+    UserID     int                    `json:"userId"`
+    MovieID    int                    `json:"movieId"`
+    OccurredAt time.Time              `json:"occurredAt"`
+    Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// DispatcherStatus reports what the dispatcher has been doing since the
+// service started. Processed counts notifications it tried to send, Sent and
+// Failed the outcomes, DeadLettered the failures that won't be retried, and
+// Errors the runs that failed before sending.
+type DispatcherStatus struct {
+    Running         bool       `json:"running"`
+    LastRunAt       *time.Time `json:"lastRunAt"`
+    LastRunDuration string     `json:"lastRunDuration"`
+    LastError       string     `json:"lastError,omitempty"`
+    LastErrorAt     *time.Time `json:"lastErrorAt,omitempty"`
+    Runs            int64      `json:"runs"`
+    Processed       int64      `json:"processed"`
+    Sent            int64      `json:"sent"`
+    Failed          int64      `json:"failed"`
+    DeadLettered    int64      `json:"deadLettered"`
+    Errors          int64      `json:"errors"`
+    Restarts        int64      `json:"restarts"`
+}
+
 type CreateNotificationRequest struct {
     UserID  int    `json:"userId" binding:"required"`
     Type    string `json:"type" binding:"required"`
@@ -49,7 +130,28 @@ type CreateTemplateRequest struct {
     Content string `json:"content" binding:"required"`
 }
 
+type CreateRuleRequest struct {
+    EventType    string `json:"eventType" binding:"required"`
+    TemplateName string `json:"templateName" binding:"required"`
+    Channel      string `json:"channel" binding:"required,oneof=email push"`
+    DelaySeconds int    `json:"delaySeconds" binding:"min=0"`
+    Enabled      *bool  `json:"enabled"`
+}
+
 type UpdatePreferenceRequest struct {
     EmailEnabled *bool `json:"emailEnabled"`
     PushEnabled  *bool `json:"pushEnabled"`
 }
+
+type RegisterDeviceRequest struct {
+    Platform   string `json:"platform" binding:"required,oneof=ios android web"`
+    Token      string `json:"token" binding:"required,max=4096"`
+    AppVersion string `json:"appVersion" binding:"max=50"`
+}
+
+// RefreshDeviceRequest replaces a device's token and app version; either
+// can be left out to keep it
+type RefreshDeviceRequest struct {
+    Token      string `json:"token" binding:"max=4096"`
+    AppVersion string `json:"appVersion" binding:"max=50"`
+}
@@ -1,10 +1,30 @@
 package repository
 
 import (
+    "crypto/rand"
     "database/sql"
+    "encoding/hex"
+    "errors"
     "movie-microservices/notification-service/internal/models"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/lib/pq"
 )
 
+// PendingChannel is the Postgres NOTIFY channel told the ID of every
+// notification that becomes pending
+const PendingChannel = "notifications_pending"
+
+// ErrLeaseLost is returned by RecordAttempt when the notification's lease
+// expired and another dispatcher claimed it
+var ErrLeaseLost = errors.New("notification lease lost")
+
+// ErrRuleExists is returned when another rule already sends the same
+// template over the same channel for the event type
+var ErrRuleExists = errors.New("rule already exists")
+
 type NotificationRepository interface {
     GetByUserID(userID int) ([]*models.Notification, error)
     GetByID(id int) (*models.Notification, error)
@@ -12,7 +32,12 @@ type NotificationRepository interface {
     UpdateStatus(id int, status string) error
     MarkAsRead(id int) error
     Delete(id int) error
-    GetPending() ([]*models.Notification, error)
+    ClaimPending(limit int, lease time.Duration) ([]*models.Notification, error)
+    RecordAttempt(id int, leaseToken, status, lastError string, nextAttemptAt *time.Time) error
+    GetDeadLetters(limit, offset int) ([]*models.Notification, error)
+    Requeue(id int) (*models.Notification, error)
+    CreateForEvent(event *models.Event, notifications []*models.Notification) (bool, error)
+    DeleteProcessedEvents(before time.Time) (int64, error)
 }
 
 type TemplateRepository interface {
@@ -24,11 +49,29 @@ type TemplateRepository interface {
     Delete(id int) error
 }
 
+type RuleRepository interface {
+    GetAll() ([]*models.Rule, error)
+    GetByID(id int) (*models.Rule, error)
+    GetByEventType(eventType string) ([]*models.Rule, error)
+    Create(rule *models.Rule) (*models.Rule, error)
+    Update(rule *models.Rule) (*models.Rule, error)
+    Delete(id int) error
+}
+
 type PreferenceRepository interface {
     GetByUserID(userID int) (*models.Preference, error)
     CreateOrUpdate(preference *models.Preference) (*models.Preference, error)
 }
 
+type DeviceRepository interface {
+    GetByUserID(userID int) ([]*models.Device, error)
+    GetActiveByUserID(userID int, seenSince time.Time) ([]*models.Device, error)
+    Register(device *models.Device) (*models.Device, error)
+    Refresh(device *models.Device) (*models.Device, error)
+    Delete(userID, id int) (bool, error)
+    DeleteInvalid(id int, token string) error
+}
+
 type notificationRepository struct {
     db *sql.DB
 }
@@ -38,42 +81,22 @@ func NewNotificationRepository(db *sql.DB) NotificationRepository {
 }
 
 func (r *notificationRepository) GetByUserID(userID int) ([]*models.Notification, error) {
-    rows, err := r.db.Query(`
-        SELECT id, user_id, type, title, content, status, channel, created_at, sent_at, read_at
+    return r.query(`
+        SELECT id, user_id, type, title, content, status, channel, send_after, attempts, next_attempt_at, last_error, created_at, sent_at, read_at
         FROM notifications
         WHERE user_id = $1
         ORDER BY created_at DESC
         LIMIT 50
     `, userID)
-    if err != nil {
-        return nil, err
-    }
-    defer rows.Close()
-
-    var notifications []*models.Notification
-    for rows.Next() {
-        n := &models.Notification{}
-        err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Content, &n.Status, &n.Channel, &n.CreatedAt, &n.SentAt, &n.ReadAt)
-        if err != nil {
-            return nil, err
-        }
-        notifications = append(notifications, n)
-    }
-
-    if err := rows.Err(); err != nil {
-        return nil, err
-    }
-
-    return notifications, nil
 }
 
 func (r *notificationRepository) GetByID(id int) (*models.Notification, error) {
     n := &models.Notification{}
     err := r.db.QueryRow(`
-        SELECT id, user_id, type, title, content, status, channel, created_at, sent_at, read_at
+        SELECT id, user_id, type, title, content, status, channel, send_after, attempts, next_attempt_at, last_error, created_at, sent_at, read_at
         FROM notifications
         WHERE id = $1
-    `, id).Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Content, &n.Status, &n.Channel, &n.CreatedAt, &n.SentAt, &n.ReadAt)
+    `, id).Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Content, &n.Status, &n.Channel, &n.SendAfter, &n.Attempts, &n.NextAttemptAt, &n.LastError, &n.CreatedAt, &n.SentAt, &n.ReadAt)
     if err != nil {
         if err == sql.ErrNoRows {
             return nil, nil
@@ -84,19 +107,42 @@ func (r *notificationRepository) GetByID(id int) (*models.Notification, error) {
     return n, nil
 }
 
+// Create inserts a notification and NOTIFYs PendingChannel with its ID, so
+// dispatchers listening there can send it right away
 func (r *notificationRepository) Create(notification *models.Notification) (*models.Notification, error) {
-    err := r.db.QueryRow(`
-        INSERT INTO notifications (user_id, type, title, content, status, channel)
-        VALUES ($1, $2, $3, $4, $5, $6)
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    err = tx.QueryRow(`
+        INSERT INTO notifications (user_id, type, title, content, status, channel, send_after)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
         RETURNING id, created_at
-    `, notification.UserID, notification.Type, notification.Title, notification.Content, notification.Status, notification.Channel).Scan(&notification.ID, &notification.CreatedAt)
+    `, notification.UserID, notification.Type, notification.Title, notification.Content, notification.Status, notification.Channel, notification.SendAfter).Scan(&notification.ID, &notification.CreatedAt)
     if err != nil {
         return nil, err
     }
 
+    if err := notifyPending(tx, notification.ID); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
     return notification, nil
 }
 
+// notifyPending NOTIFYs PendingChannel with a notification's ID. Listeners
+// are told when tx commits.
+func notifyPending(tx *sql.Tx, id int) error {
+    _, err := tx.Exec(`SELECT pg_notify($1, $2)`, PendingChannel, strconv.Itoa(id))
+    return err
+}
+
 func (r *notificationRepository) UpdateStatus(id int, status string) error {
     _, err := r.db.Exec(`
         UPDATE notifications
@@ -123,14 +169,124 @@ func (r *notificationRepository) Delete(id int) error {
     return err
 }
 
-func (r *notificationRepository) GetPending() ([]*models.Notification, error) {
-    rows, err := r.db.Query(`
-        SELECT id, user_id, type, title, content, status, channel, created_at, sent_at, read_at
+// ClaimPending leases up to limit pending notifications that are due, not
+// waiting to be retried and not leased by anyone else, oldest first. Rows
+// being claimed concurrently are skipped rather than waited for, so
+// dispatchers never get the same notification. The lease lasts until
+// lease has passed; a notification whose attempt isn't recorded by then,
+// e.g. because its dispatcher crashed, can be claimed again. Each claimed
+// notification carries the lease's token for RecordAttempt.
+func (r *notificationRepository) ClaimPending(limit int, lease time.Duration) ([]*models.Notification, error) {
+    token, err := newLeaseToken()
+    if err != nil {
+        return nil, err
+    }
+
+    notifications, err := r.query(`
+        UPDATE notifications
+        SET leased_until = CURRENT_TIMESTAMP + make_interval(secs => $2), lease_token = $3
+        WHERE id IN (
+            SELECT id
+            FROM notifications
+            WHERE status = 'pending'
+                AND (send_after IS NULL OR send_after <= CURRENT_TIMESTAMP)
+                AND (next_attempt_at IS NULL OR next_attempt_at <= CURRENT_TIMESTAMP)
+                AND (leased_until IS NULL OR leased_until <= CURRENT_TIMESTAMP)
+            ORDER BY created_at ASC
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, user_id, type, title, content, status, channel, send_after, attempts, next_attempt_at, last_error, created_at, sent_at, read_at
+    `, limit, lease.Seconds(), token)
+    if err != nil {
+        return nil, err
+    }
+
+    sort.Slice(notifications, func(i, j int) bool {
+        return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+    })
+    for _, n := range notifications {
+        n.LeaseToken = token
+    }
+
+    return notifications, nil
+}
+
+// RecordAttempt counts a send attempt and stores its outcome: the new
+// status, the error if it failed, and when to try again if it will be
+// retried. The lease is released. It returns ErrLeaseLost, changing
+// nothing, if the lease identified by leaseToken has expired and the
+// notification was claimed again.
+func (r *notificationRepository) RecordAttempt(id int, leaseToken, status, lastError string, nextAttemptAt *time.Time) error {
+    result, err := r.db.Exec(`
+        UPDATE notifications
+        SET status = $1,
+            attempts = attempts + 1,
+            last_error = $2,
+            next_attempt_at = $3,
+            sent_at = CASE WHEN $1 = 'sent' THEN CURRENT_TIMESTAMP ELSE sent_at END,
+            leased_until = NULL,
+            lease_token = NULL
+        WHERE id = $4 AND lease_token = $5
+    `, status, lastError, nextAttemptAt, id, leaseToken)
+    if err != nil {
+        return err
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rowsAffected == 0 {
+        return ErrLeaseLost
+    }
+
+    return nil
+}
+
+// newLeaseToken returns a random token identifying one ClaimPending call
+func newLeaseToken() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// GetDeadLetters returns dead-lettered notifications, most recent first
+func (r *notificationRepository) GetDeadLetters(limit, offset int) ([]*models.Notification, error) {
+    return r.query(`
+        SELECT id, user_id, type, title, content, status, channel, send_after, attempts, next_attempt_at, last_error, created_at, sent_at, read_at
         FROM notifications
-        WHERE status = 'pending'
-        ORDER BY created_at ASC
-        LIMIT 100
-    `)
+        WHERE status = 'dead'
+        ORDER BY id DESC
+        LIMIT $1 OFFSET $2
+    `, limit, offset)
+}
+
+// Requeue puts a dead-lettered notification back to pending with its
+// attempts reset, so it is sent on the next run. It returns nil if there is
+// no dead-lettered notification with that ID.
+func (r *notificationRepository) Requeue(id int) (*models.Notification, error) {
+    n := &models.Notification{}
+    err := r.db.QueryRow(`
+        UPDATE notifications
+        SET status = 'pending', attempts = 0, next_attempt_at = NULL, leased_until = NULL, lease_token = NULL
+        WHERE id = $1 AND status = 'dead'
+        RETURNING id, user_id, type, title, content, status, channel, send_after, attempts, next_attempt_at, last_error, created_at, sent_at, read_at
+    `, id).Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Content, &n.Status, &n.Channel, &n.SendAfter, &n.Attempts, &n.NextAttemptAt, &n.LastError, &n.CreatedAt, &n.SentAt, &n.ReadAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return n, nil
+}
+
+func (r *notificationRepository) query(query string, args ...interface{}) ([]*models.Notification, error) {
+    rows, err := r.db.Query(query, args...)
     if err != nil {
         return nil, err
     }
@@ -139,7 +295,7 @@ func (r *notificationRepository) GetPending() ([]*models.Notification, error) {
     var notifications []*models.Notification
     for rows.Next() {
         n := &models.Notification{}
-        err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Content, &n.Status, &n.Channel, &n.CreatedAt, &n.SentAt, &n.ReadAt)
+        err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Content, &n.Status, &n.Channel, &n.SendAfter, &n.Attempts, &n.NextAttemptAt, &n.LastError, &n.CreatedAt, &n.SentAt, &n.ReadAt)
         if err != nil {
             return nil, err
         }
@@ -153,6 +309,68 @@ func (r *notificationRepository) GetPending() ([]*models.Notification, error) {
     return notifications, nil
 }
 
+// CreateForEvent records the event as processed and inserts the
+// notifications it produced in one transaction. It returns false, inserting
+// nothing, if the event was processed before, so a redelivered event never
+// produces its notifications twice.
+func (r *notificationRepository) CreateForEvent(event *models.Event, notifications []*models.Notification) (bool, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        INSERT INTO processed_events (event_id, event_type)
+        VALUES ($1, $2)
+        ON CONFLICT (event_id) DO NOTHING
+    `, event.ID, event.Type)
+    if err != nil {
+        return false, err
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    if rowsAffected == 0 {
+        return false, nil
+    }
+
+    for _, n := range notifications {
+        err := tx.QueryRow(`
+            INSERT INTO notifications (user_id, type, title, content, status, channel, send_after, event_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+            RETURNING id, created_at
+        `, n.UserID, n.Type, n.Title, n.Content, n.Status, n.Channel, n.SendAfter, event.ID).Scan(&n.ID, &n.CreatedAt)
+        if err != nil {
+            return false, err
+        }
+        if err := notifyPending(tx, n.ID); err != nil {
+            return false, err
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
+
+// DeleteProcessedEvents forgets events processed before the given time.
+// Redeliveries of those events are no longer recognised.
+func (r *notificationRepository) DeleteProcessedEvents(before time.Time) (int64, error) {
+    result, err := r.db.Exec(`
+        DELETE FROM processed_events
+        WHERE processed_at < $1
+    `, before)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
 type templateRepository struct {
     db *sql.DB
 }
@@ -258,6 +476,119 @@ func (r *templateRepository) Delete(id int) error {
     return err
 }
 
+type ruleRepository struct {
+    db *sql.DB
+}
+
+func NewRuleRepository(db *sql.DB) RuleRepository {
+    return &ruleRepository{db: db}
+}
+
+func (r *ruleRepository) GetAll() ([]*models.Rule, error) {
+    return r.query(`
+        SELECT id, event_type, template_name, channel, delay_seconds, enabled, created_at, updated_at
+        FROM notification_rules
+        ORDER BY event_type ASC, id ASC
+    `)
+}
+
+func (r *ruleRepository) GetByID(id int) (*models.Rule, error) {
+    rule := &models.Rule{}
+    err := r.db.QueryRow(`
+        SELECT id, event_type, template_name, channel, delay_seconds, enabled, created_at, updated_at
+        FROM notification_rules
+        WHERE id = $1
+    `, id).Scan(&rule.ID, &rule.EventType, &rule.TemplateName, &rule.Channel, &rule.DelaySeconds, &rule.Enabled, &rule.CreatedAt, &rule.UpdatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return rule, nil
+}
+
+// GetByEventType returns the enabled rules for an event type
+func (r *ruleRepository) GetByEventType(eventType string) ([]*models.Rule, error) {
+    return r.query(`
+        SELECT id, event_type, template_name, channel, delay_seconds, enabled, created_at, updated_at
+        FROM notification_rules
+        WHERE event_type = $1 AND enabled
+        ORDER BY id ASC
+    `, eventType)
+}
+
+func (r *ruleRepository) query(query string, args ...interface{}) ([]*models.Rule, error) {
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var rules []*models.Rule
+    for rows.Next() {
+        rule := &models.Rule{}
+        err := rows.Scan(&rule.ID, &rule.EventType, &rule.TemplateName, &rule.Channel, &rule.DelaySeconds, &rule.Enabled, &rule.CreatedAt, &rule.UpdatedAt)
+        if err != nil {
+            return nil, err
+        }
+        rules = append(rules, rule)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return rules, nil
+}
+
+func (r *ruleRepository) Create(rule *models.Rule) (*models.Rule, error) {
+    err := r.db.QueryRow(`
+        INSERT INTO notification_rules (event_type, template_name, channel, delay_seconds, enabled)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at, updated_at
+    `, rule.EventType, rule.TemplateName, rule.Channel, rule.DelaySeconds, rule.Enabled).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+    if err != nil {
+        return nil, mapRuleError(err)
+    }
+
+    return rule, nil
+}
+
+func (r *ruleRepository) Update(rule *models.Rule) (*models.Rule, error) {
+    err := r.db.QueryRow(`
+        UPDATE notification_rules
+        SET event_type = $1, template_name = $2, channel = $3, delay_seconds = $4, enabled = $5, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $6
+        RETURNING created_at, updated_at
+    `, rule.EventType, rule.TemplateName, rule.Channel, rule.DelaySeconds, rule.Enabled, rule.ID).Scan(&rule.CreatedAt, &rule.UpdatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, mapRuleError(err)
+    }
+
+    return rule, nil
+}
+
+func mapRuleError(err error) error {
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+        return ErrRuleExists
+    }
+    return err
+}
+
+func (r *ruleRepository) Delete(id int) error {
+    _, err := r.db.Exec(`
+        DELETE FROM notification_rules
+        WHERE id = $1
+    `, id)
+    return err
+}
+
 type preferenceRepository struct {
     db *sql.DB
 }
@@ -332,3 +663,153 @@ func (r *preferenceRepository) CreateOrUpdate(preference *models.Preference) (*m
 
     return preference, nil
 }
+
+type deviceRepository struct {
+    db *sql.DB
+}
+
+func NewDeviceRepository(db *sql.DB) DeviceRepository {
+    return &deviceRepository{db: db}
+}
+
+func (r *deviceRepository) GetByUserID(userID int) ([]*models.Device, error) {
+    rows, err := r.db.Query(`
+        SELECT id, user_id, platform, token, app_version, created_at, last_seen_at
+        FROM devices
+        WHERE user_id = $1
+        ORDER BY last_seen_at DESC
+    `, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var devices []*models.Device
+    for rows.Next() {
+        d := &models.Device{}
+        if err := rows.Scan(&d.ID, &d.UserID, &d.Platform, &d.Token, &d.AppVersion, &d.CreatedAt, &d.LastSeenAt); err != nil {
+            return nil, err
+        }
+        devices = append(devices, d)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return devices, nil
+}
+
+// GetActiveByUserID returns the user's devices that were registered or
+// refreshed at or after seenSince
+func (r *deviceRepository) GetActiveByUserID(userID int, seenSince time.Time) ([]*models.Device, error) {
+    rows, err := r.db.Query(`
+        SELECT id, user_id, platform, token, app_version, created_at, last_seen_at
+        FROM devices
+        WHERE user_id = $1 AND last_seen_at >= $2
+        ORDER BY last_seen_at DESC
+    `, userID, seenSince)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var devices []*models.Device
+    for rows.Next() {
+        d := &models.Device{}
+        if err := rows.Scan(&d.ID, &d.UserID, &d.Platform, &d.Token, &d.AppVersion, &d.CreatedAt, &d.LastSeenAt); err != nil {
+            return nil, err
+        }
+        devices = append(devices, d)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return devices, nil
+}
+
+// Register adds the device, or updates it if its token is already
+// registered. A token registered to another user moves to this one, as when
+// someone else signs in to the app on that device.
+func (r *deviceRepository) Register(device *models.Device) (*models.Device, error) {
+    err := r.db.QueryRow(`
+        INSERT INTO devices (user_id, platform, token, app_version)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (token) DO UPDATE
+        SET user_id = EXCLUDED.user_id, platform = EXCLUDED.platform,
+            app_version = EXCLUDED.app_version, last_seen_at = CURRENT_TIMESTAMP
+        RETURNING id, created_at, last_seen_at
+    `, device.UserID, device.Platform, device.Token, device.AppVersion).Scan(&device.ID, &device.CreatedAt, &device.LastSeenAt)
+    if err != nil {
+        return nil, err
+    }
+
+    return device, nil
+}
+
+// Refresh marks the user's device as seen, replacing its token and app
+// version with those of device that are set. Another registration of the
+// new token is removed, since the token now belongs to this device.
+func (r *deviceRepository) Refresh(device *models.Device) (*models.Device, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if device.Token != "" {
+        if _, err := tx.Exec(`
+            DELETE FROM devices
+            WHERE token = $1 AND id <> $2
+        `, device.Token, device.ID); err != nil {
+            return nil, err
+        }
+    }
+
+    d := &models.Device{}
+    err = tx.QueryRow(`
+        UPDATE devices
+        SET token = COALESCE(NULLIF($3, ''), token),
+            app_version = COALESCE(NULLIF($4, ''), app_version),
+            last_seen_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND user_id = $2
+        RETURNING id, user_id, platform, token, app_version, created_at, last_seen_at
+    `, device.ID, device.UserID, device.Token, device.AppVersion).Scan(&d.ID, &d.UserID, &d.Platform, &d.Token, &d.AppVersion, &d.CreatedAt, &d.LastSeenAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return d, nil
+}
+
+// Delete unregisters the user's device. It reports whether there was one.
+func (r *deviceRepository) Delete(userID, id int) (bool, error) {
+    res, err := r.db.Exec(`
+        DELETE FROM devices
+        WHERE id = $1 AND user_id = $2
+    `, id, userID)
+    if err != nil {
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
+}
+
+// DeleteInvalid removes a device whose token a push provider rejected,
+// unless the token was refreshed since
+func (r *deviceRepository) DeleteInvalid(id int, token string) error {
+    _, err := r.db.Exec(`
+        DELETE FROM devices
+        WHERE id = $1 AND token = $2
+    `, id, token)
+    return err
+}
@@ -0,0 +1,236 @@
+// notification-service/internal/services/apns.go
+
+package services
+
+import (
+    "bytes"
+    "crypto/ecdsa"
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/rs/zerolog/log"
+)
+
+const (
+    defaultAPNsEndpoint = "https://api.push.apple.com"
+    defaultAPNsTimeout  = 10 * time.Second
+
+    // providerTokenLifetime is how long a provider token is reused. APNs
+    // rejects tokens older than an hour and throttles providers that replace
+    // them more often than every 20 minutes.
+    providerTokenLifetime = 40 * time.Minute
+)
+
+// APNsPushService sends push notifications to iOS devices through the APNs
+// HTTP/2 API, authenticating with ES256 provider tokens signed by a .p8 key
+type APNsPushService struct {
+    keyID    string
+    teamID   string
+    key      *ecdsa.PrivateKey
+    topic    string
+    endpoint string
+    client   *http.Client
+
+    mu            sync.Mutex
+    providerToken string
+    issuedAt      time.Time
+}
+
+// NewAPNsPushService loads the signing key named in the config. Without
+// one, every send fails permanently.
+func NewAPNsPushService(cfg config.APNsConfig) (*APNsPushService, error) {
+    s := &APNsPushService{
+        keyID:    cfg.KeyID,
+        teamID:   cfg.TeamID,
+        topic:    cfg.Topic,
+        endpoint: strings.TrimRight(cfg.Endpoint, "/"),
+        client: &http.Client{
+            Timeout: cfg.Timeout,
+            Transport: &http.Transport{
+                Proxy:             http.ProxyFromEnvironment,
+                ForceAttemptHTTP2: true,
+                TLSClientConfig:   &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+            },
+        },
+    }
+    if s.endpoint == "" {
+        s.endpoint = defaultAPNsEndpoint
+    }
+    if cfg.Timeout <= 0 {
+        s.client.Timeout = defaultAPNsTimeout
+    }
+
+    if cfg.KeyFile == "" {
+        return s, nil
+    }
+
+    data, err := os.ReadFile(cfg.KeyFile)
+    if err != nil {
+        return nil, fmt.Errorf("apns: failed to read key: %w", err)
+    }
+    key, err := jwt.ParseECPrivateKeyFromPEM(data)
+    if err != nil {
+        return nil, fmt.Errorf("apns: failed to parse key: %w", err)
+    }
+    if s.keyID == "" || s.teamID == "" || s.topic == "" {
+        return nil, errors.New("apns: key ID, team ID and topic are required")
+    }
+    s.key = key
+
+    return s, nil
+}
+
+// apnsHeaders returns the APNs headers for the message's collapse ID,
+// priority and expiration, leaving out those that aren't set
+func apnsHeaders(msg *PushMessage) map[string]string {
+    headers := map[string]string{}
+    if msg.CollapseID != "" {
+        headers["apns-collapse-id"] = msg.CollapseID
+    }
+    switch msg.Priority {
+    case PriorityHigh:
+        headers["apns-priority"] = "10"
+    case PriorityNormal:
+        headers["apns-priority"] = "5"
+    }
+    if !msg.Expiration.IsZero() {
+        headers["apns-expiration"] = strconv.FormatInt(msg.Expiration.Unix(), 10)
+    }
+    return headers
+}
+
+// APNsError is an error response from APNs. Reason is the APNs reason, such
+// as BadDeviceToken, or the HTTP status if there is none.
+type APNsError struct {
+    StatusCode int
+    Reason     string
+}
+
+func (e *APNsError) Error() string {
+    return fmt.Sprintf("apns: %d %s", e.StatusCode, e.Reason)
+}
+
+// Send sends msg to the device with the given token. A message without a
+// title or body is sent as a background notification carrying only its
+// data, which APNs requires to have normal priority. A token APNs no longer
+// accepts fails with an error wrapping ErrInvalidToken. Throttling and
+// server errors are retryable; other rejections are permanent.
+func (s *APNsPushService) Send(token string, msg *PushMessage) error {
+    if s.key == nil {
+        return Permanent(errors.New("apns: key not configured"))
+    }
+
+    payload := map[string]interface{}{}
+    for k, v := range msg.Data {
+        payload[k] = v
+    }
+    headers := apnsHeaders(msg)
+    if msg.Title != "" || msg.Body != "" {
+        headers["apns-push-type"] = "alert"
+        payload["aps"] = map[string]interface{}{
+            "alert": map[string]string{"title": msg.Title, "body": msg.Body},
+        }
+    } else {
+        headers["apns-push-type"] = "background"
+        headers["apns-priority"] = "5"
+        payload["aps"] = map[string]interface{}{"content-available": 1}
+    }
+    headers["apns-topic"] = s.topic
+
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return Permanent(err)
+    }
+
+    providerToken, err := s.token()
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest(http.MethodPost, s.endpoint+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+    if err != nil {
+        return Permanent(err)
+    }
+    req.Header.Set("Authorization", "bearer "+providerToken)
+    req.Header.Set("Content-Type", "application/json")
+    for k, v := range headers {
+        req.Header.Set(k, v)
+    }
+
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("apns: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusOK {
+        io.Copy(io.Discard, resp.Body)
+        return nil
+    }
+
+    apnsErr := &APNsError{StatusCode: resp.StatusCode, Reason: http.StatusText(resp.StatusCode)}
+    var errResp struct {
+        Reason string `json:"reason"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&errResp); err == nil && errResp.Reason != "" {
+        apnsErr.Reason = errResp.Reason
+    }
+
+    switch apnsErr.Reason {
+    case "BadDeviceToken", "Unregistered":
+        return Permanent(fmt.Errorf("%w: %v", ErrInvalidToken, apnsErr))
+    case "DeviceTokenNotForTopic":
+        // The token is fine but belongs to another app, so the topic is
+        // misconfigured and the device must be kept
+        log.Error().Str("topic", s.topic).Msg("APNs rejected a token for the configured topic; check PUSH_APNS_TOPIC")
+        return Permanent(apnsErr)
+    case "ExpiredProviderToken":
+        // Sign a new token next time
+        s.mu.Lock()
+        s.providerToken = ""
+        s.mu.Unlock()
+        return apnsErr
+    }
+    if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+        return apnsErr
+    }
+    return Permanent(apnsErr)
+}
+
+// token returns the cached provider token, signing a new one when there is
+// none or it is due to be replaced
+func (s *APNsPushService) token() (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.providerToken != "" && time.Since(s.issuedAt) < providerTokenLifetime {
+        return s.providerToken, nil
+    }
+
+    now := time.Now()
+    token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+        "iss": s.teamID,
+        "iat": now.Unix(),
+    })
+    token.Header["kid"] = s.keyID
+    signed, err := token.SignedString(s.key)
+    if err != nil {
+        return "", Permanent(fmt.Errorf("apns: failed to sign provider token: %w", err))
+    }
+
+    s.providerToken = signed
+    s.issuedAt = now
+    return signed, nil
+}
@@ -0,0 +1,145 @@
+// notification-service/internal/services/dispatcher.go
+
+package services
+
+import (
+    "context"
+    "fmt"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/models"
+    "runtime/debug"
+    "sync"
+    "time"
+
+    "github.com/rs/zerolog/log"
+)
+
+// Dispatcher sends pending notifications in the background by running
+// NotificationService.SendPending in a loop, and keeps a status report of
+// what it has done.
+type Dispatcher struct {
+    service NotificationService
+    pool    *Pool
+    retry   RetryPolicy
+    cfg     config.DispatcherConfig
+
+    wake chan struct{}
+
+    mu     sync.Mutex
+    status models.DispatcherStatus
+}
+
+func NewDispatcher(service NotificationService, cfg config.DispatcherConfig) *Dispatcher {
+    return &Dispatcher{
+        service: service,
+        pool:    NewPool(cfg.Workers, cfg.Concurrency),
+        retry: RetryPolicy{
+            MaxAttempts: cfg.MaxAttempts,
+            BaseDelay:   cfg.RetryBaseDelay,
+            MaxDelay:    cfg.RetryMaxDelay,
+        },
+        cfg:  cfg,
+        wake: make(chan struct{}, 1),
+    }
+}
+
+// Run sends pending notifications until ctx is cancelled. It polls every
+// PollInterval, runs as soon as Wake is called, and goes straight on after
+// a full batch that sent without failures. A panic is logged and the loop
+// restarted after PollInterval. Once ctx is cancelled, Run returns as soon
+// as the sends already under way have finished; notifications not started
+// yet stay pending.
+func (d *Dispatcher) Run(ctx context.Context) {
+    d.update(func(s *models.DispatcherStatus) { s.Running = true })
+    defer d.update(func(s *models.DispatcherStatus) { s.Running = false })
+
+    for !d.loop(ctx) {
+        d.update(func(s *models.DispatcherStatus) { s.Restarts++ })
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(d.cfg.PollInterval):
+        }
+    }
+}
+
+// Wake makes the dispatcher run now instead of at its next poll. If a run
+// is under way, another one follows it.
+func (d *Dispatcher) Wake() {
+    select {
+    case d.wake <- struct{}{}:
+    default:
+    }
+}
+
+// Status returns a snapshot of the dispatcher's status
+func (d *Dispatcher) Status() models.DispatcherStatus {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    return d.status
+}
+
+// loop runs batches until ctx is cancelled, returning true, or until a
+// batch panics, returning false
+func (d *Dispatcher) loop(ctx context.Context) (stopped bool) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Error().Interface("panic", r).Str("stack", string(debug.Stack())).Msg("Dispatcher panicked, restarting")
+            d.recordError(fmt.Errorf("panic: %v", r))
+            stopped = false
+        }
+    }()
+
+    for {
+        if ctx.Err() != nil {
+            return true
+        }
+
+        start := time.Now()
+        result, err := d.service.SendPending(ctx, d.pool, d.cfg.BatchSize, d.cfg.LeaseDuration, d.retry)
+        d.update(func(s *models.DispatcherStatus) {
+            s.Runs++
+            s.LastRunAt = &start
+            s.LastRunDuration = time.Since(start).String()
+            if result != nil {
+                s.Processed += int64(result.Processed)
+                s.Sent += int64(result.Sent)
+                s.Failed += int64(result.Failed)
+                s.DeadLettered += int64(result.DeadLettered)
+            }
+        })
+
+        wait := d.cfg.PollInterval
+        if err != nil {
+            log.Error().Err(err).Msg("Failed to send pending notifications")
+            d.recordError(err)
+        } else if result.Processed == d.cfg.BatchSize && result.Failed == 0 {
+            wait = 0
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return true
+        case <-d.wake:
+            timer.Stop()
+        case <-timer.C:
+        }
+    }
+}
+
+func (d *Dispatcher) recordError(err error) {
+    now := time.Now()
+    d.update(func(s *models.DispatcherStatus) {
+        s.Errors++
+        s.LastError = err.Error()
+        s.LastErrorAt = &now
+    })
+}
+
+func (d *Dispatcher) update(fn func(s *models.DispatcherStatus)) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    fn(&d.status)
+}
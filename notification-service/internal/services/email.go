@@ -0,0 +1,43 @@
+// notification-service/internal/services/email.go
+
+package services
+
+import (
+    "bytes"
+    "fmt"
+    "html/template"
+    "movie-microservices/notification-service/internal/models"
+    "strings"
+    texttemplate "text/template"
+)
+
+// renderEmail renders a template's content twice: as text, and as HTML with
+// the data escaped
+func renderEmail(tmpl *models.Template, data interface{}) (text, html string, err error) {
+    t, err := texttemplate.New("email").Parse(tmpl.Content)
+    if err != nil {
+        return "", "", fmt.Errorf("failed to parse email template: %w", err)
+    }
+    var textOut bytes.Buffer
+    if err := t.Execute(&textOut, data); err != nil {
+        return "", "", fmt.Errorf("failed to execute email template: %w", err)
+    }
+
+    h, err := template.New("email").Parse(tmpl.Content)
+    if err != nil {
+        return "", "", fmt.Errorf("failed to parse email template: %w", err)
+    }
+    var htmlOut bytes.Buffer
+    if err := h.Execute(&htmlOut, data); err != nil {
+        return "", "", fmt.Errorf("failed to execute email template: %w", err)
+    }
+
+    return textOut.String(), htmlOut.String(), nil
+}
+
+// htmlFromText turns plain text into HTML that reads the same: escaped,
+// with its line breaks kept
+func htmlFromText(text string) string {
+    escaped := template.HTMLEscapeString(text)
+    return strings.ReplaceAll(escaped, "\n", "<br>\n")
+}
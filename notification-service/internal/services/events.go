@@ -0,0 +1,122 @@
+// notification-service/internal/services/events.go
+
+package services
+
+import (
+    "bytes"
+    "context"
+    "movie-microservices/notification-service/internal/models"
+    "strconv"
+    "text/template"
+    "time"
+
+    "github.com/rs/zerolog/log"
+)
+
+// HandleEvent creates the notifications the enabled rules for the event's
+// type ask for. Each rule's template is rendered with the event: its subject
+// becomes the title and its content the body, and the notification waits
+// until the rule's delay after the event occurred. A rule whose template is
+// missing or fails to render is logged and skipped. The notifications are
+// stored together with the event ID, so an event that is delivered again
+// creates nothing. An error means nothing was stored and the event should
+// be retried.
+func (s *notificationService) HandleEvent(event *models.Event) error {
+    if event.UserID == 0 {
+        log.Debug().Str("eventID", event.ID).Str("type", event.Type).Msg("Skipping event without a user")
+        return nil
+    }
+
+    rules, err := s.ruleRepo.GetByEventType(event.Type)
+    if err != nil {
+        return err
+    }
+
+    data := eventTemplateData(event)
+
+    var notifications []*models.Notification
+    for _, rule := range rules {
+        tmpl, err := s.templateRepo.GetByNameAndType(rule.TemplateName, rule.Channel)
+        if err != nil {
+            return err
+        }
+        if tmpl == nil {
+            log.Warn().Int("ruleID", rule.ID).Str("template", rule.TemplateName).Str("channel", rule.Channel).Msg("Rule template not found")
+            continue
+        }
+
+        title, err := renderTemplate(tmpl.Subject, data)
+        if err != nil {
+            log.Warn().Err(err).Int("ruleID", rule.ID).Str("eventID", event.ID).Msg("Failed to render rule template subject")
+            continue
+        }
+        content, err := renderTemplate(tmpl.Content, data)
+        if err != nil {
+            log.Warn().Err(err).Int("ruleID", rule.ID).Str("eventID", event.ID).Msg("Failed to render rule template content")
+            continue
+        }
+
+        sendAfter := event.OccurredAt.Add(time.Duration(rule.DelaySeconds) * time.Second)
+        notifications = append(notifications, &models.Notification{
+            UserID:    event.UserID,
+            Type:      rule.TemplateName,
+            Title:     title,
+            Content:   content,
+            Status:    "pending",
+            Channel:   rule.Channel,
+            SendAfter: &sendAfter,
+        })
+    }
+
+    if len(notifications) == 0 {
+        return nil
+    }
+
+    created, err := s.notificationRepo.CreateForEvent(event, notifications)
+    if err != nil {
+        return err
+    }
+    if !created {
+        log.Debug().Str("eventID", event.ID).Msg("Skipping event that was already processed")
+        return nil
+    }
+
+    // Invalidate cache
+    ctx := context.Background()
+    cacheKey := "notifications:" + strconv.Itoa(event.UserID)
+    s.redis.Del(ctx, cacheKey)
+
+    return nil
+}
+
+// eventTemplateData is what rule templates are rendered with, e.g.
+// {{.MovieID}} or {{.Data.listId}}
+func eventTemplateData(event *models.Event) map[string]interface{} {
+    data := event.Data
+    if data == nil {
+        data = map[string]interface{}{}
+    }
+    return map[string]interface{}{
+        "EventID":    event.ID,
+        "EventType":  event.Type,
+        "UserID":     event.UserID,
+        "MovieID":    event.MovieID,
+        "OccurredAt": event.OccurredAt,
+        "Data":       data,
+    }
+}
+
+// renderTemplate executes text as a template, failing on fields the data
+// doesn't have rather than rendering "<no value>"
+func renderTemplate(text string, data interface{}) (string, error) {
+    tmpl, err := template.New("rule").Option("missingkey=error").Parse(text)
+    if err != nil {
+        return "", err
+    }
+
+    var out bytes.Buffer
+    if err := tmpl.Execute(&out, data); err != nil {
+        return "", err
+    }
+    return out.String(), nil
+}
@@ -0,0 +1,315 @@
+// notification-service/internal/services/fcm.go
+
+package services
+
+import (
+    "bytes"
+    "crypto/rsa"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/rs/zerolog/log"
+)
+
+const (
+    defaultFCMBaseURL = "https://fcm.googleapis.com"
+    defaultFCMTimeout = 10 * time.Second
+
+    fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+    // tokenExpiryMargin is how long before its expiry an access token is
+    // replaced, so it doesn't expire mid-request
+    tokenExpiryMargin = time.Minute
+)
+
+// serviceAccount holds the fields of a Google service account JSON key
+// needed to mint access tokens
+type serviceAccount struct {
+    ProjectID    string `json:"project_id"`
+    PrivateKeyID string `json:"private_key_id"`
+    PrivateKey   string `json:"private_key"`
+    ClientEmail  string `json:"client_email"`
+    TokenURI     string `json:"token_uri"`
+}
+
+// FCMPushService sends push notifications through the FCM HTTP v1 API,
+// authenticating with OAuth2 access tokens minted from a service account
+// key. Tokens are cached until shortly before they expire.
+type FCMPushService struct {
+    projectID string
+    email     string
+    keyID     string
+    key       *rsa.PrivateKey
+    tokenURL  string
+    baseURL   string
+    client    *http.Client
+
+    mu          sync.Mutex
+    accessToken string
+    expiresAt   time.Time
+}
+
+// NewFCMPushService loads the service account key named in the config.
+// Without one, every send fails permanently.
+func NewFCMPushService(cfg config.FCMConfig) (*FCMPushService, error) {
+    s := &FCMPushService{
+        projectID: cfg.ProjectID,
+        tokenURL:  cfg.TokenURL,
+        baseURL:   strings.TrimRight(cfg.BaseURL, "/"),
+        client:    &http.Client{Timeout: cfg.Timeout},
+    }
+    if s.baseURL == "" {
+        s.baseURL = defaultFCMBaseURL
+    }
+    if cfg.Timeout <= 0 {
+        s.client.Timeout = defaultFCMTimeout
+    }
+
+    if cfg.CredentialsFile == "" {
+        return s, nil
+    }
+
+    data, err := os.ReadFile(cfg.CredentialsFile)
+    if err != nil {
+        return nil, fmt.Errorf("fcm: failed to read credentials: %w", err)
+    }
+    var account serviceAccount
+    if err := json.Unmarshal(data, &account); err != nil {
+        return nil, fmt.Errorf("fcm: failed to parse credentials: %w", err)
+    }
+    key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+    if err != nil {
+        return nil, fmt.Errorf("fcm: failed to parse credentials private key: %w", err)
+    }
+
+    s.email = account.ClientEmail
+    s.keyID = account.PrivateKeyID
+    s.key = key
+    if s.projectID == "" {
+        s.projectID = account.ProjectID
+    }
+    if s.tokenURL == "" {
+        s.tokenURL = account.TokenURI
+    }
+    if s.projectID == "" || s.tokenURL == "" {
+        return nil, errors.New("fcm: credentials have no project_id or token_uri")
+    }
+
+    return s, nil
+}
+
+type fcmMessage struct {
+    Message fcmMessageBody `json:"message"`
+}
+
+type fcmMessageBody struct {
+    Token        string            `json:"token"`
+    Notification *fcmNotification  `json:"notification,omitempty"`
+    Data         map[string]string `json:"data,omitempty"`
+    Android      *fcmAndroid       `json:"android,omitempty"`
+    APNs         *fcmAPNs          `json:"apns,omitempty"`
+}
+
+type fcmAndroid struct {
+    CollapseKey string `json:"collapse_key,omitempty"`
+    Priority    string `json:"priority,omitempty"`
+    TTL         string `json:"ttl,omitempty"`
+}
+
+type fcmAPNs struct {
+    Headers map[string]string `json:"headers"`
+}
+
+type fcmNotification struct {
+    Title string `json:"title,omitempty"`
+    Body  string `json:"body,omitempty"`
+}
+
+type fcmErrorResponse struct {
+    Error struct {
+        Code    int    `json:"code"`
+        Message string `json:"message"`
+        Status  string `json:"status"`
+        Details []struct {
+            Type      string `json:"@type"`
+            ErrorCode string `json:"errorCode"`
+        } `json:"details"`
+    } `json:"error"`
+}
+
+// FCMError is an error response from the FCM API. Code is the FCM error
+// code, such as UNREGISTERED, or the API status if there is none.
+type FCMError struct {
+    StatusCode int
+    Code       string
+    Message    string
+}
+
+func (e *FCMError) Error() string {
+    return fmt.Sprintf("fcm: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Send sends msg to the device with the given registration token. A token
+// FCM no longer accepts fails with an error wrapping ErrInvalidToken.
+// Quota, availability and internal errors are retryable; other rejections
+// are permanent.
+func (s *FCMPushService) Send(token string, msg *PushMessage) error {
+    if s.key == nil {
+        return Permanent(errors.New("fcm: credentials not configured"))
+    }
+
+    body := fcmMessage{Message: fcmMessageBody{Token: token, Data: msg.Data}}
+    if msg.Title != "" || msg.Body != "" {
+        body.Message.Notification = &fcmNotification{Title: msg.Title, Body: msg.Body}
+    }
+    if msg.CollapseID != "" || msg.Priority != "" || !msg.Expiration.IsZero() {
+        // FCM passes these on to Android devices and APNs in their own terms
+        android := &fcmAndroid{CollapseKey: msg.CollapseID}
+        apns := &fcmAPNs{Headers: apnsHeaders(msg)}
+        switch msg.Priority {
+        case PriorityHigh:
+            android.Priority = "HIGH"
+        case PriorityNormal:
+            android.Priority = "NORMAL"
+        }
+        if !msg.Expiration.IsZero() {
+            ttl := time.Until(msg.Expiration)
+            if ttl < 0 {
+                ttl = 0
+            }
+            android.TTL = fmt.Sprintf("%ds", int64(ttl/time.Second))
+        }
+        body.Message.Android = android
+        body.Message.APNs = apns
+    }
+    payload, err := json.Marshal(body)
+    if err != nil {
+        return Permanent(err)
+    }
+
+    accessToken, err := s.token()
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest(http.MethodPost, s.baseURL+"/v1/projects/"+url.PathEscape(s.projectID)+"/messages:send", bytes.NewReader(payload))
+    if err != nil {
+        return Permanent(err)
+    }
+    req.Header.Set("Authorization", "Bearer "+accessToken)
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("fcm: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusOK {
+        io.Copy(io.Discard, resp.Body)
+        return nil
+    }
+
+    fcmErr := &FCMError{StatusCode: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+    var errResp fcmErrorResponse
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&errResp); err == nil {
+        fcmErr.Message = errResp.Error.Message
+        if errResp.Error.Status != "" {
+            fcmErr.Code = errResp.Error.Status
+        }
+        for _, d := range errResp.Error.Details {
+            if d.ErrorCode != "" {
+                fcmErr.Code = d.ErrorCode
+            }
+        }
+    }
+
+    switch fcmErr.Code {
+    case "UNREGISTERED":
+        return Permanent(fmt.Errorf("%w: %v", ErrInvalidToken, fcmErr))
+    case "SENDER_ID_MISMATCH":
+        // The token belongs to another Firebase project, so the project is
+        // misconfigured and the device must be kept
+        log.Error().Str("projectID", s.projectID).Msg("FCM rejected a token registered with another sender; check the FCM project and credentials")
+        return Permanent(fcmErr)
+    case "QUOTA_EXCEEDED", "UNAVAILABLE", "INTERNAL":
+        return fcmErr
+    }
+    switch {
+    case resp.StatusCode == http.StatusUnauthorized:
+        // The access token may have been revoked; mint a new one next time
+        s.mu.Lock()
+        s.accessToken = ""
+        s.mu.Unlock()
+        return fcmErr
+    case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+        return fcmErr
+    }
+    return Permanent(fcmErr)
+}
+
+// token returns a cached access token, minting a new one when there is none
+// or it is about to expire
+func (s *FCMPushService) token() (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.accessToken != "" && time.Now().Before(s.expiresAt.Add(-tokenExpiryMargin)) {
+        return s.accessToken, nil
+    }
+
+    now := time.Now()
+    assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+        "iss":   s.email,
+        "scope": fcmScope,
+        "aud":   s.tokenURL,
+        "iat":   now.Unix(),
+        "exp":   now.Add(time.Hour).Unix(),
+    })
+    assertion.Header["kid"] = s.keyID
+    signed, err := assertion.SignedString(s.key)
+    if err != nil {
+        return "", Permanent(fmt.Errorf("fcm: failed to sign token request: %w", err))
+    }
+
+    resp, err := s.client.PostForm(s.tokenURL, url.Values{
+        "grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+        "assertion":  {signed},
+    })
+    if err != nil {
+        return "", fmt.Errorf("fcm: failed to get access token: %w", err)
+    }
+    defer resp.Body.Close()
+
+    var tokenResp struct {
+        AccessToken      string `json:"access_token"`
+        ExpiresIn        int    `json:"expires_in"`
+        Error            string `json:"error"`
+        ErrorDescription string `json:"error_description"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&tokenResp); err != nil && resp.StatusCode == http.StatusOK {
+        return "", fmt.Errorf("fcm: failed to decode access token: %w", err)
+    }
+    if resp.StatusCode != http.StatusOK || tokenResp.AccessToken == "" {
+        err := fmt.Errorf("fcm: failed to get access token: %d %s %s", resp.StatusCode, tokenResp.Error, tokenResp.ErrorDescription)
+        if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+            // A rejected service account won't be accepted on retry
+            return "", Permanent(err)
+        }
+        return "", err
+    }
+
+    s.accessToken = tokenResp.AccessToken
+    s.expiresAt = now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+    return s.accessToken, nil
+}
@@ -0,0 +1,84 @@
+// notification-service/internal/services/pool.go
+
+package services
+
+import (
+    "context"
+    "runtime/debug"
+    "sync"
+
+    "github.com/rs/zerolog/log"
+)
+
+// Pool runs jobs grouped by channel, with at most workers jobs running at
+// once overall and at most limits[channel] for any one channel. A channel
+// without a limit runs one job at a time. Channels draw from the shared
+// workers independently, so a slow channel doesn't hold up the others
+// beyond its own limit.
+type Pool struct {
+    workers chan struct{}
+    limits  map[string]int
+}
+
+func NewPool(workers int, limits map[string]int) *Pool {
+    if workers < 1 {
+        workers = 1
+    }
+    return &Pool{
+        workers: make(chan struct{}, workers),
+        limits:  limits,
+    }
+}
+
+// Run runs the jobs and returns once they have all finished. After ctx is
+// cancelled no further job starts; jobs already running are finished.
+func (p *Pool) Run(ctx context.Context, jobs map[string][]func()) {
+    var wg sync.WaitGroup
+    for channel, queue := range jobs {
+        limit := p.limits[channel]
+        if limit < 1 {
+            limit = 1
+        }
+        if limit > len(queue) {
+            limit = len(queue)
+        }
+
+        next := make(chan func(), len(queue))
+        for _, job := range queue {
+            next <- job
+        }
+        close(next)
+
+        for i := 0; i < limit; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for job := range next {
+                    select {
+                    case <-ctx.Done():
+                        return
+                    case p.workers <- struct{}{}:
+                    }
+                    if ctx.Err() != nil {
+                        <-p.workers
+                        return
+                    }
+                    p.run(job)
+                    <-p.workers
+                }
+            }()
+        }
+    }
+    wg.Wait()
+}
+
+// run runs a job, recovering from a panic so the worker slot is released
+// and the other jobs carry on
+func (p *Pool) run(job func()) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Error().Interface("panic", r).Str("stack", string(debug.Stack())).Msg("Job panicked")
+        }
+    }()
+    job()
+}
@@ -0,0 +1,68 @@
+// notification-service/internal/services/retry.go
+
+package services
+
+import (
+    "errors"
+    "math/rand"
+    "time"
+)
+
+// PermanentError wraps a send error that retrying won't fix, such as an
+// invalid address or a rejected message. Providers return it through
+// Permanent; any other error is treated as transient and retried.
+type PermanentError struct {
+    Err error
+}
+
+func (e *PermanentError) Error() string {
+    return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+    return e.Err
+}
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+    if err == nil {
+        return nil
+    }
+    return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked
+// permanent
+func IsPermanent(err error) bool {
+    var permanent *PermanentError
+    return errors.As(err, &permanent)
+}
+
+// RetryPolicy decides what happens to a notification whose send failed
+// with a transient error: it is retried after a backoff that doubles from
+// BaseDelay up to MaxDelay, until MaxAttempts attempts have been made.
+type RetryPolicy struct {
+    MaxAttempts int
+    BaseDelay   time.Duration
+    MaxDelay    time.Duration
+}
+
+// Delay returns how long to wait before the next attempt, given the number
+// of attempts made so far. It is a random duration between half and all of
+// the exponential backoff, so notifications that failed together don't all
+// come back at once.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+    delay := p.BaseDelay
+    for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+        delay *= 2
+    }
+    if delay > p.MaxDelay {
+        delay = p.MaxDelay
+    }
+    if delay <= 0 {
+        return 0
+    }
+
+    half := delay / 2
+    return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
@@ -0,0 +1,164 @@
+// notification-service/internal/services/sendgrid.go
+
+package services
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/models"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    defaultSendGridBaseURL = "https://api.sendgrid.com"
+    defaultSendGridTimeout = 10 * time.Second
+
+    // maxErrorBody caps how much of an error response is read
+    maxErrorBody = 64 << 10
+)
+
+// SendGridEmailService sends email through the SendGrid v3 Mail Send API
+type SendGridEmailService struct {
+    apiKey    string
+    fromEmail string
+    fromName  string
+    baseURL   string
+    client    *http.Client
+}
+
+func NewSendGridEmailService(cfg config.EmailConfig) *SendGridEmailService {
+    baseURL := cfg.BaseURL
+    if baseURL == "" {
+        baseURL = defaultSendGridBaseURL
+    }
+    timeout := cfg.Timeout
+    if timeout <= 0 {
+        timeout = defaultSendGridTimeout
+    }
+
+    return &SendGridEmailService{
+        apiKey:    cfg.APIKey,
+        fromEmail: cfg.FromEmail,
+        fromName:  cfg.FromName,
+        baseURL:   strings.TrimRight(baseURL, "/"),
+        client:    &http.Client{Timeout: timeout},
+    }
+}
+
+// SendGridError is an error response from the Mail Send API
+type SendGridError struct {
+    StatusCode int
+    Messages   []string
+}
+
+func (e *SendGridError) Error() string {
+    msg := fmt.Sprintf("sendgrid: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+    if len(e.Messages) > 0 {
+        msg += ": " + strings.Join(e.Messages, "; ")
+    }
+    return msg
+}
+
+type sendGridAddress struct {
+    Email string `json:"email"`
+    Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+    To []sendGridAddress `json:"to"`
+}
+
+type sendGridContent struct {
+    Type  string `json:"type"`
+    Value string `json:"value"`
+}
+
+type sendGridMail struct {
+    Personalizations []sendGridPersonalization `json:"personalizations"`
+    From             sendGridAddress           `json:"from"`
+    Subject          string                    `json:"subject"`
+    Content          []sendGridContent         `json:"content"`
+}
+
+type sendGridErrorResponse struct {
+    Errors []struct {
+        Message string `json:"message"`
+        Field   string `json:"field"`
+    } `json:"errors"`
+}
+
+func (s *SendGridEmailService) Send(to, subject, content string) error {
+    return s.send(to, subject, content, htmlFromText(content))
+}
+
+func (s *SendGridEmailService) SendWithTemplate(to string, tmpl *models.Template, data interface{}) error {
+    text, html, err := renderEmail(tmpl, data)
+    if err != nil {
+        return Permanent(err)
+    }
+    return s.send(to, tmpl.Subject, text, html)
+}
+
+// send posts one message with a text and an HTML part. Rate limiting,
+// server errors and network failures are retryable; any other rejection is
+// permanent.
+func (s *SendGridEmailService) send(to, subject, text, html string) error {
+    if s.apiKey == "" {
+        return Permanent(errors.New("sendgrid: API key not configured"))
+    }
+
+    body, err := json.Marshal(sendGridMail{
+        Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: to}}}},
+        From:             sendGridAddress{Email: s.fromEmail, Name: s.fromName},
+        Subject:          subject,
+        // SendGrid requires text/plain before text/html
+        Content: []sendGridContent{
+            {Type: "text/plain", Value: text},
+            {Type: "text/html", Value: html},
+        },
+    })
+    if err != nil {
+        return Permanent(err)
+    }
+
+    req, err := http.NewRequest(http.MethodPost, s.baseURL+"/v3/mail/send", bytes.NewReader(body))
+    if err != nil {
+        return Permanent(err)
+    }
+    req.Header.Set("Authorization", "Bearer "+s.apiKey)
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("sendgrid: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        io.Copy(io.Discard, resp.Body)
+        return nil
+    }
+
+    sgErr := &SendGridError{StatusCode: resp.StatusCode}
+    var errResp sendGridErrorResponse
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&errResp); err == nil {
+        for _, e := range errResp.Errors {
+            if e.Field != "" {
+                sgErr.Messages = append(sgErr.Messages, e.Field+": "+e.Message)
+            } else {
+                sgErr.Messages = append(sgErr.Messages, e.Message)
+            }
+        }
+    }
+
+    if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500 {
+        return sgErr
+    }
+    return Permanent(sgErr)
+}
@@ -3,108 +3,165 @@
 package services
 
 import (
-    "bytes"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
-    "html/template"
     "movie-microservices/notification-service/internal/config"
     "movie-microservices/notification-service/internal/models"
     "movie-microservices/notification-service/internal/repository"
     "strconv"
+    "sync/atomic"
     "time"
 
     "github.com/go-redis/redis/v8"
     "github.com/rs/zerolog/log"
 )
 
+// EmailService sends email. Send takes plain-text content and sends it as
+// both a text and an HTML part; SendWithTemplate renders the template's
+// content as HTML for the HTML part and as text for the text part. Errors
+// that retrying won't fix are marked with Permanent.
 type EmailService interface {
     Send(to, subject, content string) error
     SendWithTemplate(to string, template *models.Template, data interface{}) error
 }
 
-type SendGridEmailService struct {
-    apiKey    string
-    fromEmail string
-    fromName  string
-}
-
-func NewEmailService(cfg config.EmailConfig) EmailService {
-    return &SendGridEmailService{
-        apiKey:    cfg.APIKey,
-        fromEmail: cfg.FromEmail,
-        fromName:  cfg.FromName,
+// NewEmailService returns the email provider named by cfg.Provider
+func NewEmailService(cfg config.EmailConfig) (EmailService, error) {
+    switch cfg.Provider {
+    case "", "sendgrid":
+        return NewSendGridEmailService(cfg), nil
+    case "smtp":
+        return NewSMTPEmailService(cfg)
+    default:
+        return nil, fmt.Errorf("unknown email provider %q", cfg.Provider)
     }
 }
 
-func (s *SendGridEmailService) Send(to, subject, content string) error {
-    // This is a placeholder implementation
-    // In a real implementation, you would use the SendGrid API
-    log.Info().
-        Str("to", to).
-        Str("subject", subject).
-        Msg("Sending email (placeholder implementation)")
-    return nil
+// Push priorities. High priority messages are delivered immediately; normal
+// ones may be delayed to save the device's battery.
+const (
+    PriorityHigh   = "high"
+    PriorityNormal = "normal"
+)
+
+// PushMessage is a push notification. Title and Body are shown to the
+// user; Data is delivered to the app alongside them. A message replaces an
+// earlier one with the same CollapseID. Priority defaults to high, and the
+// provider stops trying to deliver the message after Expiration if it is
+// set.
+type PushMessage struct {
+    Title      string
+    Body       string
+    Data       map[string]string
+    CollapseID string
+    Priority   string
+    Expiration time.Time
 }
 
-func (s *SendGridEmailService) SendWithTemplate(to string, template *models.Template, data interface{}) error {
-    // Parse template
-    tmpl, err := template.New("email").Parse(template.Content)
-    if err != nil {
-        return fmt.Errorf("failed to parse email template: %w", err)
-    }
-    
-    // Execute template
-    var content bytes.Buffer
-    if err := tmpl.Execute(&content, data); err != nil {
-        return fmt.Errorf("failed to execute email template: %w", err)
-    }
-    
-    // Send email
-    return s.Send(to, template.Subject, content.String())
+// ErrInvalidToken is wrapped by push errors for device tokens the provider
+// no longer accepts, such as those of uninstalled apps
+var ErrInvalidToken = errors.New("push: device token is no longer valid")
+
+// ErrNoDevices fails push notifications for users without a device to send
+// them to
+var ErrNoDevices = errors.New("push: user has no registered devices")
+
+// PushProvider sends push notifications to a device token through one push
+// service. Errors that retrying won't fix are marked with Permanent.
+type PushProvider interface {
+    Send(token string, msg *PushMessage) error
 }
 
+// PushService sends push notifications to a device on the given platform,
+// through the provider configured for it
 type PushService interface {
-    Send(to, title, content string) error
+    Send(platform, token string, msg *PushMessage) error
 }
 
-type FCMPushService struct {
-    apiKey    string
-    authToken string
+// pushRouter sends through the provider registered for a device's platform,
+// or the fallback for other platforms
+type pushRouter struct {
+    providers map[string]PushProvider
+    fallback  PushProvider
 }
 
-func NewPushService(cfg config.PushConfig) PushService {
-    return &FCMPushService{
-        apiKey:    cfg.APIKey,
-        authToken: cfg.AuthToken,
+func (r *pushRouter) Send(platform, token string, msg *PushMessage) error {
+    if provider, ok := r.providers[platform]; ok {
+        return provider.Send(token, msg)
     }
+    if r.fallback == nil {
+        return Permanent(fmt.Errorf("push: no provider for platform %q", platform))
+    }
+    return r.fallback.Send(token, msg)
 }
 
-func (s *FCMPushService) Send(to, title, content string) error {
-    // This is a placeholder implementation
-    // In a real implementation, you would use the FCM API
-    log.Info().
-        Str("to", to).
-        Str("title", title).
-        Msg("Sending push notification (placeholder implementation)")
-    return nil
+// NewPushService returns a PushService for cfg.Provider: "fcm" sends to
+// every device through FCM, "apns" sends to iOS devices through APNs and
+// has no provider for the others, and "platform" sends to iOS devices
+// through APNs and to the others through FCM
+func NewPushService(cfg config.PushConfig) (PushService, error) {
+    switch cfg.Provider {
+    case "", "fcm":
+        fcm, err := NewFCMPushService(cfg.FCM)
+        if err != nil {
+            return nil, err
+        }
+        return &pushRouter{fallback: fcm}, nil
+    case "apns":
+        apns, err := NewAPNsPushService(cfg.APNs)
+        if err != nil {
+            return nil, err
+        }
+        // APNs would reject Android and web tokens as BadDeviceToken and get
+        // those devices pruned
+        return &pushRouter{providers: map[string]PushProvider{models.PlatformIOS: apns}}, nil
+    case "platform":
+        fcm, err := NewFCMPushService(cfg.FCM)
+        if err != nil {
+            return nil, err
+        }
+        apns, err := NewAPNsPushService(cfg.APNs)
+        if err != nil {
+            return nil, err
+        }
+        return &pushRouter{providers: map[string]PushProvider{models.PlatformIOS: apns}, fallback: fcm}, nil
+    default:
+        return nil, fmt.Errorf("unknown push provider %q", cfg.Provider)
+    }
+}
+
+// SendResult counts what one SendPending run did
+type SendResult struct {
+    Processed    int
+    Sent         int
+    Failed       int
+    DeadLettered int
 }
 
 type NotificationService interface {
     Create(userID int, notificationType, title, content string, channel string) (*models.Notification, error)
-    SendPending() error
+    SendPending(ctx context.Context, pool *Pool, limit int, lease time.Duration, retry RetryPolicy) (*SendResult, error)
+    GetDeadLetters(limit, offset int) ([]*models.Notification, error)
+    Requeue(id int) (*models.Notification, error)
     GetUserNotifications(userID int) ([]*models.Notification, error)
     MarkAsRead(id int) error
     SendMovieRecommendation(userID int, username, movieTitle string) error
     SendWatchlistReminder(userID int, username string, count int) error
     SendNewRelease(userID int, username, movieTitle string) error
     SendRatingReminder(userID int, username, movieTitle string) error
+    HandleEvent(event *models.Event) error
 }
 
 type notificationService struct {
     notificationRepo repository.NotificationRepository
     templateRepo     repository.TemplateRepository
     preferenceRepo   repository.PreferenceRepository
+    ruleRepo         repository.RuleRepository
+    deviceRepo       repository.DeviceRepository
+    deviceMaxIdle    time.Duration
+    users            UserDirectory
     emailService     EmailService
     pushService      PushService
     redis            *redis.Client
@@ -114,6 +171,10 @@ func NewNotificationService(
     notificationRepo repository.NotificationRepository,
     templateRepo repository.TemplateRepository,
     preferenceRepo repository.PreferenceRepository,
+    ruleRepo repository.RuleRepository,
+    deviceRepo repository.DeviceRepository,
+    deviceMaxIdle time.Duration,
+    users UserDirectory,
     emailService EmailService,
     pushService PushService,
     redis *redis.Client,
@@ -122,6 +183,10 @@ func NewNotificationService(
         notificationRepo: notificationRepo,
         templateRepo:     templateRepo,
         preferenceRepo:   preferenceRepo,
+        ruleRepo:         ruleRepo,
+        deviceRepo:       deviceRepo,
+        deviceMaxIdle:    deviceMaxIdle,
+        users:            users,
         emailService:     emailService,
         pushService:      pushService,
         redis:            redis,
@@ -151,54 +216,160 @@ func (s *notificationService) Create(userID int, notificationType, title, conten
     return created, nil
 }
 
-func (s *notificationService) SendPending() error {
-    // Get pending notifications
-    notifications, err := s.notificationRepo.GetPending()
+// SendPending claims up to limit due pending notifications for lease,
+// sends them through pool and waits for them. Failed sends are retried
+// according to retry. If ctx is cancelled, the ones not started yet stay
+// pending and can be claimed again once their lease expires.
+func (s *notificationService) SendPending(ctx context.Context, pool *Pool, limit int, lease time.Duration, retry RetryPolicy) (*SendResult, error) {
+    // Claim pending notifications
+    notifications, err := s.notificationRepo.ClaimPending(limit, lease)
     if err != nil {
-        return err
+        return nil, err
     }
     
-    // Process each notification
+    var sent, failed, dead int64
+    jobs := make(map[string][]func())
     for _, notification := range notifications {
-        // Get user preferences
-        preferences, err := s.preferenceRepo.GetByUserID(notification.UserID)
-        if err != nil {
-            log.Error().Err(err).Int("userID", notification.UserID).Msg("Failed to get user preferences")
-            continue
-        }
-        
-        // Send notification based on channel and preferences
-        var sendErr error
-        switch notification.Channel {
-        case "email":
-            if preferences.EmailEnabled {
-                sendErr = s.emailService.Send(
-                    fmt.Sprintf("user%d@example.com", notification.UserID), // Placeholder email
-                    notification.Title,
-                    notification.Content,
-                )
+        notification := notification
+        jobs[notification.Channel] = append(jobs[notification.Channel], func() {
+            status, err := s.send(notification, retry)
+            if err == nil {
+                atomic.AddInt64(&sent, 1)
+                return
             }
-        case "push":
-            if preferences.PushEnabled {
-                sendErr = s.pushService.Send(
-                    strconv.Itoa(notification.UserID), // Placeholder device token
-                    notification.Title,
-                    notification.Content,
-                )
+            atomic.AddInt64(&failed, 1)
+            if status == models.StatusDead {
+                atomic.AddInt64(&dead, 1)
             }
+        })
+    }
+    pool.Run(ctx, jobs)
+    
+    return &SendResult{
+        Processed:    int(sent + failed),
+        Sent:         int(sent),
+        Failed:       int(failed),
+        DeadLettered: int(dead),
+    }, nil
+}
+
+// send delivers a notification on its channel, unless the user turned the
+// channel off, and records the attempt. A failed notification is scheduled
+// for another attempt, or dead-lettered if the error is permanent or it has
+// run out of attempts. It returns the status the notification was left in
+// and the send error. If the user's preferences can't be read the
+// notification stays pending without counting an attempt, and is tried again
+// once its lease expires.
+func (s *notificationService) send(notification *models.Notification, retry RetryPolicy) (string, error) {
+    // Get user preferences
+    preferences, err := s.preferenceRepo.GetByUserID(notification.UserID)
+    if err != nil {
+        log.Error().Err(err).Int("userID", notification.UserID).Msg("Failed to get user preferences")
+        return models.StatusPending, err
+    }
+    
+    // Send notification based on channel and preferences
+    var sendErr error
+    switch notification.Channel {
+    case "email":
+        if preferences.EmailEnabled {
+            sendErr = s.sendEmail(notification)
         }
-        
-        if sendErr != nil {
-            log.Error().Err(sendErr).Int("notificationID", notification.ID).Msg("Failed to send notification")
-            // Update status to failed
-            s.notificationRepo.UpdateStatus(notification.ID, "failed")
+    case "push":
+        if preferences.PushEnabled {
+            sendErr = s.sendPush(notification)
+        }
+    }
+    
+    status := models.StatusSent
+    var lastError string
+    var nextAttemptAt *time.Time
+    if sendErr != nil {
+        lastError = sendErr.Error()
+        attempts := notification.Attempts + 1
+        if IsPermanent(sendErr) || attempts >= retry.MaxAttempts {
+            status = models.StatusDead
+            log.Error().Err(sendErr).Int("notificationID", notification.ID).Int("attempts", attempts).Msg("Failed to send notification, dead-lettering it")
         } else {
-            // Update status to sent
-            s.notificationRepo.UpdateStatus(notification.ID, "sent")
+            status = models.StatusPending
+            next := time.Now().Add(retry.Delay(attempts))
+            nextAttemptAt = &next
+            log.Warn().Err(sendErr).Int("notificationID", notification.ID).Int("attempts", attempts).Time("nextAttemptAt", next).Msg("Failed to send notification, will retry")
         }
     }
+    if err := s.notificationRepo.RecordAttempt(notification.ID, notification.LeaseToken, status, lastError, nextAttemptAt); err == repository.ErrLeaseLost {
+        log.Warn().Int("notificationID", notification.ID).Msg("Notification lease expired before its attempt was recorded")
+    } else if err != nil {
+        log.Error().Err(err).Int("notificationID", notification.ID).Msg("Failed to record notification attempt")
+    }
     
-    return nil
+    return status, sendErr
+}
+
+// sendEmail sends an email notification to the address user-service has
+// for the user
+func (s *notificationService) sendEmail(notification *models.Notification) error {
+    email, err := s.users.Email(notification.UserID)
+    if err != nil {
+        return err
+    }
+    return s.emailService.Send(email, notification.Title, notification.Content)
+}
+
+// sendPush sends a push notification to each of the user's devices seen
+// within deviceMaxIdle, unregistering those whose token the provider reports as invalid. It
+// succeeds if any device accepted the notification. Otherwise it returns a
+// device's error, preferring a retryable one, or a permanent ErrNoDevices if
+// the user has no devices left.
+func (s *notificationService) sendPush(notification *models.Notification) error {
+    var seenSince time.Time
+    if s.deviceMaxIdle > 0 {
+        seenSince = time.Now().Add(-s.deviceMaxIdle)
+    }
+    devices, err := s.deviceRepo.GetActiveByUserID(notification.UserID, seenSince)
+    if err != nil {
+        return err
+    }
+
+    msg := &PushMessage{
+        Title: notification.Title,
+        Body:  notification.Content,
+        // A retry after a send that reached the device but failed to
+        // report back replaces the first copy
+        CollapseID: "notification-" + strconv.Itoa(notification.ID),
+        Data: map[string]string{
+            "notificationId": strconv.Itoa(notification.ID),
+            "type":           notification.Type,
+        },
+    }
+
+    delivered := false
+    var sendErr error
+    for _, device := range devices {
+        err := s.pushService.Send(device.Platform, device.Token, msg)
+        switch {
+        case err == nil:
+            delivered = true
+        case errors.Is(err, ErrInvalidToken):
+            log.Info().Err(err).Int("userID", device.UserID).Int("deviceID", device.ID).Msg("Unregistering device with an invalid push token")
+            if err := s.deviceRepo.DeleteInvalid(device.ID, device.Token); err != nil {
+                log.Error().Err(err).Int("deviceID", device.ID).Msg("Failed to unregister device")
+            }
+        default:
+            log.Warn().Err(err).Int("notificationID", notification.ID).Int("deviceID", device.ID).Msg("Failed to send push notification to device")
+            if sendErr == nil || IsPermanent(sendErr) {
+                sendErr = err
+            }
+        }
+    }
+
+    if delivered {
+        return nil
+    }
+    if sendErr == nil {
+        return Permanent(ErrNoDevices)
+    }
+    return sendErr
 }
 
 func (s *notificationService) GetUserNotifications(userID int) ([]*models.Notification, error) {
@@ -232,6 +403,16 @@ func (s *notificationService) MarkAsRead(id int) error {
     return s.notificationRepo.MarkAsRead(id)
 }
 
+func (s *notificationService) GetDeadLetters(limit, offset int) ([]*models.Notification, error) {
+    return s.notificationRepo.GetDeadLetters(limit, offset)
+}
+
+// Requeue sends a dead-lettered notification again from scratch. It returns
+// nil if there is no dead-lettered notification with that ID.
+func (s *notificationService) Requeue(id int) (*models.Notification, error) {
+    return s.notificationRepo.Requeue(id)
+}
+
 func (s *notificationService) SendMovieRecommendation(userID int, username, movieTitle string) error {
     // Get template
     template, err := s.templateRepo.GetByNameAndType("movie_recommendation", "email")
@@ -259,8 +440,12 @@ func (s *notificationService) SendMovieRecommendation(userID int, username, movi
     
     // Send email if enabled
     if preferences.EmailEnabled {
+        email, err := s.users.Email(userID)
+        if err != nil {
+            return err
+        }
         if err := s.emailService.SendWithTemplate(
-            fmt.Sprintf("user%d@example.com", userID), // Placeholder email
+            email,
             template,
             data,
         ); err != nil {
@@ -305,8 +490,12 @@ func (s *notificationService) SendWatchlistReminder(userID int, username string,
     
     // Send email if enabled
     if preferences.EmailEnabled {
+        email, err := s.users.Email(userID)
+        if err != nil {
+            return err
+        }
         if err := s.emailService.SendWithTemplate(
-            fmt.Sprintf("user%d@example.com", userID), // Placeholder email
+            email,
             template,
             data,
         ); err != nil {
@@ -351,8 +540,12 @@ func (s *notificationService) SendNewRelease(userID int, username, movieTitle st
     
     // Send email if enabled
     if preferences.EmailEnabled {
+        email, err := s.users.Email(userID)
+        if err != nil {
+            return err
+        }
         if err := s.emailService.SendWithTemplate(
-            fmt.Sprintf("user%d@example.com", userID), // Placeholder email
+            email,
             template,
             data,
         ); err != nil {
@@ -397,8 +590,12 @@ func (s *notificationService) SendRatingReminder(userID int, username, movieTitl
     
     // Send email if enabled
     if preferences.EmailEnabled {
+        email, err := s.users.Email(userID)
+        if err != nil {
+            return err
+        }
         if err := s.emailService.SendWithTemplate(
-            fmt.Sprintf("user%d@example.com", userID), // Placeholder email
+            email,
             template,
             data,
         ); err != nil {
@@ -454,6 +651,44 @@ func (s *templateService) DeleteTemplate(id int) error {
     return s.repo.Delete(id)
 }
 
+// RuleService implementation
+type RuleService interface {
+    GetAllRules() ([]*models.Rule, error)
+    GetRuleByID(id int) (*models.Rule, error)
+    CreateRule(rule *models.Rule) (*models.Rule, error)
+    UpdateRule(id int, rule *models.Rule) (*models.Rule, error)
+    DeleteRule(id int) error
+}
+
+type ruleService struct {
+    repo repository.RuleRepository
+}
+
+func NewRuleService(repo repository.RuleRepository) RuleService {
+    return &ruleService{repo: repo}
+}
+
+func (s *ruleService) GetAllRules() ([]*models.Rule, error) {
+    return s.repo.GetAll()
+}
+
+func (s *ruleService) GetRuleByID(id int) (*models.Rule, error) {
+    return s.repo.GetByID(id)
+}
+
+func (s *ruleService) CreateRule(rule *models.Rule) (*models.Rule, error) {
+    return s.repo.Create(rule)
+}
+
+func (s *ruleService) UpdateRule(id int, rule *models.Rule) (*models.Rule, error) {
+    rule.ID = id
+    return s.repo.Update(rule)
+}
+
+func (s *ruleService) DeleteRule(id int) error {
+    return s.repo.Delete(id)
+}
+
 // PreferenceService implementation
 type PreferenceService interface {
     GetPreferences(userID int) (*models.Preference, error)
@@ -490,3 +725,53 @@ func (s *preferenceService) UpdatePreferences(userID int, req models.UpdatePrefe
     // Save updated preferences
     return s.repo.CreateOrUpdate(preference)
 }
+
+// DeviceService manages the devices users register for push notifications
+type DeviceService interface {
+    GetDevices(userID int) ([]*models.Device, error)
+    Register(userID int, req models.RegisterDeviceRequest) (*models.Device, error)
+    Refresh(userID, id int, req models.RefreshDeviceRequest) (*models.Device, error)
+    Unregister(userID, id int) (bool, error)
+}
+
+type deviceService struct {
+    repo repository.DeviceRepository
+}
+
+func NewDeviceService(repo repository.DeviceRepository) DeviceService {
+    return &deviceService{repo: repo}
+}
+
+func (s *deviceService) GetDevices(userID int) ([]*models.Device, error) {
+    devices, err := s.repo.GetByUserID(userID)
+    if err != nil {
+        return nil, err
+    }
+    if devices == nil {
+        devices = []*models.Device{}
+    }
+    return devices, nil
+}
+
+func (s *deviceService) Register(userID int, req models.RegisterDeviceRequest) (*models.Device, error) {
+    return s.repo.Register(&models.Device{
+        UserID:     userID,
+        Platform:   req.Platform,
+        Token:      req.Token,
+        AppVersion: req.AppVersion,
+    })
+}
+
+// Refresh returns nil if the user has no device with the ID
+func (s *deviceService) Refresh(userID, id int, req models.RefreshDeviceRequest) (*models.Device, error) {
+    return s.repo.Refresh(&models.Device{
+        ID:         id,
+        UserID:     userID,
+        Token:      req.Token,
+        AppVersion: req.AppVersion,
+    })
+}
+
+func (s *deviceService) Unregister(userID, id int) (bool, error) {
+    return s.repo.Delete(userID, id)
+}
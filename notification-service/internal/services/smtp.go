@@ -0,0 +1,393 @@
+// notification-service/internal/services/smtp.go
+
+package services
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/models"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "net/textproto"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/rs/zerolog/log"
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// dkimHeaders are the headers covered by the DKIM signature, in order
+var dkimHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// SMTPEmailService sends email to an SMTP server, as multipart/alternative
+// messages with a text and an HTML part, optionally DKIM-signed
+type SMTPEmailService struct {
+    cfg       config.SMTPConfig
+    fromEmail string
+    fromName  string
+    timeout   time.Duration
+    dkimKey   *rsa.PrivateKey
+}
+
+// NewSMTPEmailService checks the SMTP settings and loads the DKIM key, if
+// one is configured
+func NewSMTPEmailService(cfg config.EmailConfig) (*SMTPEmailService, error) {
+    smtpCfg := cfg.SMTP
+    if smtpCfg.Host == "" {
+        return nil, errors.New("smtp: host not configured")
+    }
+    switch smtpCfg.TLS {
+    case "", "none", "starttls", "tls":
+    default:
+        return nil, fmt.Errorf("smtp: unknown TLS mode %q", smtpCfg.TLS)
+    }
+    switch smtpCfg.Auth {
+    case "", "none", "plain", "login":
+    default:
+        return nil, fmt.Errorf("smtp: unknown auth mechanism %q", smtpCfg.Auth)
+    }
+    // Credentials are only sent over TLS or to localhost, so any other
+    // combination would fail every send
+    if (smtpCfg.Auth == "plain" || smtpCfg.Auth == "login") &&
+        (smtpCfg.TLS == "" || smtpCfg.TLS == "none") && !isLocalhost(smtpCfg.Host) {
+        return nil, fmt.Errorf("smtp: %s auth needs TLS when the server isn't localhost", smtpCfg.Auth)
+    }
+
+    s := &SMTPEmailService{
+        cfg:       smtpCfg,
+        fromEmail: cfg.FromEmail,
+        fromName:  cfg.FromName,
+        timeout:   cfg.Timeout,
+    }
+    if s.timeout <= 0 {
+        s.timeout = defaultSMTPTimeout
+    }
+
+    if smtpCfg.DKIMKeyFile != "" {
+        if smtpCfg.DKIMDomain == "" || smtpCfg.DKIMSelector == "" {
+            return nil, errors.New("smtp: DKIM needs a domain and a selector")
+        }
+        key, err := loadRSAKey(smtpCfg.DKIMKeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("smtp: failed to load DKIM key: %w", err)
+        }
+        s.dkimKey = key
+    }
+
+    return s, nil
+}
+
+func (s *SMTPEmailService) Send(to, subject, content string) error {
+    return s.send(to, subject, content, htmlFromText(content))
+}
+
+func (s *SMTPEmailService) SendWithTemplate(to string, tmpl *models.Template, data interface{}) error {
+    text, html, err := renderEmail(tmpl, data)
+    if err != nil {
+        return Permanent(err)
+    }
+    return s.send(to, tmpl.Subject, text, html)
+}
+
+// send delivers one message. Replies in the 5xx range are permanent; 4xx
+// replies and connection failures are retryable.
+func (s *SMTPEmailService) send(to, subject, text, html string) error {
+    msg, err := s.buildMessage(to, subject, text, html, time.Now())
+    if err != nil {
+        return Permanent(err)
+    }
+
+    if err := s.deliver(to, msg); err != nil {
+        var protoErr *textproto.Error
+        if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+            return Permanent(fmt.Errorf("smtp: %w", err))
+        }
+        return fmt.Errorf("smtp: %w", err)
+    }
+    return nil
+}
+
+func (s *SMTPEmailService) deliver(to string, msg []byte) error {
+    addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+    tlsConfig := &tls.Config{ServerName: s.cfg.Host, InsecureSkipVerify: s.cfg.InsecureSkipVerify}
+    dialer := &net.Dialer{Timeout: s.timeout}
+
+    var conn net.Conn
+    var err error
+    if s.cfg.TLS == "tls" {
+        conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+    } else {
+        conn, err = dialer.Dial("tcp", addr)
+    }
+    if err != nil {
+        return err
+    }
+    conn.SetDeadline(time.Now().Add(s.timeout))
+
+    c, err := smtp.NewClient(conn, s.cfg.Host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer c.Close()
+
+    if s.cfg.TLS == "starttls" {
+        if ok, _ := c.Extension("STARTTLS"); !ok {
+            return Permanent(errors.New("server does not support STARTTLS"))
+        }
+        if err := c.StartTLS(tlsConfig); err != nil {
+            return err
+        }
+    }
+
+    switch s.cfg.Auth {
+    case "plain":
+        if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+            return err
+        }
+    case "login":
+        if err := c.Auth(&loginAuth{username: s.cfg.Username, password: s.cfg.Password}); err != nil {
+            return err
+        }
+    }
+
+    if err := c.Mail(s.fromEmail); err != nil {
+        return err
+    }
+    if err := c.Rcpt(to); err != nil {
+        return err
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(msg); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    // The server has accepted the message, so a failed QUIT mustn't cause
+    // it to be sent again
+    if err := c.Quit(); err != nil {
+        log.Warn().Err(err).Str("host", s.cfg.Host).Msg("SMTP QUIT failed after the message was accepted")
+    }
+    return nil
+}
+
+// buildMessage renders the message with CRLF line endings, DKIM-signed if a
+// key is configured
+func (s *SMTPEmailService) buildMessage(to, subject, text, html string, now time.Time) ([]byte, error) {
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    for _, part := range []struct{ contentType, content string }{
+        {"text/plain; charset=UTF-8", text},
+        {"text/html; charset=UTF-8", html},
+    } {
+        pw, err := mw.CreatePart(textproto.MIMEHeader{
+            "Content-Type":              {part.contentType},
+            "Content-Transfer-Encoding": {"quoted-printable"},
+        })
+        if err != nil {
+            return nil, err
+        }
+        qw := quotedprintable.NewWriter(pw)
+        if _, err := qw.Write([]byte(part.content)); err != nil {
+            return nil, err
+        }
+        if err := qw.Close(); err != nil {
+            return nil, err
+        }
+    }
+    if err := mw.Close(); err != nil {
+        return nil, err
+    }
+
+    messageID, err := s.messageID()
+    if err != nil {
+        return nil, err
+    }
+
+    from := (&mail.Address{Name: s.fromName, Address: s.fromEmail}).String()
+    headers := [][2]string{
+        {"From", from},
+        {"To", (&mail.Address{Address: to}).String()},
+        {"Subject", mime.QEncoding.Encode("UTF-8", subject)},
+        {"Date", now.Format(time.RFC1123Z)},
+        {"Message-ID", messageID},
+        {"MIME-Version", "1.0"},
+        {"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+    }
+
+    var header bytes.Buffer
+    for _, h := range headers {
+        header.WriteString(h[0] + ": " + h[1] + "\r\n")
+    }
+
+    if s.dkimKey != nil {
+        signature, err := s.dkimSignature(headers, body.Bytes(), now)
+        if err != nil {
+            return nil, err
+        }
+        header.Reset()
+        header.WriteString("DKIM-Signature: " + signature + "\r\n")
+        for _, h := range headers {
+            header.WriteString(h[0] + ": " + h[1] + "\r\n")
+        }
+    }
+
+    header.WriteString("\r\n")
+    return append(header.Bytes(), body.Bytes()...), nil
+}
+
+func (s *SMTPEmailService) messageID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    domain := s.fromEmail
+    if at := strings.LastIndex(domain, "@"); at >= 0 {
+        domain = domain[at+1:]
+    }
+    return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
+
+// dkimSignature returns the value of the DKIM-Signature header for a message
+// with the given headers and body, signed rsa-sha256 with relaxed header and
+// body canonicalization (RFC 6376)
+func (s *SMTPEmailService) dkimSignature(headers [][2]string, body []byte, now time.Time) (string, error) {
+    bodyHash := sha256.Sum256(dkimRelaxedBody(body))
+
+    names := make([]string, len(dkimHeaders))
+    for i, name := range dkimHeaders {
+        names[i] = strings.ToLower(name)
+    }
+
+    value := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+        s.cfg.DKIMDomain, s.cfg.DKIMSelector, now.Unix(), strings.Join(names, ":"),
+        base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+    hash := sha256.New()
+    for _, name := range dkimHeaders {
+        for _, h := range headers {
+            if strings.EqualFold(h[0], name) {
+                hash.Write([]byte(dkimRelaxedHeader(h[0], h[1]) + "\r\n"))
+                break
+            }
+        }
+    }
+    // The signature header itself is hashed last, with an empty b= and no
+    // trailing CRLF
+    hash.Write([]byte(dkimRelaxedHeader("DKIM-Signature", value)))
+
+    sig, err := rsa.SignPKCS1v15(rand.Reader, s.dkimKey, crypto.SHA256, hash.Sum(nil))
+    if err != nil {
+        return "", err
+    }
+    return value + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+var (
+    wspRun      = regexp.MustCompile(`[ \t]+`)
+    trailingWSP = regexp.MustCompile(`[ \t]+\r\n`)
+)
+
+// dkimRelaxedHeader canonicalizes a header field: lowercase name, unfolded
+// value with whitespace runs collapsed and trimmed
+func dkimRelaxedHeader(name, value string) string {
+    value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+    value = strings.TrimSpace(wspRun.ReplaceAllString(value, " "))
+    return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// dkimRelaxedBody canonicalizes a body: whitespace runs collapsed, trailing
+// whitespace on lines and trailing empty lines removed
+func dkimRelaxedBody(body []byte) []byte {
+    b := wspRun.ReplaceAll(body, []byte(" "))
+    b = trailingWSP.ReplaceAll(b, []byte("\r\n"))
+    for bytes.HasSuffix(b, []byte("\r\n\r\n")) {
+        b = b[:len(b)-2]
+    }
+    if len(b) > 0 && !bytes.HasSuffix(b, []byte("\r\n")) {
+        b = append(b, '\r', '\n')
+    }
+    return b
+}
+
+// loadRSAKey reads a PEM-encoded RSA private key in PKCS #1 or PKCS #8 form
+func loadRSAKey(path string) (*rsa.PrivateKey, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New("no PEM data found")
+    }
+    if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+        return key, nil
+    }
+    parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    key, ok := parsed.(*rsa.PrivateKey)
+    if !ok {
+        return nil, errors.New("not an RSA key")
+    }
+    return key, nil
+}
+
+// isLocalhost reports whether net/smtp treats host as local, and so allows
+// authenticating without TLS
+func isLocalhost(host string) bool {
+    return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp doesn't provide.
+// Like smtp.PlainAuth, it refuses to send credentials over an unencrypted
+// connection to anything but localhost.
+type loginAuth struct {
+    username string
+    password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+    if !server.TLS && !isLocalhost(server.Name) {
+        return "", nil, errors.New("unencrypted connection")
+    }
+    return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+    if !more {
+        return nil, nil
+    }
+    switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+    case "username:":
+        return []byte(a.username), nil
+    case "password:":
+        return []byte(a.password), nil
+    default:
+        return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+    }
+}
@@ -0,0 +1,146 @@
+// notification-service/internal/services/users.go
+
+package services
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+    defaultUsersTimeout = 10 * time.Second
+
+    // userTokenLifetime is how long the tokens presented to user-service
+    // are valid
+    userTokenLifetime = 15 * time.Minute
+)
+
+// UserDirectory looks up where to reach a user
+type UserDirectory interface {
+    // Email returns the user's email address. A user that doesn't exist or
+    // has no address fails permanently.
+    Email(userID int) (string, error)
+}
+
+// HTTPUserDirectory reads users from user-service's GET /api/users/:id,
+// authenticating with a token it signs with the shared JWT secret
+type HTTPUserDirectory struct {
+    baseURL  string
+    secret   []byte
+    issuer   string
+    audience string
+    client   *http.Client
+
+    mu        sync.Mutex
+    token     string
+    expiresAt time.Time
+}
+
+// NewHTTPUserDirectory returns a directory for the user-service in cfg.
+// Without a base URL every lookup fails permanently, so email is never sent
+// to a guessed address.
+func NewHTTPUserDirectory(cfg config.UsersConfig, jwtSecret string) *HTTPUserDirectory {
+    timeout := cfg.Timeout
+    if timeout <= 0 {
+        timeout = defaultUsersTimeout
+    }
+
+    return &HTTPUserDirectory{
+        baseURL:  strings.TrimRight(cfg.BaseURL, "/"),
+        secret:   []byte(jwtSecret),
+        issuer:   cfg.JWTIssuer,
+        audience: cfg.JWTAudience,
+        client:   &http.Client{Timeout: timeout},
+    }
+}
+
+// Email fetches the user's address. Rate limiting, server errors and
+// network failures are retryable, as is a rejected token, which is replaced
+// on the next attempt.
+func (d *HTTPUserDirectory) Email(userID int) (string, error) {
+    if d.baseURL == "" {
+        return "", Permanent(errors.New("users: user-service URL not configured"))
+    }
+
+    token, err := d.serviceToken()
+    if err != nil {
+        return "", err
+    }
+
+    req, err := http.NewRequest(http.MethodGet, d.baseURL+"/api/users/"+strconv.Itoa(userID), nil)
+    if err != nil {
+        return "", Permanent(err)
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    req.Header.Set("Accept", "application/json")
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("users: %w", err)
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == http.StatusOK:
+    case resp.StatusCode == http.StatusNotFound:
+        return "", Permanent(fmt.Errorf("users: user %d not found", userID))
+    case resp.StatusCode == http.StatusUnauthorized:
+        d.mu.Lock()
+        d.token = ""
+        d.mu.Unlock()
+        return "", fmt.Errorf("users: user-service rejected the service token")
+    case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+        return "", fmt.Errorf("users: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+    default:
+        return "", Permanent(fmt.Errorf("users: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+    }
+
+    var user struct {
+        Email string `json:"email"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&user); err != nil {
+        return "", fmt.Errorf("users: failed to decode user %d: %w", userID, err)
+    }
+    if user.Email == "" {
+        return "", Permanent(fmt.Errorf("users: user %d has no email address", userID))
+    }
+    return user.Email, nil
+}
+
+// serviceToken returns the cached token, signing a new one when there is
+// none or it is about to expire
+func (d *HTTPUserDirectory) serviceToken() (string, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    if d.token != "" && time.Now().Before(d.expiresAt.Add(-tokenExpiryMargin)) {
+        return d.token, nil
+    }
+
+    now := time.Now()
+    expiresAt := now.Add(userTokenLifetime)
+    signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "sub": "notification-service",
+        "iss": d.issuer,
+        "aud": d.audience,
+        "iat": now.Unix(),
+        "exp": expiresAt.Unix(),
+    }).SignedString(d.secret)
+    if err != nil {
+        return "", Permanent(fmt.Errorf("users: failed to sign service token: %w", err))
+    }
+
+    d.token = signed
+    d.expiresAt = expiresAt
+    return d.token, nil
+}
@@ -0,0 +1,296 @@
+package tests
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/models"
+    "movie-microservices/notification-service/internal/services"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// apnsStub stands in for APNs, verifying provider tokens with key and
+// answering with status and reason. It keeps the last request.
+type apnsStub struct {
+    key    *ecdsa.PublicKey
+    status int
+    reason string
+
+    requests int
+    proto    int
+    path     string
+    header   http.Header
+    claims   jwt.MapClaims
+    kid      interface{}
+    payload  map[string]interface{}
+}
+
+func (s *apnsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.requests++
+    s.proto = r.ProtoMajor
+    s.path = r.URL.Path
+    s.header = r.Header
+
+    s.claims = jwt.MapClaims{}
+    token, err := jwt.ParseWithClaims(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "), s.claims, func(*jwt.Token) (interface{}, error) {
+        return s.key, nil
+    }, jwt.WithValidMethods([]string{"ES256"}))
+    if err != nil {
+        w.WriteHeader(http.StatusForbidden)
+        io.WriteString(w, `{"reason":"InvalidProviderToken"}`)
+        return
+    }
+    s.kid = token.Header["kid"]
+
+    data, _ := io.ReadAll(r.Body)
+    s.payload = nil
+    json.Unmarshal(data, &s.payload)
+
+    w.WriteHeader(s.status)
+    if s.reason != "" {
+        io.WriteString(w, `{"reason":"`+s.reason+`"}`)
+    }
+}
+
+func apnsConfig(t *testing.T, stub *apnsStub) config.APNsConfig {
+    t.Helper()
+
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.key = &key.PublicKey
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file := filepath.Join(t.TempDir(), "AuthKey_ABC123DEFG.p8")
+    if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    srv := httptest.NewUnstartedServer(stub)
+    srv.EnableHTTP2 = true
+    srv.StartTLS()
+    t.Cleanup(srv.Close)
+
+    return config.APNsConfig{
+        KeyFile:            file,
+        KeyID:              "ABC123DEFG",
+        TeamID:             "TEAM123456",
+        Topic:              "com.example.movies",
+        Endpoint:           srv.URL,
+        InsecureSkipVerify: true,
+    }
+}
+
+func newAPNs(t *testing.T, stub *apnsStub) services.PushProvider {
+    t.Helper()
+    push, err := services.NewAPNsPushService(apnsConfig(t, stub))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return push
+}
+
+func TestAPNsSend(t *testing.T) {
+    stub := &apnsStub{status: http.StatusOK}
+    push := newAPNs(t, stub)
+
+    expiration := time.Now().Add(time.Hour)
+    msg := &services.PushMessage{
+        Title:      "Rate it",
+        Body:       "How was the movie?",
+        Data:       map[string]string{"movieId": "42"},
+        CollapseID: "rating-42",
+        Expiration: expiration,
+    }
+    if err := push.Send("a1b2c3", msg); err != nil {
+        t.Fatal(err)
+    }
+
+    if stub.proto != 2 {
+        t.Errorf("sent over HTTP/%d, want HTTP/2", stub.proto)
+    }
+    if stub.path != "/3/device/a1b2c3" {
+        t.Errorf("path = %q", stub.path)
+    }
+    if stub.kid != "ABC123DEFG" || stub.claims["iss"] != "TEAM123456" {
+        t.Errorf("kid = %v, claims = %v", stub.kid, stub.claims)
+    }
+
+    for name, want := range map[string]string{
+        "apns-topic":       "com.example.movies",
+        "apns-push-type":   "alert",
+        "apns-priority":    "",
+        "apns-collapse-id": "rating-42",
+        "apns-expiration":  strconv.FormatInt(expiration.Unix(), 10),
+    } {
+        if got := stub.header.Get(name); got != want {
+            t.Errorf("%s = %q, want %q", name, got, want)
+        }
+    }
+
+    alert := stub.payload["aps"].(map[string]interface{})["alert"].(map[string]interface{})
+    if alert["title"] != "Rate it" || alert["body"] != "How was the movie?" {
+        t.Errorf("alert = %v", alert)
+    }
+    if stub.payload["movieId"] != "42" {
+        t.Errorf("payload = %v", stub.payload)
+    }
+}
+
+func TestAPNsBackgroundMessage(t *testing.T) {
+    stub := &apnsStub{status: http.StatusOK}
+    push := newAPNs(t, stub)
+
+    msg := &services.PushMessage{Data: map[string]string{"sync": "1"}, Priority: services.PriorityHigh}
+    if err := push.Send("a1b2c3", msg); err != nil {
+        t.Fatal(err)
+    }
+
+    if stub.header.Get("apns-push-type") != "background" || stub.header.Get("apns-priority") != "5" {
+        t.Errorf("apns-push-type = %q, apns-priority = %q", stub.header.Get("apns-push-type"), stub.header.Get("apns-priority"))
+    }
+    aps := stub.payload["aps"].(map[string]interface{})
+    if aps["content-available"] != float64(1) || aps["alert"] != nil {
+        t.Errorf("aps = %v", aps)
+    }
+}
+
+func TestAPNsReusesProviderToken(t *testing.T) {
+    stub := &apnsStub{status: http.StatusOK}
+    push := newAPNs(t, stub)
+
+    var tokens []string
+    for i := 0; i < 2; i++ {
+        if err := push.Send("a1b2c3", &services.PushMessage{Title: "Hi"}); err != nil {
+            t.Fatal(err)
+        }
+        tokens = append(tokens, stub.header.Get("Authorization"))
+    }
+    if tokens[0] != tokens[1] {
+        t.Error("provider token was replaced between sends")
+    }
+}
+
+func TestAPNsErrors(t *testing.T) {
+    tests := []struct {
+        reason    string
+        status    int
+        permanent bool
+        invalid   bool
+    }{
+        {"Unregistered", http.StatusGone, true, true},
+        {"BadDeviceToken", http.StatusBadRequest, true, true},
+        {"DeviceTokenNotForTopic", http.StatusBadRequest, true, false},
+        {"PayloadTooLarge", http.StatusRequestEntityTooLarge, true, false},
+        {"TooManyRequests", http.StatusTooManyRequests, false, false},
+        {"ServiceUnavailable", http.StatusServiceUnavailable, false, false},
+        {"", http.StatusInternalServerError, false, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.reason, func(t *testing.T) {
+            stub := &apnsStub{status: tt.status, reason: tt.reason}
+            push := newAPNs(t, stub)
+
+            err := push.Send("a1b2c3", &services.PushMessage{Title: "Hi"})
+            if err == nil {
+                t.Fatal("expected an error")
+            }
+            if services.IsPermanent(err) != tt.permanent {
+                t.Errorf("IsPermanent = %v, want %v: %v", !tt.permanent, tt.permanent, err)
+            }
+            if errors.Is(err, services.ErrInvalidToken) != tt.invalid {
+                t.Errorf("errors.Is(ErrInvalidToken) = %v, want %v: %v", !tt.invalid, tt.invalid, err)
+            }
+        })
+    }
+}
+
+func TestAPNsReplacesExpiredProviderToken(t *testing.T) {
+    stub := &apnsStub{status: http.StatusForbidden, reason: "ExpiredProviderToken"}
+    push := newAPNs(t, stub)
+
+    err := push.Send("a1b2c3", &services.PushMessage{Title: "Hi"})
+    if err == nil || services.IsPermanent(err) {
+        t.Fatalf("expected a retryable error, got %v", err)
+    }
+    expired := stub.header.Get("Authorization")
+
+    stub.status, stub.reason = http.StatusOK, ""
+    if err := push.Send("a1b2c3", &services.PushMessage{Title: "Hi"}); err != nil {
+        t.Fatal(err)
+    }
+    if stub.header.Get("Authorization") == expired {
+        t.Error("expired provider token was sent again")
+    }
+}
+
+func TestPushRoutesByPlatform(t *testing.T) {
+    stub := &apnsStub{status: http.StatusOK}
+    push, err := services.NewPushService(config.PushConfig{Provider: "platform", APNs: apnsConfig(t, stub)})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := push.Send(models.PlatformIOS, "a1b2c3", &services.PushMessage{Title: "Hi"}); err != nil {
+        t.Fatal(err)
+    }
+    if stub.requests != 1 {
+        t.Fatalf("APNs got %d requests, want 1", stub.requests)
+    }
+
+    // FCM has no credentials here, so Android sends fail without reaching APNs
+    if err := push.Send(models.PlatformAndroid, "device-1", &services.PushMessage{Title: "Hi"}); err == nil || !strings.HasPrefix(err.Error(), "fcm:") {
+        t.Errorf("expected an FCM error, got %v", err)
+    }
+    if stub.requests != 1 {
+        t.Errorf("APNs got %d requests, want 1", stub.requests)
+    }
+}
+
+// APNs only accepts iOS tokens, so other platforms must neither reach it nor
+// look like invalid tokens
+func TestAPNsProviderSkipsOtherPlatforms(t *testing.T) {
+    stub := &apnsStub{status: http.StatusOK}
+    push, err := services.NewPushService(config.PushConfig{Provider: "apns", APNs: apnsConfig(t, stub)})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := push.Send(models.PlatformIOS, "a1b2c3", &services.PushMessage{Title: "Hi"}); err != nil {
+        t.Fatal(err)
+    }
+    for _, platform := range []string{models.PlatformAndroid, models.PlatformWeb} {
+        err := push.Send(platform, "device-1", &services.PushMessage{Title: "Hi"})
+        if err == nil || !services.IsPermanent(err) || errors.Is(err, services.ErrInvalidToken) {
+            t.Errorf("%s: expected a permanent, not invalid-token error, got %v", platform, err)
+        }
+    }
+    if stub.requests != 1 {
+        t.Errorf("APNs got %d requests, want 1", stub.requests)
+    }
+}
+
+func TestUnknownPushProvider(t *testing.T) {
+    if _, err := services.NewPushService(config.PushConfig{Provider: "pigeon"}); err == nil {
+        t.Error("expected an error")
+    }
+}
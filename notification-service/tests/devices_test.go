@@ -0,0 +1,204 @@
+package tests
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "movie-microservices/notification-service/internal/models"
+    "movie-microservices/notification-service/internal/repository"
+    "movie-microservices/notification-service/internal/services"
+    "sync"
+    "testing"
+    "time"
+)
+
+const (
+    deviceTestType = "device_test"
+
+    deviceTestUser      = 900001
+    otherDeviceTestUser = 900002
+    noDeviceTestUser    = 900003
+)
+
+// fakePushService fails sends to the tokens in errs and records the others
+type fakePushService struct {
+    mu    sync.Mutex
+    errs  map[string]error
+    sends map[string]int
+}
+
+func (s *fakePushService) Send(platform, token string, msg *services.PushMessage) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err := s.errs[token]; err != nil {
+        return err
+    }
+    s.sends[platform+":"+token]++
+    return nil
+}
+
+func register(t *testing.T, repo repository.DeviceRepository, userID int, platform, token string) *models.Device {
+    t.Helper()
+    device, err := repo.Register(&models.Device{UserID: userID, Platform: platform, Token: token, AppVersion: "1.0.0"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return device
+}
+
+func TestRegisterDeviceMovesTokenToNewUser(t *testing.T) {
+    db := connect(t)
+    repo := repository.NewDeviceRepository(db)
+
+    first := register(t, repo, deviceTestUser, models.PlatformIOS, "device-test-shared")
+    second := register(t, repo, otherDeviceTestUser, models.PlatformIOS, "device-test-shared")
+    if second.ID != first.ID {
+        t.Errorf("registering the token again added device %d, want %d updated", second.ID, first.ID)
+    }
+
+    devices, err := repo.GetByUserID(deviceTestUser)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(devices) != 0 {
+        t.Errorf("first user still has %d devices", len(devices))
+    }
+    devices, err = repo.GetByUserID(otherDeviceTestUser)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(devices) != 1 || devices[0].Token != "device-test-shared" {
+        t.Errorf("second user has devices %v", devices)
+    }
+}
+
+func TestRefreshDevice(t *testing.T) {
+    db := connect(t)
+    repo := repository.NewDeviceRepository(db)
+
+    phone := register(t, repo, deviceTestUser, models.PlatformAndroid, "device-test-old")
+    register(t, repo, otherDeviceTestUser, models.PlatformAndroid, "device-test-new")
+
+    // Another user can't refresh the device
+    refreshed, err := repo.Refresh(&models.Device{ID: phone.ID, UserID: otherDeviceTestUser, Token: "device-test-stolen"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if refreshed != nil {
+        t.Fatal("refreshed another user's device")
+    }
+
+    // A new token that was registered elsewhere now belongs to this device
+    refreshed, err = repo.Refresh(&models.Device{ID: phone.ID, UserID: deviceTestUser, Token: "device-test-new"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if refreshed == nil || refreshed.Token != "device-test-new" || refreshed.AppVersion != "1.0.0" {
+        t.Fatalf("refreshed = %+v", refreshed)
+    }
+    if refreshed.LastSeenAt.Before(phone.LastSeenAt) {
+        t.Error("last seen time went back")
+    }
+    devices, err := repo.GetByUserID(otherDeviceTestUser)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(devices) != 0 {
+        t.Errorf("old registration of the token was kept: %v", devices)
+    }
+
+    // An invalid token refreshed since it was reported isn't removed
+    if err := repo.DeleteInvalid(phone.ID, "device-test-old"); err != nil {
+        t.Fatal(err)
+    }
+    if found, err := repo.Delete(deviceTestUser, phone.ID); err != nil || !found {
+        t.Errorf("Delete = %v, %v; want the device found", found, err)
+    }
+}
+
+func TestPushFansOutToDevices(t *testing.T) {
+    db := connect(t)
+    deviceRepo := repository.NewDeviceRepository(db)
+    notificationRepo := repository.NewNotificationRepository(db)
+
+    register(t, deviceRepo, deviceTestUser, models.PlatformIOS, "device-test-phone")
+    register(t, deviceRepo, deviceTestUser, models.PlatformAndroid, "device-test-tablet")
+    uninstalled := register(t, deviceRepo, deviceTestUser, models.PlatformAndroid, "device-test-uninstalled")
+    register(t, deviceRepo, otherDeviceTestUser, models.PlatformWeb, "device-test-unavailable")
+    stale := register(t, deviceRepo, deviceTestUser, models.PlatformIOS, "device-test-stale")
+    if _, err := db.Exec(`UPDATE devices SET last_seen_at = NOW() - INTERVAL '2 days' WHERE id = $1`, stale.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    push := &fakePushService{
+        errs: map[string]error{
+            "device-test-uninstalled": services.Permanent(fmt.Errorf("%w: unregistered", services.ErrInvalidToken)),
+            "device-test-unavailable": errors.New("unavailable"),
+        },
+        sends: map[string]int{},
+    }
+    service := services.NewNotificationService(
+        notificationRepo,
+        repository.NewTemplateRepository(db),
+        repository.NewPreferenceRepository(db),
+        repository.NewRuleRepository(db),
+        deviceRepo,
+        24*time.Hour,
+        exampleUsers{},
+        &countingEmailService{sends: map[string]int{}},
+        push,
+        nil,
+    )
+
+    var ids []int
+    for _, userID := range []int{deviceTestUser, otherDeviceTestUser, noDeviceTestUser} {
+        n, err := notificationRepo.Create(&models.Notification{
+            UserID:  userID,
+            Type:    deviceTestType,
+            Title:   "Device test",
+            Status:  models.StatusPending,
+            Channel: "push",
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, n.ID)
+    }
+
+    pool := services.NewPool(1, map[string]int{"push": 1})
+    if _, err := service.SendPending(context.Background(), pool, 1000, time.Minute, services.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Minute}); err != nil {
+        t.Fatal(err)
+    }
+
+    if push.sends["ios:device-test-phone"] != 1 || push.sends["android:device-test-tablet"] != 1 {
+        t.Errorf("sends = %v", push.sends)
+    }
+    if push.sends["ios:device-test-stale"] != 0 {
+        t.Error("sent to a device not seen within the idle limit")
+    }
+
+    devices, err := deviceRepo.GetByUserID(deviceTestUser)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, d := range devices {
+        if d.ID == uninstalled.ID {
+            t.Error("device with an invalid token was not unregistered")
+        }
+    }
+    if len(devices) != 3 {
+        t.Errorf("user has %d devices, want 3", len(devices))
+    }
+
+    // Delivered to some of the first user's devices, to none of the second's
+    // because of a retryable error, and nowhere for the third
+    for i, want := range []string{models.StatusSent, models.StatusPending, models.StatusDead} {
+        n, err := notificationRepo.GetByID(ids[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if n.Status != want {
+            t.Errorf("notification %d is %s, want %s", n.ID, n.Status, want)
+        }
+    }
+}
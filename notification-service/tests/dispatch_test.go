@@ -0,0 +1,217 @@
+package tests
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/database"
+    "movie-microservices/notification-service/internal/models"
+    "movie-microservices/notification-service/internal/repository"
+    "movie-microservices/notification-service/internal/services"
+    "os"
+    "sync"
+    "testing"
+    "time"
+)
+
+const leaseTestType = "lease_test"
+
+// countingEmailService records every email it is asked to send
+type countingEmailService struct {
+    mu    sync.Mutex
+    sends map[string]int
+}
+
+func (s *countingEmailService) Send(to, subject, content string) error {
+    // Slow sends widen the window for dispatchers to overlap
+    time.Sleep(2 * time.Millisecond)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.sends[content]++
+    return nil
+}
+
+func (s *countingEmailService) SendWithTemplate(to string, tmpl *models.Template, data interface{}) error {
+    return s.Send(to, tmpl.Subject, tmpl.Content)
+}
+
+// exampleUsers gives every user an address at example.com
+type exampleUsers struct{}
+
+func (exampleUsers) Email(userID int) (string, error) {
+    return fmt.Sprintf("user%d@example.com", userID), nil
+}
+
+func TestConcurrentDispatchersNeverSendTwice(t *testing.T) {
+    db := connect(t)
+
+    const count = 200
+    notificationRepo := repository.NewNotificationRepository(db)
+    for i := 0; i < count; i++ {
+        if _, err := notificationRepo.Create(&models.Notification{
+            UserID:  1,
+            Type:    leaseTestType,
+            Title:   "Lease test",
+            Content: fmt.Sprintf("lease test %d", i),
+            Status:  models.StatusPending,
+            Channel: "email",
+        }); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    email := &countingEmailService{sends: map[string]int{}}
+    push := &fakePushService{sends: map[string]int{}}
+    retry := services.RetryPolicy{MaxAttempts: 1}
+
+    // Two dispatchers, each with its own service and pool as separate
+    // replicas would have, drain the queue in small batches
+    var wg sync.WaitGroup
+    for d := 0; d < 2; d++ {
+        service := services.NewNotificationService(
+            notificationRepo,
+            repository.NewTemplateRepository(db),
+            repository.NewPreferenceRepository(db),
+            repository.NewRuleRepository(db),
+            repository.NewDeviceRepository(db),
+            0,
+            exampleUsers{},
+            email,
+            push,
+            nil,
+        )
+        pool := services.NewPool(4, map[string]int{"email": 4})
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                result, err := service.SendPending(context.Background(), pool, 10, time.Minute, retry)
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                if result.Processed == 0 {
+                    return
+                }
+            }
+        }()
+    }
+    wg.Wait()
+
+    for i := 0; i < count; i++ {
+        content := fmt.Sprintf("lease test %d", i)
+        if n := email.sends[content]; n != 1 {
+            t.Errorf("%q sent %d times, want 1", content, n)
+        }
+    }
+
+    var pending int
+    if err := db.QueryRow(`
+        SELECT COUNT(*) FROM notifications WHERE type = $1 AND status <> $2
+    `, leaseTestType, models.StatusSent).Scan(&pending); err != nil {
+        t.Fatal(err)
+    }
+    if pending != 0 {
+        t.Errorf("%d notifications not marked sent", pending)
+    }
+}
+
+func TestExpiredLeaseCanBeClaimedAgain(t *testing.T) {
+    db := connect(t)
+
+    notificationRepo := repository.NewNotificationRepository(db)
+    created, err := notificationRepo.Create(&models.Notification{
+        UserID:  1,
+        Type:    leaseTestType,
+        Title:   "Lease test",
+        Status:  models.StatusPending,
+        Channel: "email",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Simulate a dispatcher that crashed after claiming: its lease runs out
+    first := claim(t, notificationRepo, created.ID, 50*time.Millisecond)
+    if first == nil {
+        t.Fatal("notification was not claimed")
+    }
+    if again := claim(t, notificationRepo, created.ID, time.Minute); again != nil {
+        t.Fatal("notification claimed again while leased")
+    }
+
+    time.Sleep(100 * time.Millisecond)
+    second := claim(t, notificationRepo, created.ID, time.Minute)
+    if second == nil {
+        t.Fatal("notification not claimable after its lease expired")
+    }
+
+    // The crashed dispatcher's late result must not overwrite the new lease
+    err = notificationRepo.RecordAttempt(first.ID, first.LeaseToken, models.StatusSent, "", nil)
+    if err != repository.ErrLeaseLost {
+        t.Fatalf("RecordAttempt with expired lease = %v, want ErrLeaseLost", err)
+    }
+    if err := notificationRepo.RecordAttempt(second.ID, second.LeaseToken, models.StatusSent, "", nil); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// claim claims pending notifications and returns the one with the given ID,
+// if it was among them. The test's schema holds no other notifications, so
+// nothing else is leased.
+func claim(t *testing.T, repo repository.NotificationRepository, id int, lease time.Duration) *models.Notification {
+    t.Helper()
+    claimed, err := repo.ClaimPending(1000, lease)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, n := range claimed {
+        if n.ID == id {
+            return n
+        }
+    }
+    return nil
+}
+
+// connect migrates a new schema in the database from the service's
+// configuration and returns a pool that only sees that schema, so sending
+// pending notifications never touches real ones. The schema is dropped when
+// the test ends, and the test is skipped when no database is reachable.
+func connect(t *testing.T) *sql.DB {
+    t.Helper()
+    cfg, err := config.LoadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    admin, err := database.Connect(cfg.Database)
+    if err != nil {
+        t.Skipf("database not available: %v", err)
+    }
+    schema := fmt.Sprintf("notification_test_%d_%d", os.Getpid(), time.Now().UnixNano())
+    if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+        admin.Close()
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        defer admin.Close()
+        if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+            t.Errorf("dropping test schema %s: %v", schema, err)
+        }
+    })
+
+    // lib/pq sends unknown settings such as search_path to the server
+    db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s search_path=%s",
+        cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.DBName, cfg.Database.SSLMode, schema))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    if err := database.RunMigrations(db); err != nil {
+        t.Fatal(err)
+    }
+    return db
+}
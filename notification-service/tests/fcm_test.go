@@ -0,0 +1,262 @@
+package tests
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/services"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// fcmStub stands in for both the OAuth2 token endpoint and the FCM API. The
+// token endpoint verifies the JWT assertion with key; the FCM API answers
+// with status and body and keeps the last message.
+type fcmStub struct {
+    key    *rsa.PublicKey
+    status int
+    body   string
+
+    mu       sync.Mutex
+    tokenURL string
+    tokens   int
+    claims   jwt.MapClaims
+    kid      interface{}
+    auth     string
+    path     string
+    message  map[string]interface{}
+}
+
+func (s *fcmStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if r.URL.Path == "/token" {
+        if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        claims := jwt.MapClaims{}
+        token, err := jwt.ParseWithClaims(r.FormValue("assertion"), claims, func(*jwt.Token) (interface{}, error) {
+            return s.key, nil
+        }, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(s.tokenURL))
+        if err != nil {
+            w.WriteHeader(http.StatusUnauthorized)
+            io.WriteString(w, `{"error":"invalid_grant"}`)
+            return
+        }
+        s.tokens++
+        s.claims = claims
+        s.kid = token.Header["kid"]
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "access_token": "access-" + strconv.Itoa(s.tokens),
+            "expires_in":   3600,
+            "token_type":   "Bearer",
+        })
+        return
+    }
+
+    s.auth = r.Header.Get("Authorization")
+    s.path = r.URL.Path
+    data, _ := io.ReadAll(r.Body)
+    s.message = nil
+    json.Unmarshal(data, &s.message)
+    w.WriteHeader(s.status)
+    io.WriteString(w, s.body)
+}
+
+func newFCM(t *testing.T, stub *fcmStub) services.PushProvider {
+    t.Helper()
+
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.key = &key.PublicKey
+
+    srv := httptest.NewServer(stub)
+    t.Cleanup(srv.Close)
+    stub.tokenURL = srv.URL + "/token"
+
+    credentials, _ := json.Marshal(map[string]string{
+        "type":           "service_account",
+        "project_id":     "movies-test",
+        "private_key_id": "key-1",
+        "private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+        "client_email":   "push@movies-test.iam.gserviceaccount.com",
+        "token_uri":      stub.tokenURL,
+    })
+    file := filepath.Join(t.TempDir(), "credentials.json")
+    if err := os.WriteFile(file, credentials, 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    push, err := services.NewFCMPushService(config.FCMConfig{CredentialsFile: file, BaseURL: srv.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return push
+}
+
+func TestFCMSend(t *testing.T) {
+    stub := &fcmStub{status: http.StatusOK, body: `{"name":"projects/movies-test/messages/1"}`}
+    push := newFCM(t, stub)
+
+    msg := &services.PushMessage{Title: "Rate it", Body: "How was the movie?", Data: map[string]string{"movieId": "42"}}
+    if err := push.Send("device-1", msg); err != nil {
+        t.Fatal(err)
+    }
+
+    if stub.path != "/v1/projects/movies-test/messages:send" {
+        t.Errorf("path = %q", stub.path)
+    }
+    if stub.auth != "Bearer access-1" {
+        t.Errorf("Authorization = %q", stub.auth)
+    }
+    if stub.kid != "key-1" {
+        t.Errorf("kid = %v", stub.kid)
+    }
+    if stub.claims["iss"] != "push@movies-test.iam.gserviceaccount.com" || stub.claims["scope"] != "https://www.googleapis.com/auth/firebase.messaging" {
+        t.Errorf("claims = %v", stub.claims)
+    }
+
+    message := stub.message["message"].(map[string]interface{})
+    if message["token"] != "device-1" {
+        t.Errorf("token = %v", message["token"])
+    }
+    notification := message["notification"].(map[string]interface{})
+    if notification["title"] != "Rate it" || notification["body"] != "How was the movie?" {
+        t.Errorf("notification = %v", notification)
+    }
+    if data := message["data"].(map[string]interface{}); data["movieId"] != "42" {
+        t.Errorf("data = %v", data)
+    }
+}
+
+func TestFCMReusesAccessToken(t *testing.T) {
+    stub := &fcmStub{status: http.StatusOK, body: `{}`}
+    push := newFCM(t, stub)
+
+    for i := 0; i < 3; i++ {
+        if err := push.Send("device-1", &services.PushMessage{Title: "Hi"}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if stub.tokens != 1 {
+        t.Errorf("%d access tokens requested, want 1", stub.tokens)
+    }
+}
+
+func TestFCMDataOnlyMessage(t *testing.T) {
+    stub := &fcmStub{status: http.StatusOK, body: `{}`}
+    push := newFCM(t, stub)
+
+    if err := push.Send("device-1", &services.PushMessage{Data: map[string]string{"sync": "1"}}); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := stub.message["message"].(map[string]interface{})["notification"]; ok {
+        t.Error("data-only message has a notification")
+    }
+}
+
+func TestFCMDeliveryOptions(t *testing.T) {
+    stub := &fcmStub{status: http.StatusOK, body: `{}`}
+    push := newFCM(t, stub)
+
+    expiration := time.Now().Add(time.Hour)
+    msg := &services.PushMessage{Title: "Hi", CollapseID: "rating-42", Priority: services.PriorityNormal, Expiration: expiration}
+    if err := push.Send("device-1", msg); err != nil {
+        t.Fatal(err)
+    }
+
+    message := stub.message["message"].(map[string]interface{})
+    android := message["android"].(map[string]interface{})
+    if android["collapse_key"] != "rating-42" || android["priority"] != "NORMAL" {
+        t.Errorf("android = %v", android)
+    }
+    if ttl := android["ttl"]; ttl != "3599s" && ttl != "3600s" {
+        t.Errorf("ttl = %v", ttl)
+    }
+    headers := message["apns"].(map[string]interface{})["headers"].(map[string]interface{})
+    if headers["apns-collapse-id"] != "rating-42" || headers["apns-priority"] != "5" || headers["apns-expiration"] != strconv.FormatInt(expiration.Unix(), 10) {
+        t.Errorf("apns headers = %v", headers)
+    }
+}
+
+func TestFCMErrors(t *testing.T) {
+    tests := []struct {
+        name      string
+        status    int
+        body      string
+        permanent bool
+        invalid   bool
+    }{
+        {"unregistered", http.StatusNotFound, `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`, true, true},
+        {"sender mismatch", http.StatusForbidden, `{"error":{"code":403,"status":"PERMISSION_DENIED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"SENDER_ID_MISMATCH"}]}}`, true, false},
+        {"invalid argument", http.StatusBadRequest, `{"error":{"code":400,"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"}]}}`, true, false},
+        {"quota exceeded", http.StatusTooManyRequests, `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"QUOTA_EXCEEDED"}]}}`, false, false},
+        {"unavailable", http.StatusServiceUnavailable, `{"error":{"code":503,"status":"UNAVAILABLE"}}`, false, false},
+        {"server error without body", http.StatusBadGateway, ``, false, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            stub := &fcmStub{status: tt.status, body: tt.body}
+            push := newFCM(t, stub)
+
+            err := push.Send("device-1", &services.PushMessage{Title: "Hi"})
+            if err == nil {
+                t.Fatal("expected an error")
+            }
+            if services.IsPermanent(err) != tt.permanent {
+                t.Errorf("IsPermanent = %v, want %v: %v", !tt.permanent, tt.permanent, err)
+            }
+            if errors.Is(err, services.ErrInvalidToken) != tt.invalid {
+                t.Errorf("errors.Is(ErrInvalidToken) = %v, want %v: %v", !tt.invalid, tt.invalid, err)
+            }
+        })
+    }
+}
+
+func TestFCMRefreshesRejectedAccessToken(t *testing.T) {
+    stub := &fcmStub{status: http.StatusUnauthorized, body: `{"error":{"code":401,"status":"UNAUTHENTICATED"}}`}
+    push := newFCM(t, stub)
+
+    err := push.Send("device-1", &services.PushMessage{Title: "Hi"})
+    if err == nil || services.IsPermanent(err) {
+        t.Fatalf("expected a retryable error, got %v", err)
+    }
+
+    stub.status = http.StatusOK
+    if err := push.Send("device-1", &services.PushMessage{Title: "Hi"}); err != nil {
+        t.Fatal(err)
+    }
+    if stub.tokens != 2 || stub.auth != "Bearer access-2" {
+        t.Errorf("%d access tokens requested, Authorization = %q; want a new token", stub.tokens, stub.auth)
+    }
+}
+
+func TestFCMWithoutCredentials(t *testing.T) {
+    push, err := services.NewFCMPushService(config.FCMConfig{})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = push.Send("device-1", &services.PushMessage{Title: "Hi"})
+    if err == nil || !services.IsPermanent(err) {
+        t.Errorf("expected a permanent error, got %v", err)
+    }
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+    "encoding/json"
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/models"
+    "movie-microservices/notification-service/internal/services"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// sendGridStub stands in for the Mail Send API, answering with status and
+// body and keeping the last request
+type sendGridStub struct {
+    status int
+    body   string
+
+    auth string
+    path string
+    mail map[string]interface{}
+}
+
+func (s *sendGridStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.auth = r.Header.Get("Authorization")
+    s.path = r.URL.Path
+    data, _ := io.ReadAll(r.Body)
+    json.Unmarshal(data, &s.mail)
+    w.WriteHeader(s.status)
+    io.WriteString(w, s.body)
+}
+
+func newSendGrid(t *testing.T, stub *sendGridStub) services.EmailService {
+    t.Helper()
+    srv := httptest.NewServer(stub)
+    t.Cleanup(srv.Close)
+    return services.NewSendGridEmailService(config.EmailConfig{
+        APIKey:    "SG.test",
+        FromEmail: "noreply@example.com",
+        FromName:  "Movie Microservices",
+        BaseURL:   srv.URL,
+    })
+}
+
+func TestSendGridSend(t *testing.T) {
+    stub := &sendGridStub{status: http.StatusAccepted}
+    email := newSendGrid(t, stub)
+
+    if err := email.Send("user@example.com", "Hello", "Line one\nA <b>bold</b> claim"); err != nil {
+        t.Fatal(err)
+    }
+
+    if stub.path != "/v3/mail/send" {
+        t.Errorf("path = %q", stub.path)
+    }
+    if stub.auth != "Bearer SG.test" {
+        t.Errorf("Authorization = %q", stub.auth)
+    }
+
+    from := stub.mail["from"].(map[string]interface{})
+    if from["email"] != "noreply@example.com" || from["name"] != "Movie Microservices" {
+        t.Errorf("from = %v", from)
+    }
+    to := stub.mail["personalizations"].([]interface{})[0].(map[string]interface{})["to"].([]interface{})[0].(map[string]interface{})
+    if to["email"] != "user@example.com" {
+        t.Errorf("to = %v", to)
+    }
+    if stub.mail["subject"] != "Hello" {
+        t.Errorf("subject = %v", stub.mail["subject"])
+    }
+
+    content := stub.mail["content"].([]interface{})
+    if len(content) != 2 {
+        t.Fatalf("content has %d parts, want 2", len(content))
+    }
+    text := content[0].(map[string]interface{})
+    html := content[1].(map[string]interface{})
+    if text["type"] != "text/plain" || text["value"] != "Line one\nA <b>bold</b> claim" {
+        t.Errorf("text part = %v", text)
+    }
+    if html["type"] != "text/html" || html["value"] != "Line one<br>\nA &lt;b&gt;bold&lt;/b&gt; claim" {
+        t.Errorf("html part = %v", html)
+    }
+}
+
+func TestSendGridSendWithTemplate(t *testing.T) {
+    stub := &sendGridStub{status: http.StatusAccepted}
+    email := newSendGrid(t, stub)
+
+    tmpl := &models.Template{Subject: "New release", Content: `Hi {{.Username}}, "{{.MovieTitle}}" is out`}
+    data := map[string]string{"Username": "sam", "MovieTitle": "Tom & Jerry"}
+    if err := email.SendWithTemplate("user@example.com", tmpl, data); err != nil {
+        t.Fatal(err)
+    }
+
+    content := stub.mail["content"].([]interface{})
+    if got := content[0].(map[string]interface{})["value"]; got != `Hi sam, "Tom & Jerry" is out` {
+        t.Errorf("text part = %q", got)
+    }
+    if got := content[1].(map[string]interface{})["value"]; got != `Hi sam, "Tom &amp; Jerry" is out` {
+        t.Errorf("html part = %q", got)
+    }
+}
+
+func TestSendGridErrors(t *testing.T) {
+    tests := []struct {
+        status    int
+        body      string
+        permanent bool
+        message   string
+    }{
+        {http.StatusBadRequest, `{"errors":[{"message":"Does not contain a valid address.","field":"personalizations.0.to.0.email"}]}`, true, "personalizations.0.to.0.email: Does not contain a valid address."},
+        {http.StatusUnauthorized, `{"errors":[{"message":"The provided authorization grant is invalid, expired, or revoked"}]}`, true, "authorization grant"},
+        {http.StatusForbidden, ``, true, "403"},
+        {http.StatusRequestEntityTooLarge, ``, true, "413"},
+        {http.StatusTooManyRequests, `{"errors":[{"message":"too many requests"}]}`, false, "too many requests"},
+        {http.StatusInternalServerError, `oops`, false, "500"},
+        {http.StatusServiceUnavailable, ``, false, "503"},
+    }
+
+    for _, tt := range tests {
+        stub := &sendGridStub{status: tt.status, body: tt.body}
+        email := newSendGrid(t, stub)
+
+        err := email.Send("user@example.com", "Hello", "Hi")
+        if err == nil {
+            t.Errorf("%d: no error", tt.status)
+            continue
+        }
+        if services.IsPermanent(err) != tt.permanent {
+            t.Errorf("%d: permanent = %v, want %v", tt.status, services.IsPermanent(err), tt.permanent)
+        }
+        if !strings.Contains(err.Error(), tt.message) {
+            t.Errorf("%d: error %q doesn't mention %q", tt.status, err, tt.message)
+        }
+    }
+}
+
+func TestSendGridNetworkErrorIsRetryable(t *testing.T) {
+    srv := httptest.NewServer(http.NotFoundHandler())
+    srv.Close()
+
+    email := services.NewSendGridEmailService(config.EmailConfig{APIKey: "SG.test", BaseURL: srv.URL})
+    err := email.Send("user@example.com", "Hello", "Hi")
+    if err == nil || services.IsPermanent(err) {
+        t.Errorf("error = %v, want a retryable error", err)
+    }
+}
+
+func TestSendGridWithoutAPIKeyIsPermanent(t *testing.T) {
+    email := services.NewSendGridEmailService(config.EmailConfig{})
+    if err := email.Send("user@example.com", "Hello", "Hi"); !services.IsPermanent(err) {
+        t.Errorf("error = %v, want a permanent error", err)
+    }
+}
@@ -0,0 +1,513 @@
+package tests
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "io"
+    "math/big"
+    "mime"
+    "mime/multipart"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/services"
+    "net"
+    "net/mail"
+    "net/textproto"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// smtpStub is a minimal SMTP server. It offers STARTTLS when startTLS is
+// set, wraps every connection in TLS when implicitTLS is set, answers RCPT
+// with rcptReply and QUIT with quitReply, and keeps what it was sent.
+type smtpStub struct {
+    tls         *tls.Config
+    startTLS    bool
+    implicitTLS bool
+    rcptReply   string
+    quitReply   string
+
+    mu       sync.Mutex
+    authTLS  bool
+    username string
+    password string
+    from     string
+    to       string
+    message  []byte
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+    defer func() { conn.Close() }()
+    _, isTLS := conn.(*tls.Conn)
+    tp := textproto.NewConn(conn)
+    reply := func(line string) { tp.PrintfLine("%s", line) }
+
+    reply("220 localhost ESMTP stub")
+    for {
+        line, err := tp.ReadLine()
+        if err != nil {
+            return
+        }
+        verb, arg, _ := strings.Cut(line, " ")
+
+        switch strings.ToUpper(verb) {
+        case "EHLO", "HELO":
+            reply("250-localhost")
+            if s.startTLS && !isTLS {
+                reply("250-STARTTLS")
+            }
+            reply("250 AUTH PLAIN LOGIN")
+        case "STARTTLS":
+            reply("220 Ready to start TLS")
+            tlsConn := tls.Server(conn, s.tls)
+            if err := tlsConn.Handshake(); err != nil {
+                return
+            }
+            conn, isTLS = tlsConn, true
+            tp = textproto.NewConn(conn)
+        case "AUTH":
+            mech, initial, _ := strings.Cut(arg, " ")
+            var username, password string
+            switch strings.ToUpper(mech) {
+            case "LOGIN":
+                reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+                line, _ := tp.ReadLine()
+                u, _ := base64.StdEncoding.DecodeString(line)
+                reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+                line, _ = tp.ReadLine()
+                p, _ := base64.StdEncoding.DecodeString(line)
+                username, password = string(u), string(p)
+            case "PLAIN":
+                decoded, _ := base64.StdEncoding.DecodeString(initial)
+                if parts := strings.Split(string(decoded), "\x00"); len(parts) == 3 {
+                    username, password = parts[1], parts[2]
+                }
+            }
+            s.mu.Lock()
+            s.authTLS, s.username, s.password = isTLS, username, password
+            s.mu.Unlock()
+            reply("235 Authenticated")
+        case "MAIL":
+            s.mu.Lock()
+            s.from = arg
+            s.mu.Unlock()
+            reply("250 OK")
+        case "RCPT":
+            s.mu.Lock()
+            s.to = arg
+            s.mu.Unlock()
+            if s.rcptReply != "" {
+                reply(s.rcptReply)
+            } else {
+                reply("250 OK")
+            }
+        case "DATA":
+            reply("354 Go ahead")
+            data, err := tp.ReadDotBytes()
+            if err != nil {
+                return
+            }
+            s.mu.Lock()
+            // ReadDotBytes turns CRLF into LF; put the wire form back
+            s.message = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+            s.mu.Unlock()
+            reply("250 Queued")
+        case "RSET", "NOOP":
+            reply("250 OK")
+        case "QUIT":
+            if s.quitReply != "" {
+                reply(s.quitReply)
+            } else {
+                reply("221 Bye")
+            }
+            return
+        default:
+            reply("502 Unknown command")
+        }
+    }
+}
+
+// start listens on a local port and returns it
+func (s *smtpStub) start(t *testing.T) int {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.implicitTLS {
+        ln = tls.NewListener(ln, s.tls)
+    }
+    t.Cleanup(func() { ln.Close() })
+
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            go s.serve(conn)
+        }
+    }()
+    return ln.Addr().(*net.TCPAddr).Port
+}
+
+// selfSignedTLS returns a server config with a throwaway certificate for
+// 127.0.0.1
+func selfSignedTLS(t *testing.T) *tls.Config {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+        DNSNames:     []string{"localhost"},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func newSMTP(t *testing.T, smtpCfg config.SMTPConfig) services.EmailService {
+    t.Helper()
+    smtpCfg.Host = "127.0.0.1"
+    smtpCfg.InsecureSkipVerify = true
+    email, err := services.NewSMTPEmailService(config.EmailConfig{
+        FromEmail: "noreply@example.com",
+        FromName:  "Movie Microservices",
+        Timeout:   5 * time.Second,
+        SMTP:      smtpCfg,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return email
+}
+
+// readParts returns the content type and decoded body of each part of a
+// multipart/alternative message
+func readParts(t *testing.T, raw []byte) (*mail.Message, map[string]string) {
+    t.Helper()
+    msg, err := mail.ReadMessage(bytes.NewReader(raw))
+    if err != nil {
+        t.Fatal(err)
+    }
+    mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+    if err != nil || mediaType != "multipart/alternative" {
+        t.Fatalf("Content-Type = %q", msg.Header.Get("Content-Type"))
+    }
+
+    parts := map[string]string{}
+    mr := multipart.NewReader(msg.Body, params["boundary"])
+    for {
+        part, err := mr.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        // multipart.Reader decodes quoted-printable parts itself
+        body, err := io.ReadAll(part)
+        if err != nil {
+            t.Fatal(err)
+        }
+        parts[part.Header.Get("Content-Type")] = string(body)
+    }
+    return msg, parts
+}
+
+func TestSMTPStartTLSWithLoginAuth(t *testing.T) {
+    stub := &smtpStub{tls: selfSignedTLS(t), startTLS: true}
+    port := stub.start(t)
+    email := newSMTP(t, config.SMTPConfig{Port: port, TLS: "starttls", Auth: "login", Username: "mailer", Password: "s3cret"})
+
+    if err := email.Send("user@example.com", "Hello", "Line one\nA <b>bold</b> claim"); err != nil {
+        t.Fatal(err)
+    }
+
+    stub.mu.Lock()
+    defer stub.mu.Unlock()
+    if !stub.authTLS {
+        t.Error("authenticated before STARTTLS")
+    }
+    if stub.username != "mailer" || stub.password != "s3cret" {
+        t.Errorf("credentials = %q, %q", stub.username, stub.password)
+    }
+    if stub.from != "FROM:<noreply@example.com>" || stub.to != "TO:<user@example.com>" {
+        t.Errorf("MAIL %s, RCPT %s", stub.from, stub.to)
+    }
+
+    msg, parts := readParts(t, stub.message)
+    if msg.Header.Get("Subject") != "Hello" || msg.Header.Get("To") != "<user@example.com>" {
+        t.Errorf("Subject = %q, To = %q", msg.Header.Get("Subject"), msg.Header.Get("To"))
+    }
+    if from := msg.Header.Get("From"); from != `"Movie Microservices" <noreply@example.com>` {
+        t.Errorf("From = %q", from)
+    }
+    if len(parts) != 2 {
+        t.Fatalf("message has %d parts, want 2", len(parts))
+    }
+    if text := parts["text/plain; charset=UTF-8"]; text != "Line one\r\nA <b>bold</b> claim" {
+        t.Errorf("text part = %q", text)
+    }
+    if html := parts["text/html; charset=UTF-8"]; !strings.Contains(html, "&lt;b&gt;bold&lt;/b&gt;") {
+        t.Errorf("HTML part isn't escaped: %q", html)
+    }
+}
+
+func TestSMTPImplicitTLSWithPlainAuth(t *testing.T) {
+    stub := &smtpStub{tls: selfSignedTLS(t), implicitTLS: true}
+    port := stub.start(t)
+    email := newSMTP(t, config.SMTPConfig{Port: port, TLS: "tls", Auth: "plain", Username: "mailer", Password: "s3cret"})
+
+    if err := email.Send("user@example.com", "Hello", "Hi"); err != nil {
+        t.Fatal(err)
+    }
+
+    stub.mu.Lock()
+    defer stub.mu.Unlock()
+    if !stub.authTLS || stub.username != "mailer" || stub.password != "s3cret" {
+        t.Errorf("auth over TLS = %v, credentials = %q, %q", stub.authTLS, stub.username, stub.password)
+    }
+    if len(stub.message) == 0 {
+        t.Error("no message received")
+    }
+}
+
+func TestSMTPStartTLSNotOffered(t *testing.T) {
+    stub := &smtpStub{}
+    port := stub.start(t)
+    email := newSMTP(t, config.SMTPConfig{Port: port, TLS: "starttls", Auth: "none"})
+
+    err := email.Send("user@example.com", "Hello", "Hi")
+    if err == nil || !services.IsPermanent(err) {
+        t.Errorf("expected a permanent error, got %v", err)
+    }
+}
+
+func TestSMTPErrors(t *testing.T) {
+    tests := []struct {
+        name      string
+        rcptReply string
+        permanent bool
+    }{
+        {"mailbox busy", "450 4.2.1 Mailbox busy", false},
+        {"too many connections", "421 4.7.0 Try again later", false},
+        {"no such user", "550 5.1.1 No such user", true},
+        {"relay denied", "554 5.7.1 Relay access denied", true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            stub := &smtpStub{rcptReply: tt.rcptReply}
+            port := stub.start(t)
+            email := newSMTP(t, config.SMTPConfig{Port: port, TLS: "none", Auth: "none"})
+
+            err := email.Send("user@example.com", "Hello", "Hi")
+            if err == nil {
+                t.Fatal("expected an error")
+            }
+            if services.IsPermanent(err) != tt.permanent {
+                t.Errorf("IsPermanent = %v, want %v: %v", !tt.permanent, tt.permanent, err)
+            }
+        })
+    }
+}
+
+func TestSMTPConnectionRefusedIsRetryable(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    port := ln.Addr().(*net.TCPAddr).Port
+    ln.Close()
+
+    email := newSMTP(t, config.SMTPConfig{Port: port, TLS: "none", Auth: "none"})
+    err = email.Send("user@example.com", "Hello", "Hi")
+    if err == nil || services.IsPermanent(err) {
+        t.Errorf("expected a retryable error, got %v", err)
+    }
+}
+
+// Once the server accepted the message, a failed QUIT must not fail the send,
+// or the retry would deliver it twice
+func TestSMTPQuitFailureAfterDataIsIgnored(t *testing.T) {
+    stub := &smtpStub{quitReply: "451 4.3.0 Oops"}
+    port := stub.start(t)
+    email := newSMTP(t, config.SMTPConfig{Port: port, TLS: "none", Auth: "none"})
+
+    if err := email.Send("user@example.com", "Hello", "Hi"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestSMTPRejectsAuthWithoutTLS(t *testing.T) {
+    for _, auth := range []string{"plain", "login"} {
+        _, err := services.NewSMTPEmailService(config.EmailConfig{
+            SMTP: config.SMTPConfig{Host: "mail.example.com", Port: 25, TLS: "none", Auth: auth},
+        })
+        if err == nil {
+            t.Errorf("%s auth without TLS was accepted", auth)
+        }
+    }
+
+    // net/smtp allows it for localhost, e.g. for a local relay
+    if _, err := services.NewSMTPEmailService(config.EmailConfig{
+        SMTP: config.SMTPConfig{Host: "localhost", Port: 25, TLS: "none", Auth: "plain"},
+    }); err != nil {
+        t.Error(err)
+    }
+}
+
+var (
+    dkimWSP      = regexp.MustCompile(`[ \t]+`)
+    dkimBValue   = regexp.MustCompile(`b=[^;]*$`)
+    dkimTagSplit = regexp.MustCompile(`;\s*`)
+)
+
+// relaxedHeader and relaxedBody implement the relaxed canonicalization of
+// RFC 6376 section 3.4, independently of the service
+func relaxedHeader(name, value string) string {
+    value = strings.ReplaceAll(value, "\r\n", "")
+    return strings.ToLower(name) + ":" + strings.TrimSpace(dkimWSP.ReplaceAllString(value, " "))
+}
+
+func relaxedBody(body string) string {
+    lines := strings.Split(body, "\r\n")
+    for i, line := range lines {
+        lines[i] = strings.TrimRight(dkimWSP.ReplaceAllString(line, " "), " ")
+    }
+    for len(lines) > 0 && lines[len(lines)-1] == "" {
+        lines = lines[:len(lines)-1]
+    }
+    if len(lines) == 0 {
+        return ""
+    }
+    return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// verifyDKIM checks a message's DKIM-Signature against key
+func verifyDKIM(raw []byte, key *rsa.PublicKey) error {
+    head, body, ok := strings.Cut(string(raw), "\r\n\r\n")
+    if !ok {
+        return errors.New("no header/body separator")
+    }
+
+    var fields [][2]string
+    for _, line := range strings.Split(head, "\r\n") {
+        if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+            fields[len(fields)-1][1] += "\r\n" + line
+            continue
+        }
+        name, value, _ := strings.Cut(line, ":")
+        fields = append(fields, [2]string{name, value})
+    }
+
+    var signature string
+    for _, f := range fields {
+        if strings.EqualFold(f[0], "DKIM-Signature") {
+            signature = f[1]
+        }
+    }
+    tags := map[string]string{}
+    for _, tag := range dkimTagSplit.Split(strings.TrimSpace(signature), -1) {
+        k, v, _ := strings.Cut(tag, "=")
+        tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+    }
+    if tags["a"] != "rsa-sha256" || tags["c"] != "relaxed/relaxed" {
+        return errors.New("unexpected a=" + tags["a"] + " c=" + tags["c"])
+    }
+
+    bodyHash := sha256.Sum256([]byte(relaxedBody(body)))
+    if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+        return errors.New("body hash mismatch")
+    }
+
+    hash := sha256.New()
+    for _, name := range strings.Split(tags["h"], ":") {
+        for _, f := range fields {
+            if strings.EqualFold(f[0], name) {
+                hash.Write([]byte(relaxedHeader(f[0], f[1]) + "\r\n"))
+                break
+            }
+        }
+    }
+    hash.Write([]byte(relaxedHeader("DKIM-Signature", dkimBValue.ReplaceAllString(strings.TrimSpace(signature), "b="))))
+
+    sig, err := base64.StdEncoding.DecodeString(tags["b"])
+    if err != nil {
+        return err
+    }
+    return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash.Sum(nil), sig)
+}
+
+func TestSMTPDKIMSignature(t *testing.T) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    stub := &smtpStub{}
+    port := stub.start(t)
+    email := newSMTP(t, config.SMTPConfig{
+        Port: port, TLS: "none", Auth: "none",
+        DKIMDomain: "example.com", DKIMSelector: "mail", DKIMKeyFile: keyFile,
+    })
+
+    if err := email.Send("user@example.com", "Rate  your movie", "Tabs\tand  spaces   \n\n\n"); err != nil {
+        t.Fatal(err)
+    }
+    stub.mu.Lock()
+    raw := stub.message
+    stub.mu.Unlock()
+
+    if !bytes.HasPrefix(raw, []byte("DKIM-Signature: ")) {
+        t.Fatalf("message doesn't start with a DKIM-Signature: %q", raw[:min(len(raw), 80)])
+    }
+    for _, tag := range []string{"d=example.com;", "s=mail;", "h=from:to:subject:date:message-id:mime-version:content-type;"} {
+        if !bytes.Contains(raw, []byte(tag)) {
+            t.Errorf("signature has no %s", tag)
+        }
+    }
+    if err := verifyDKIM(raw, &key.PublicKey); err != nil {
+        t.Fatalf("signature doesn't verify: %v", err)
+    }
+
+    // Relaxed canonicalization tolerates whitespace changes in transit...
+    reformatted := bytes.Replace(raw, []byte("\r\nSubject: "), []byte("\r\nSUBJECT:   "), 1)
+    reformatted = bytes.Replace(reformatted, []byte("\r\n\r\n"), []byte(" \r\n\r\n"), 1)
+    reformatted = append(reformatted, []byte("\r\n\r\n")...)
+    if err := verifyDKIM(reformatted, &key.PublicKey); err != nil {
+        t.Errorf("signature doesn't survive whitespace changes: %v", err)
+    }
+
+    // ...but not changes to the content
+    tampered := bytes.Replace(raw, []byte("Rate"), []byte("Hate"), 1)
+    if err := verifyDKIM(tampered, &key.PublicKey); err == nil {
+        t.Error("signature verifies after the subject was changed")
+    }
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+    "io"
+    "movie-microservices/notification-service/internal/config"
+    "movie-microservices/notification-service/internal/services"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const usersTestSecret = "users-test-secret"
+
+// userServiceStub stands in for user-service's GET /api/users/:id, checking
+// the service token like user-service does and answering with status and
+// body. It keeps the last request.
+type userServiceStub struct {
+    status int
+    body   string
+
+    requests int
+    path     string
+    auth     string
+}
+
+func (s *userServiceStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.requests++
+    s.path = r.URL.Path
+    s.auth = r.Header.Get("Authorization")
+
+    _, err := jwt.Parse(strings.TrimPrefix(s.auth, "Bearer "), func(*jwt.Token) (interface{}, error) {
+        return []byte(usersTestSecret), nil
+    },
+        jwt.WithValidMethods([]string{"HS256"}),
+        jwt.WithIssuer("movie-microservices"),
+        jwt.WithAudience("movie-microservices-clients"),
+    )
+    if err != nil {
+        w.WriteHeader(http.StatusUnauthorized)
+        return
+    }
+
+    w.WriteHeader(s.status)
+    io.WriteString(w, s.body)
+}
+
+func newUserDirectory(t *testing.T, stub *userServiceStub) services.UserDirectory {
+    t.Helper()
+    srv := httptest.NewServer(stub)
+    t.Cleanup(srv.Close)
+    return services.NewHTTPUserDirectory(config.UsersConfig{
+        BaseURL:     srv.URL,
+        JWTIssuer:   "movie-microservices",
+        JWTAudience: "movie-microservices-clients",
+    }, usersTestSecret)
+}
+
+func TestUserDirectoryEmail(t *testing.T) {
+    stub := &userServiceStub{
+        status: http.StatusOK,
+        body:   `{"id":42,"username":"ada","email":"ada@example.com","role":"User"}`,
+    }
+    users := newUserDirectory(t, stub)
+
+    email, err := users.Email(42)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if email != "ada@example.com" {
+        t.Errorf("email = %q", email)
+    }
+    if stub.path != "/api/users/42" {
+        t.Errorf("path = %q", stub.path)
+    }
+}
+
+func TestUserDirectoryReusesServiceToken(t *testing.T) {
+    stub := &userServiceStub{status: http.StatusOK, body: `{"email":"ada@example.com"}`}
+    users := newUserDirectory(t, stub)
+
+    var tokens []string
+    for i := 0; i < 2; i++ {
+        if _, err := users.Email(42); err != nil {
+            t.Fatal(err)
+        }
+        tokens = append(tokens, stub.auth)
+    }
+    if tokens[0] != tokens[1] {
+        t.Error("service token was replaced between lookups")
+    }
+}
+
+func TestUserDirectoryErrors(t *testing.T) {
+    tests := []struct {
+        name      string
+        status    int
+        body      string
+        permanent bool
+    }{
+        {"not found", http.StatusNotFound, "", true},
+        {"no email", http.StatusOK, `{"id":42,"email":""}`, true},
+        {"forbidden", http.StatusForbidden, "", true},
+        {"rate limited", http.StatusTooManyRequests, "", false},
+        {"server error", http.StatusInternalServerError, "", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            users := newUserDirectory(t, &userServiceStub{status: tt.status, body: tt.body})
+
+            _, err := users.Email(42)
+            if err == nil {
+                t.Fatal("expected an error")
+            }
+            if services.IsPermanent(err) != tt.permanent {
+                t.Errorf("IsPermanent = %v, want %v: %v", !tt.permanent, tt.permanent, err)
+            }
+        })
+    }
+}
+
+func TestUserDirectoryRejectedToken(t *testing.T) {
+    stub := &userServiceStub{status: http.StatusOK, body: `{"email":"ada@example.com"}`}
+    srv := httptest.NewServer(stub)
+    t.Cleanup(srv.Close)
+    users := services.NewHTTPUserDirectory(config.UsersConfig{
+        BaseURL:     srv.URL,
+        JWTIssuer:   "movie-microservices",
+        JWTAudience: "movie-microservices-clients",
+    }, "wrong-secret")
+
+    _, err := users.Email(42)
+    if err == nil || services.IsPermanent(err) {
+        t.Errorf("expected a retryable error, got %v", err)
+    }
+}
+
+// Without user-service there is no address to send to, so email fails for
+// good rather than going to a made-up one
+func TestUserDirectoryNotConfigured(t *testing.T) {
+    users := services.NewHTTPUserDirectory(config.UsersConfig{}, usersTestSecret)
+
+    _, err := users.Email(42)
+    if err == nil || !services.IsPermanent(err) {
+        t.Errorf("expected a permanent error, got %v", err)
+    }
+}
@@ -8,12 +8,17 @@ import (
 	"syscall"
 	"time"
 
+	"movie-microservices/watchlist-service/internal/cache"
 	"movie-microservices/watchlist-service/internal/config"
 	"movie-microservices/watchlist-service/internal/controllers"
 	"movie-microservices/watchlist-service/internal/database"
+	"movie-microservices/watchlist-service/internal/events"
 	"movie-microservices/watchlist-service/internal/middleware"
+	"movie-microservices/watchlist-service/internal/movies"
+	"movie-microservices/watchlist-service/internal/progress"
 	"movie-microservices/watchlist-service/internal/redis"
 	"movie-microservices/watchlist-service/internal/repository"
+	"movie-microservices/watchlist-service/internal/review"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
@@ -54,14 +59,47 @@ func main() {
 	rdb := redis.Connect(cfg.Redis)
 	defer rdb.Close()
 
+	// Response cache shared by all controllers
+	responseCache := cache.New(rdb, cfg.Cache)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go responseCache.Listen(backgroundCtx)
+
+	// Client for movie-service, used by import and export
+	movieClient := movies.NewClient(cfg.MovieService)
+
 	// Initialize repositories
 	watchlistRepo := repository.NewWatchlistRepository(db)
+	listRepo := repository.NewListRepository(db)
+	memberRepo := repository.NewMemberRepository(db)
 	historyRepo := repository.NewHistoryRepository(db)
+	importJobRepo := repository.NewImportJobRepository(db)
+	progressRepo := repository.NewProgressRepository(db)
+	yearReviewRepo := repository.NewYearReviewRepository(db)
+	settingsRepo := repository.NewSettingsRepository(db)
+	outboxRepo := repository.NewOutboxRepository(db)
+
+	// Year in review reports are built on first request and stored
+	reviewGenerator := review.NewGenerator(yearReviewRepo, historyRepo, movieClient)
+
+	// Playback heartbeats are buffered in Redis and flushed to Postgres
+	progressTracker := progress.NewTracker(rdb, progressRepo, cfg.Progress)
+	progressFlushed := make(chan struct{})
+	go func() {
+		progressTracker.Run(backgroundCtx)
+		close(progressFlushed)
+	}()
+
+	// Watchlist and history events are written to the outbox with each
+	// change and relayed to a Redis Stream for other services
+	go events.NewRelay(outboxRepo, rdb, cfg.Events).Run(backgroundCtx)
 
 	// Initialize controllers
-	watchlistController := controllers.NewWatchlistController(watchlistRepo, rdb)
-	historyController := controllers.NewHistoryController(historyRepo, rdb)
-	healthController := controllers.NewHealthController(db, rdb)
+	watchlistController := controllers.NewWatchlistController(watchlistRepo, listRepo, memberRepo, movieClient, responseCache)
+	listController := controllers.NewListController(listRepo, memberRepo, responseCache)
+	historyController := controllers.NewHistoryController(historyRepo, importJobRepo, reviewGenerator, movieClient, progressTracker, responseCache)
+	settingsController := controllers.NewSettingsController(settingsRepo)
+	healthController := controllers.NewHealthController(db, rdb, responseCache)
 
 	// Setup Gin router
 	if cfg.Environment == "production" {
@@ -77,6 +115,7 @@ func main() {
 	// Health endpoints
 	router.GET("/health", healthController.Health)
 	router.GET("/ready", healthController.Ready)
+	router.GET("/cache/stats", middleware.Authenticate("Admin"), healthController.CacheStats)
 
 	// API routes
 	api := router.Group("/api")
@@ -87,7 +126,48 @@ func main() {
 			watchlist.GET("", middleware.Authenticate(), watchlistController.GetWatchlist)
 			watchlist.POST("", middleware.Authenticate(), watchlistController.AddToWatchlist)
 			watchlist.DELETE("/:movieId", middleware.Authenticate(), watchlistController.RemoveFromWatchlist)
+			watchlist.PATCH("/:movieId", middleware.Authenticate(), watchlistController.UpdateWatchlistItem)
+			watchlist.PUT("/:movieId/position", middleware.Authenticate(), watchlistController.MoveWatchlistItem)
 			watchlist.GET("/check/:movieId", middleware.Authenticate(), watchlistController.IsInWatchlist)
+			watchlist.POST("/check", middleware.Authenticate(), watchlistController.CheckWatchlist)
+			watchlist.POST("/batch", middleware.Authenticate(), watchlistController.BatchWatchlist)
+			watchlist.GET("/export", middleware.Authenticate(), watchlistController.ExportWatchlist)
+			watchlist.POST("/import", middleware.Authenticate(), watchlistController.ImportWatchlist)
+		}
+
+		// Named list routes
+		lists := api.Group("/lists")
+		{
+			lists.GET("", middleware.Authenticate(), listController.GetLists)
+			lists.POST("", middleware.Authenticate(), listController.CreateList)
+			lists.GET("/export", middleware.Authenticate(), watchlistController.ExportLists)
+			lists.GET("/:listId", middleware.Authenticate(), listController.GetList)
+			lists.PUT("/:listId", middleware.Authenticate(), listController.RenameList)
+			lists.DELETE("/:listId", middleware.Authenticate(), listController.DeleteList)
+
+			lists.GET("/:listId/items", middleware.Authenticate(), watchlistController.GetWatchlist)
+			lists.POST("/:listId/items", middleware.Authenticate(), watchlistController.AddToWatchlist)
+			lists.DELETE("/:listId/items/:movieId", middleware.Authenticate(), watchlistController.RemoveFromWatchlist)
+			lists.PATCH("/:listId/items/:movieId", middleware.Authenticate(), watchlistController.UpdateWatchlistItem)
+			lists.PUT("/:listId/items/:movieId/position", middleware.Authenticate(), watchlistController.MoveWatchlistItem)
+			lists.GET("/:listId/items/check/:movieId", middleware.Authenticate(), watchlistController.IsInWatchlist)
+			lists.POST("/:listId/items/check", middleware.Authenticate(), watchlistController.CheckWatchlist)
+			lists.POST("/:listId/items/batch", middleware.Authenticate(), watchlistController.BatchWatchlist)
+			lists.GET("/:listId/export", middleware.Authenticate(), watchlistController.ExportWatchlist)
+			lists.POST("/:listId/import", middleware.Authenticate(), watchlistController.ImportWatchlist)
+
+			lists.GET("/:listId/members", middleware.Authenticate(), listController.GetMembers)
+			lists.POST("/:listId/members", middleware.Authenticate(), listController.InviteMember)
+			lists.PUT("/:listId/members/:userId", middleware.Authenticate(), listController.UpdateMember)
+			lists.DELETE("/:listId/members/:userId", middleware.Authenticate(), listController.RemoveMember)
+		}
+
+		// Invitations to other users' lists
+		invitations := api.Group("/invitations")
+		{
+			invitations.GET("", middleware.Authenticate(), listController.GetInvitations)
+			invitations.POST("/:listId/accept", middleware.Authenticate(), listController.AcceptInvitation)
+			invitations.POST("/:listId/decline", middleware.Authenticate(), listController.DeclineInvitation)
 		}
 
 		// History routes
@@ -96,6 +176,27 @@ func main() {
 			history.GET("", middleware.Authenticate(), historyController.GetHistory)
 			history.POST("", middleware.Authenticate(), historyController.AddToHistory)
 			history.DELETE("/:movieId", middleware.Authenticate(), historyController.RemoveFromHistory)
+			history.POST("/import", middleware.Authenticate(), historyController.ImportHistory)
+			history.GET("/import/:jobId", middleware.Authenticate(), historyController.GetImportJob)
+			history.GET("/stats", middleware.Authenticate(), historyController.GetStats)
+			history.GET("/sessions", middleware.Authenticate(), historyController.GetSessions)
+			history.GET("/continue", middleware.Authenticate(), historyController.ContinueWatching)
+			history.GET("/progress/:movieId", middleware.Authenticate(), historyController.GetProgress)
+			history.PUT("/progress/:movieId", middleware.Authenticate(), historyController.RecordProgress)
+			history.GET("/year/:year", middleware.Authenticate(), historyController.GetYearReview)
+		}
+
+		// Per-user settings
+		settings := api.Group("/settings")
+		{
+			settings.GET("", middleware.Authenticate(), settingsController.GetSettings)
+			settings.PUT("", middleware.Authenticate(), settingsController.UpdateSettings)
+		}
+
+		// Admin routes
+		admin := api.Group("/admin", middleware.Authenticate("Admin"))
+		{
+			admin.POST("/history/year/:year", historyController.PrecomputeYearReviews)
 		}
 	}
 
@@ -125,6 +226,10 @@ func main() {
 		log.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
 
+	// Stop background work and write out buffered playback progress
+	stopBackground()
+	<-progressFlushed
+
 	log.Info().Msg("Server exited gracefully")
 }
 
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/config"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+// keyVersion prefixes every key. Bump it whenever a cached type changes
+// shape so replicas running the old code never read the new layout.
+const keyVersion = "v1"
+
+// Loader produces the value to cache on a miss. Its result is shared with
+// every caller coalesced onto the same key, so ctx is detached from the
+// cancellation of the request that triggered the load.
+type Loader func(ctx context.Context) (interface{}, error)
+
+// errLoadPanicked is returned to the callers that were waiting on a load that
+// panicked
+var errLoadPanicked = errors.New("cache: load panicked")
+
+// Stats are the cache counters since the process started. Hits includes
+// LocalHits, the hits served from the in-process tier.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	LocalHits uint64 `json:"localHits"`
+	Misses    uint64 `json:"misses"`
+	Loads     uint64 `json:"loads"`
+	Coalesced uint64 `json:"coalesced"`
+	Errors    uint64 `json:"errors"`
+}
+
+// Cache is a cache-aside store over Redis. Values are stored as JSON under
+// versioned keys grouped into scopes (for example one user's history);
+// invalidating a scope bumps its generation so every key in it is dropped at
+// once. Concurrent misses for the same key are coalesced so only one caller
+// hits the database.
+//
+// With the local tier enabled, entries and scope generations are also kept
+// in an in-process LRU. Invalidations are published on a pub/sub channel so
+// every replica moves its local copy of the scope to the new generation;
+// a replica that misses a message serves stale data for at most the local
+// TTL.
+type Cache struct {
+	rdb     *redis.Client
+	ttl     time.Duration
+	local   *lru
+	channel string
+
+	mu       sync.Mutex
+	inflight map[string]*call
+
+	hits      uint64
+	localHits uint64
+	misses    uint64
+	loads     uint64
+	coalesced uint64
+	errors    uint64
+}
+
+type call struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// invalidation is the message published when a scope gets a new generation
+type invalidation struct {
+	Scope      string `json:"scope"`
+	Generation string `json:"generation"`
+}
+
+func New(rdb *redis.Client, cfg config.CacheConfig) *Cache {
+	c := &Cache{
+		rdb:      rdb,
+		ttl:      cfg.TTL,
+		channel:  cfg.InvalidationChannel,
+		inflight: make(map[string]*call),
+	}
+	if cfg.LocalEnabled {
+		c.local = newLRU(cfg.LocalSize, cfg.LocalTTL)
+	}
+	return c
+}
+
+// Get decodes the cached value for key into dest. On a miss it calls load,
+// caches the result and decodes that into dest instead. Redis failures are
+// logged and treated as misses so the database remains the source of truth.
+func (c *Cache) Get(ctx context.Context, scope, key string, dest interface{}, load Loader) error {
+	fullKey, err := c.key(ctx, scope, key)
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		log.Warn().Err(err).Str("scope", scope).Msg("Cache generation lookup failed")
+	} else {
+		if c.local != nil {
+			if data, ok := c.local.get(fullKey); ok && json.Unmarshal(data, dest) == nil {
+				atomic.AddUint64(&c.hits, 1)
+				atomic.AddUint64(&c.localHits, 1)
+				return nil
+			}
+		}
+
+		data, err := c.rdb.Get(ctx, fullKey).Bytes()
+		if err == nil && json.Unmarshal(data, dest) == nil {
+			atomic.AddUint64(&c.hits, 1)
+			if c.local != nil {
+				c.local.set(fullKey, data)
+			}
+			return nil
+		}
+		if err != nil && err != redis.Nil {
+			atomic.AddUint64(&c.errors, 1)
+			log.Warn().Err(err).Str("key", fullKey).Msg("Cache read failed")
+		}
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	flightKey := fullKey
+	if flightKey == "" {
+		flightKey = scope + ":" + key
+	}
+
+	data, err := c.do(flightKey, func() ([]byte, error) {
+		// One caller going away mustn't fail the others waiting on this load
+		loadCtx := context.WithoutCancel(ctx)
+
+		atomic.AddUint64(&c.loads, 1)
+		value, err := load(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if fullKey != "" {
+			if err := c.rdb.Set(loadCtx, fullKey, data, c.ttl).Err(); err != nil {
+				atomic.AddUint64(&c.errors, 1)
+				log.Warn().Err(err).Str("key", fullKey).Msg("Cache write failed")
+			}
+			if c.local != nil {
+				c.local.set(fullKey, data)
+			}
+		}
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// Invalidate drops every key in scope by giving it a new generation. The
+// generation is a timestamp rather than a counter so it never repeats, and it
+// outlives the entries it versions, so once it expires readers fall back to
+// keys that have expired too.
+func (c *Cache) Invalidate(ctx context.Context, scope string) {
+	gen := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := c.rdb.Set(ctx, c.genKey(scope), gen, 2*c.ttl).Err(); err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		log.Warn().Err(err).Str("scope", scope).Msg("Cache invalidation failed")
+	}
+
+	if c.local != nil {
+		c.local.setIf(c.genKey(scope), []byte(gen), newerGeneration)
+	}
+
+	if c.channel != "" {
+		payload, _ := json.Marshal(invalidation{Scope: scope, Generation: gen})
+		if err := c.rdb.Publish(ctx, c.channel, payload).Err(); err != nil {
+			atomic.AddUint64(&c.errors, 1)
+			log.Warn().Err(err).Str("scope", scope).Msg("Cache invalidation publish failed")
+		}
+	}
+}
+
+// Listen applies invalidations published by every replica, including this
+// one, to the local tier until ctx is cancelled. It returns immediately when
+// the local tier is disabled.
+func (c *Cache) Listen(ctx context.Context) {
+	if c.local == nil || c.channel == "" {
+		return
+	}
+
+	sub := c.rdb.Subscribe(ctx, c.channel)
+	defer sub.Close()
+
+	log.Info().Str("channel", c.channel).Msg("Listening for cache invalidations")
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				log.Warn().Err(err).Msg("Ignoring malformed cache invalidation")
+				continue
+			}
+			c.local.setIf(c.genKey(inv.Scope), []byte(inv.Generation), newerGeneration)
+		}
+	}
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		LocalHits: atomic.LoadUint64(&c.localHits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Loads:     atomic.LoadUint64(&c.loads),
+		Coalesced: atomic.LoadUint64(&c.coalesced),
+		Errors:    atomic.LoadUint64(&c.errors),
+	}
+}
+
+// key resolves the current generation of scope and builds the full key
+func (c *Cache) key(ctx context.Context, scope, key string) (string, error) {
+	genKey := c.genKey(scope)
+	if c.local != nil {
+		if gen, ok := c.local.get(genKey); ok {
+			return keyVersion + ":" + scope + ":g" + string(gen) + ":" + key, nil
+		}
+	}
+
+	gen, err := c.rdb.Get(ctx, genKey).Result()
+	if err == redis.Nil {
+		gen = "0"
+	} else if err != nil {
+		return "", err
+	}
+
+	if c.local != nil {
+		// A newer generation may have arrived over pub/sub while we were
+		// reading; never replace it with the one we just fetched.
+		c.local.setIf(genKey, []byte(gen), newerGeneration)
+	}
+
+	return keyVersion + ":" + scope + ":g" + gen + ":" + key, nil
+}
+
+// newerGeneration reports whether the current generation is newer than the
+// candidate and should be kept
+func newerGeneration(current, candidate []byte) bool {
+	cur, err := strconv.ParseInt(string(current), 10, 64)
+	if err != nil {
+		return false
+	}
+	cand, err := strconv.ParseInt(string(candidate), 10, 64)
+	if err != nil {
+		return true
+	}
+	return cur > cand
+}
+
+func (c *Cache) genKey(scope string) string {
+	return keyVersion + ":" + scope + ":gen"
+}
+
+// do runs fn once per key at a time; callers that arrive while it is running
+// wait for and share its result. If fn panics, the panic propagates to the
+// caller that ran it and the waiters get errLoadPanicked.
+func (c *Cache) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if existing, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		atomic.AddUint64(&c.coalesced, 1)
+		<-existing.done
+		return existing.data, existing.err
+	}
+	cl := &call{done: make(chan struct{})}
+	c.inflight[key] = cl
+	c.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			cl.err = errLoadPanicked
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+
+	cl.data, cl.err = fn()
+	returned = true
+
+	return cl.data, cl.err
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoReleasesKeyWhenLoadPanics(t *testing.T) {
+	c := &Cache{inflight: make(map[string]*call)}
+	release := make(chan struct{})
+
+	leaderDone := make(chan interface{})
+	go func() {
+		defer func() { leaderDone <- recover() }()
+		c.do("k", func() ([]byte, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+
+	// Wait for the leader to register the call before joining it
+	for {
+		c.mu.Lock()
+		_, running := c.inflight["k"]
+		c.mu.Unlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	waiterErr := make(chan error)
+	go func() {
+		_, err := c.do("k", func() ([]byte, error) { return nil, errors.New("waiter ran the load") })
+		waiterErr <- err
+	}()
+	for atomic.LoadUint64(&c.coalesced) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if r := <-leaderDone; r != "boom" {
+		t.Errorf("leader recovered %v, want the load's panic", r)
+	}
+	if err := <-waiterErr; !errors.Is(err, errLoadPanicked) {
+		t.Errorf("waiter got %v, want errLoadPanicked", err)
+	}
+
+	data, err := c.do("k", func() ([]byte, error) { return []byte("ok"), nil })
+	if err != nil || string(data) != "ok" {
+		t.Errorf("key still wedged: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a size-bounded in-process cache whose entries also expire after a
+// fixed TTL. It is safe for concurrent use.
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (l *lru) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		l.removeElement(el)
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (l *lru) set(key string, value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.setLocked(key, value)
+}
+
+// setIf stores value unless keep(current, value) says the live entry
+// already stored under key should win.
+func (l *lru) setIf(key string, value []byte, keep func(current, value []byte) bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		if time.Now().Before(entry.expiresAt) && keep(entry.value, value) {
+			return
+		}
+	}
+	l.setLocked(key, value)
+}
+
+func (l *lru) setLocked(key string, value []byte) {
+	expiresAt := time.Now().Add(l.ttl)
+	if el, ok := l.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for l.order.Len() > l.size {
+		l.removeElement(l.order.Back())
+	}
+}
+
+func (l *lru) removeElement(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.entries, el.Value.(*lruEntry).key)
+}
@@ -2,15 +2,22 @@ package config
 
 import (
     "fmt"
+    "strings"
+    "time"
+
     "github.com/spf13/viper"
 )
 
 type Config struct {
-    Port       string
-    Environment string
-    Database   DatabaseConfig
-    Redis      RedisConfig
-    JWT        JWTConfig
+    Port         string
+    Environment  string
+    Database     DatabaseConfig
+    Redis        RedisConfig
+    JWT          JWTConfig
+    Cache        CacheConfig
+    MovieService MovieServiceConfig `mapstructure:"movie_service"`
+    Progress     ProgressConfig
+    Events       EventsConfig
 }
 
 type DatabaseConfig struct {
@@ -33,6 +40,41 @@ type JWTConfig struct {
     Secret string
 }
 
+// CacheConfig controls the response cache. The optional local tier keeps
+// recently used entries in process memory in front of Redis; replicas keep
+// their local tiers in sync through the invalidation pub/sub channel.
+type CacheConfig struct {
+    TTL                 time.Duration `mapstructure:"ttl"`
+    LocalEnabled        bool          `mapstructure:"local_enabled"`
+    LocalSize           int           `mapstructure:"local_size"`
+    LocalTTL            time.Duration `mapstructure:"local_ttl"`
+    InvalidationChannel string        `mapstructure:"invalidation_channel"`
+}
+
+// MovieServiceConfig locates movie-service, used to map titles and external
+// IDs to movie IDs on import and to look up titles on export.
+type MovieServiceConfig struct {
+    URL     string
+    Timeout time.Duration
+}
+
+// ProgressConfig controls how often playback heartbeats buffered in Redis
+// are written to Postgres.
+type ProgressConfig struct {
+    FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// EventsConfig controls the relay that publishes outbox events to a Redis
+// Stream. The stream is trimmed to roughly MaxLen entries, and published
+// outbox rows are deleted after Retention.
+type EventsConfig struct {
+    Stream       string
+    MaxLen       int64         `mapstructure:"max_len"`
+    PollInterval time.Duration `mapstructure:"poll_interval"`
+    BatchSize    int           `mapstructure:"batch_size"`
+    Retention    time.Duration
+}
+
 func LoadConfig() (*Config, error) {
     viper.SetConfigName("config")
     viper.SetConfigType("yaml")
@@ -54,8 +96,22 @@ func LoadConfig() (*Config, error) {
     viper.SetDefault("redis.password", "")
     viper.SetDefault("redis.db", 0)
     viper.SetDefault("jwt.secret", "watchlist_jwt_secret")
+    viper.SetDefault("cache.ttl", "5m")
+    viper.SetDefault("cache.local_enabled", false)
+    viper.SetDefault("cache.local_size", 10000)
+    viper.SetDefault("cache.local_ttl", "30s")
+    viper.SetDefault("cache.invalidation_channel", "watchlist-service:cache-invalidation")
+    viper.SetDefault("movie_service.url", "http://movie-service:3001")
+    viper.SetDefault("movie_service.timeout", "5s")
+    viper.SetDefault("progress.flush_interval", "30s")
+    viper.SetDefault("events.stream", "events:watchlist")
+    viper.SetDefault("events.max_len", 100000)
+    viper.SetDefault("events.poll_interval", "1s")
+    viper.SetDefault("events.batch_size", 100)
+    viper.SetDefault("events.retention", "168h")
 
-    // Enable environment variable override
+    // Enable environment variable override, e.g. CACHE_LOCAL_ENABLED for cache.local_enabled
+    viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
     viper.AutomaticEnv()
 
     // Read configuration
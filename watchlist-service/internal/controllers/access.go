@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+var roleRank = map[string]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleOwner:  3,
+}
+
+// authorizeList loads a list and checks that the user holds at least minRole
+// on it, either as its owner or as an accepted member. The returned list has
+// Role set to the user's role. On failure the error response has already
+// been written and nil is returned; lists the user can't see at all are
+// reported as not found.
+func authorizeList(c *gin.Context, lists repository.ListRepository, members repository.MemberRepository, listID, userID int, minRole string) *models.List {
+	list, err := lists.GetByID(listID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get list"})
+		return nil
+	}
+	if list == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+		return nil
+	}
+
+	if list.UserID == userID {
+		list.Role = models.RoleOwner
+	} else {
+		member, err := members.Get(listID, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get list membership")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get list"})
+			return nil
+		}
+		if member == nil || member.Status != models.MemberAccepted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+			return nil
+		}
+		list.Role = member.Role
+	}
+
+	if roleRank[list.Role] < roleRank[minRole] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions for this list"})
+		return nil
+	}
+
+	return list
+}
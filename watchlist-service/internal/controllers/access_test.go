@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLists serves one list owned by user 1
+type fakeLists struct {
+	repository.ListRepository
+}
+
+func (fakeLists) GetByID(id int) (*models.List, error) {
+	if id != 10 {
+		return nil, nil
+	}
+	return &models.List{ID: 10, UserID: 1, Name: "Shared"}, nil
+}
+
+// fakeMembers serves the list's memberships by user ID
+type fakeMembers struct {
+	repository.MemberRepository
+	members map[int]*models.ListMember
+}
+
+func (m fakeMembers) Get(listID, userID int) (*models.ListMember, error) {
+	return m.members[userID], nil
+}
+
+func TestAuthorizeList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	members := fakeMembers{members: map[int]*models.ListMember{
+		2: {ListID: 10, UserID: 2, Role: models.RoleEditor, Status: models.MemberAccepted},
+		3: {ListID: 10, UserID: 3, Role: models.RoleViewer, Status: models.MemberAccepted},
+		4: {ListID: 10, UserID: 4, Role: models.RoleEditor, Status: models.MemberPending},
+		5: {ListID: 10, UserID: 5, Role: models.RoleEditor, Status: models.MemberDeclined},
+	}}
+
+	tests := []struct {
+		name     string
+		listID   int
+		userID   int
+		minRole  string
+		wantRole string
+		wantCode int
+	}{
+		{"owner can do anything", 10, 1, models.RoleOwner, models.RoleOwner, http.StatusOK},
+		{"editor can edit", 10, 2, models.RoleEditor, models.RoleEditor, http.StatusOK},
+		{"editor can view", 10, 2, models.RoleViewer, models.RoleEditor, http.StatusOK},
+		{"editor can't manage", 10, 2, models.RoleOwner, "", http.StatusForbidden},
+		{"viewer can view", 10, 3, models.RoleViewer, models.RoleViewer, http.StatusOK},
+		{"viewer can't edit", 10, 3, models.RoleEditor, "", http.StatusForbidden},
+		{"pending invitation hides the list", 10, 4, models.RoleViewer, "", http.StatusNotFound},
+		{"declined invitation hides the list", 10, 5, models.RoleViewer, "", http.StatusNotFound},
+		{"non-member can't see the list", 10, 6, models.RoleViewer, "", http.StatusNotFound},
+		{"missing list", 11, 1, models.RoleViewer, "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			list := authorizeList(c, fakeLists{}, members, tt.listID, tt.userID, tt.minRole)
+			if tt.wantRole == "" {
+				assert.Nil(t, list)
+				assert.Equal(t, tt.wantCode, w.Code)
+				return
+			}
+			if assert.NotNil(t, list) {
+				assert.Equal(t, tt.wantRole, list.Role)
+			}
+			assert.False(t, c.Writer.Written(), "response written for an authorized request")
+		})
+	}
+}
@@ -3,10 +3,17 @@ package controllers
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"movie-microservices/watchlist-service/internal/cache"
 	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/movies"
+	"movie-microservices/watchlist-service/internal/progress"
 	"movie-microservices/watchlist-service/internal/repository"
+	"movie-microservices/watchlist-service/internal/review"
+	"movie-microservices/watchlist-service/internal/transfer"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,14 +21,44 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// WatchlistController handles watchlist operations
+// WatchlistController handles watchlist operations. Every handler works on
+// a single list: the one named by the :listId route parameter, or the
+// user's default list for the legacy /api/watchlist routes.
 type WatchlistController struct {
-	repo repository.WatchlistRepository
-	rdb  *redis.Client
+	repo    repository.WatchlistRepository
+	lists   repository.ListRepository
+	members repository.MemberRepository
+	movies  *movies.Client
+	cache   *cache.Cache
 }
 
-func NewWatchlistController(repo repository.WatchlistRepository, rdb *redis.Client) *WatchlistController {
-	return &WatchlistController{repo: repo, rdb: rdb}
+func NewWatchlistController(repo repository.WatchlistRepository, lists repository.ListRepository, members repository.MemberRepository, movies *movies.Client, cache *cache.Cache) *WatchlistController {
+	return &WatchlistController{repo: repo, lists: lists, members: members, movies: movies, cache: cache}
+}
+
+// resolveList loads the list a request targets and checks that the user
+// holds at least minRole on it. It writes the error response itself and
+// returns nil on failure.
+func (ctrl *WatchlistController) resolveList(c *gin.Context, userID int, minRole string) *models.List {
+	listIDStr := c.Param("listId")
+	if listIDStr == "" {
+		list, err := ctrl.lists.GetDefault(userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get default list")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get watchlist"})
+			return nil
+		}
+		list.Role = models.RoleOwner
+		return list
+	}
+
+	listID, err := strconv.Atoi(listIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid list ID"})
+		return nil
+	}
+
+	return authorizeList(c, ctrl.lists, ctrl.members, listID, userID, minRole)
 }
 
 func (ctrl *WatchlistController) GetWatchlist(c *gin.Context) {
@@ -31,24 +68,35 @@ func (ctrl *WatchlistController) GetWatchlist(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-	cacheKey := "watchlist:" + strconv.Itoa(userID.(int))
+	list := ctrl.resolveList(c, userID.(int), models.RoleViewer)
+	if list == nil {
+		return
+	}
 
-	cached, err := ctrl.rdb.Get(ctx, cacheKey).Result()
-	if err == nil {
-		c.JSON(http.StatusOK, gin.H{"success": true, "data": cached})
+	query, ok := parseWatchlistQuery(c)
+	if !ok {
 		return
 	}
 
-	items, err := ctrl.repo.GetByUserID(userID.(int))
+	var page watchlistPage
+	err := ctrl.cache.Get(c.Request.Context(), listCacheScope(list.ID), queryCacheKey(watchlistQueryShape(query)), &page, func(context.Context) (interface{}, error) {
+		items, nextCursor, err := ctrl.repo.GetByListID(list.ID, query)
+		if err != nil {
+			return nil, err
+		}
+		return watchlistPage{Items: items, NextCursor: nextCursor}, nil
+	})
 	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
 		log.Error().Err(err).Msg("Failed to get watchlist")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get watchlist"})
 		return
 	}
 
-	ctrl.rdb.Set(ctx, cacheKey, items, 5*time.Minute)
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": items})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": page.Items, "nextCursor": nullableCursor(page.NextCursor)})
 }
 
 func (ctrl *WatchlistController) AddToWatchlist(c *gin.Context) {
@@ -64,7 +112,12 @@ func (ctrl *WatchlistController) AddToWatchlist(c *gin.Context) {
 		return
 	}
 
-	existsInDB, err := ctrl.repo.Exists(userID.(int), req.MovieID)
+	list := ctrl.resolveList(c, userID.(int), models.RoleEditor)
+	if list == nil {
+		return
+	}
+
+	existsInDB, err := ctrl.repo.Exists(list.ID, req.MovieID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to check watchlist existence")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check watchlist"})
@@ -76,16 +129,14 @@ func (ctrl *WatchlistController) AddToWatchlist(c *gin.Context) {
 		return
 	}
 
-	item, err := ctrl.repo.Add(userID.(int), req.MovieID)
+	item, err := ctrl.repo.Add(list.ID, userID.(int), req.MovieID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to add to watchlist")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to watchlist"})
 		return
 	}
 
-	ctx := c.Request.Context()
-	cacheKey := "watchlist:" + strconv.Itoa(userID.(int))
-	ctrl.rdb.Del(ctx, cacheKey)
+	ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
 
 	c.JSON(http.StatusCreated, gin.H{"success": true, "data": item})
 }
@@ -104,19 +155,143 @@ func (ctrl *WatchlistController) RemoveFromWatchlist(c *gin.Context) {
 		return
 	}
 
-	if err := ctrl.repo.Remove(userID.(int), movieID); err != nil {
+	list := ctrl.resolveList(c, userID.(int), models.RoleEditor)
+	if list == nil {
+		return
+	}
+
+	if err := ctrl.repo.Remove(list.ID, userID.(int), movieID); err != nil {
 		log.Error().Err(err).Msg("Failed to remove from watchlist")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from watchlist"})
 		return
 	}
 
-	ctx := c.Request.Context()
-	cacheKey := "watchlist:" + strconv.Itoa(userID.(int))
-	ctrl.rdb.Del(ctx, cacheKey)
+	ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Movie removed from watchlist"})
 }
 
+func (ctrl *WatchlistController) UpdateWatchlistItem(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	movieID, err := strconv.Atoi(c.Param("movieId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+		return
+	}
+
+	var req models.UpdateWatchlistItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := ctrl.resolveList(c, userID.(int), models.RoleEditor)
+	if list == nil {
+		return
+	}
+
+	item, err := ctrl.repo.Get(list.ID, movieID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get watchlist item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update watchlist item"})
+		return
+	}
+	if item == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Movie not in watchlist"})
+		return
+	}
+
+	// Update fields if provided
+	if req.Note != nil {
+		item.Note = *req.Note
+	}
+	if req.Priority != nil {
+		item.Priority = *req.Priority
+	}
+	if req.Tags != nil {
+		item.Tags = normalizeTags(*req.Tags)
+	}
+
+	updated, err := ctrl.repo.Update(item)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update watchlist item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update watchlist item"})
+		return
+	}
+
+	ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": updated})
+}
+
+func (ctrl *WatchlistController) MoveWatchlistItem(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	movieID, err := strconv.Atoi(c.Param("movieId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+		return
+	}
+
+	var req models.MoveWatchlistItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.AfterMovieID != nil && *req.AfterMovieID == movieID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A movie cannot be moved after itself"})
+		return
+	}
+
+	list := ctrl.resolveList(c, userID.(int), models.RoleEditor)
+	if list == nil {
+		return
+	}
+
+	item, err := ctrl.repo.Move(list.ID, movieID, req.AfterMovieID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMoveTargetNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "afterMovieId is not in this list"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to move watchlist item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move watchlist item"})
+		return
+	}
+	if item == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Movie not in watchlist"})
+		return
+	}
+
+	ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": item})
+}
+
+// normalizeTags trims tags and drops empty and duplicate entries
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
 func (ctrl *WatchlistController) IsInWatchlist(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -131,7 +306,12 @@ func (ctrl *WatchlistController) IsInWatchlist(c *gin.Context) {
 		return
 	}
 
-	inWatchlist, err := ctrl.repo.Exists(userID.(int), movieID)
+	list := ctrl.resolveList(c, userID.(int), models.RoleViewer)
+	if list == nil {
+		return
+	}
+
+	inWatchlist, err := ctrl.repo.Exists(list.ID, movieID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to check watchlist existence")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check watchlist"})
@@ -141,14 +321,89 @@ func (ctrl *WatchlistController) IsInWatchlist(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"inWatchlist": inWatchlist}})
 }
 
+// CheckWatchlist reports membership for many movies in one query
+func (ctrl *WatchlistController) CheckWatchlist(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.CheckWatchlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := ctrl.resolveList(c, userID.(int), models.RoleViewer)
+	if list == nil {
+		return
+	}
+
+	inWatchlist, err := ctrl.repo.ExistsMany(list.ID, req.MovieIDs)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check watchlist existence")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check watchlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"inWatchlist": inWatchlist}})
+}
+
+// BatchWatchlist adds and removes many movies in one transaction
+func (ctrl *WatchlistController) BatchWatchlist(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.BatchWatchlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := ctrl.resolveList(c, userID.(int), models.RoleEditor)
+	if list == nil {
+		return
+	}
+
+	results, err := ctrl.repo.Batch(list.ID, userID.(int), req.Operations)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to apply watchlist batch")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update watchlist"})
+		return
+	}
+
+	changed := 0
+	for _, result := range results {
+		if result.Status == models.BatchStatusAdded || result.Status == models.BatchStatusRemoved {
+			changed++
+		}
+	}
+	if changed > 0 {
+		ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"results": results, "changed": changed}})
+}
+
 // HistoryController handles history operations
 type HistoryController struct {
-	repo repository.HistoryRepository
-	rdb  *redis.Client
+	repo     repository.HistoryRepository
+	jobs     repository.ImportJobRepository
+	importer *transfer.HistoryImporter
+	reviews  *review.Generator
+	progress *progress.Tracker
+	cache    *cache.Cache
 }
 
-func NewHistoryController(repo repository.HistoryRepository, rdb *redis.Client) *HistoryController {
-	return &HistoryController{repo: repo, rdb: rdb}
+func NewHistoryController(repo repository.HistoryRepository, jobs repository.ImportJobRepository, reviews *review.Generator, movies *movies.Client, progress *progress.Tracker, cache *cache.Cache) *HistoryController {
+	importer := transfer.NewHistoryImporter(jobs, repo, movies, func(userID int) {
+		cache.Invalidate(context.Background(), historyCacheScope(userID))
+	})
+	return &HistoryController{repo: repo, jobs: jobs, importer: importer, reviews: reviews, progress: progress, cache: cache}
 }
 
 func (ctrl *HistoryController) GetHistory(c *gin.Context) {
@@ -158,24 +413,93 @@ func (ctrl *HistoryController) GetHistory(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-	cacheKey := "history:" + strconv.Itoa(userID.(int))
-
-	cached, err := ctrl.rdb.Get(ctx, cacheKey).Result()
-	if err == nil {
-		c.JSON(http.StatusOK, gin.H{"success": true, "data": cached})
+	query, ok := parseHistoryQuery(c)
+	if !ok {
 		return
 	}
 
-	items, err := ctrl.repo.GetByUserID(userID.(int))
+	var page historyPage
+	err := ctrl.cache.Get(c.Request.Context(), historyCacheScope(userID.(int)), queryCacheKey(historyQueryShape(query)), &page, func(context.Context) (interface{}, error) {
+		items, nextCursor, err := ctrl.repo.GetByUserID(userID.(int), query)
+		if err != nil {
+			return nil, err
+		}
+		return historyPage{Items: items, NextCursor: nextCursor}, nil
+	})
 	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
 		log.Error().Err(err).Msg("Failed to get history")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history"})
 		return
 	}
 
-	ctrl.rdb.Set(ctx, cacheKey, items, 5*time.Minute)
-	c.JSON(http.StatusOK, gin.H{"success": true, "data": items})
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": page.Items, "nextCursor": nullableCursor(page.NextCursor)})
+}
+
+// GetStats returns the user's viewing statistics over an optional date
+// range
+func (ctrl *HistoryController) GetStats(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	from, to, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+	query := repository.StatsQuery{From: from, To: to, Today: time.Now().UTC()}
+
+	var stats models.HistoryStats
+	err := ctrl.cache.Get(c.Request.Context(), historyCacheScope(userID.(int)), queryCacheKey(statsQueryShape(query)), &stats, func(context.Context) (interface{}, error) {
+		return ctrl.repo.GetStats(userID.(int), query)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get history stats")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": stats})
+}
+
+// GetSessions returns the user's watch sessions reconstructed from their
+// history, newest first
+func (ctrl *HistoryController) GetSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	query, ok := parseSessionQuery(c)
+	if !ok {
+		return
+	}
+
+	var page sessionPage
+	err := ctrl.cache.Get(c.Request.Context(), historyCacheScope(userID.(int)), queryCacheKey(sessionQueryShape(query)), &page, func(context.Context) (interface{}, error) {
+		sessions, nextCursor, err := ctrl.repo.GetSessions(userID.(int), query)
+		if err != nil {
+			return nil, err
+		}
+		return sessionPage{Items: sessions, NextCursor: nextCursor}, nil
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to get watch sessions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get watch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": page.Items, "nextCursor": nullableCursor(page.NextCursor)})
 }
 
 func (ctrl *HistoryController) AddToHistory(c *gin.Context) {
@@ -193,14 +517,23 @@ func (ctrl *HistoryController) AddToHistory(c *gin.Context) {
 
 	item, err := ctrl.repo.Add(userID.(int), req.MovieID, req.Action)
 	if err != nil {
+		var transitionErr *repository.TransitionError
+		if errors.As(err, &transitionErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": transitionErr.Error()})
+			return
+		}
 		log.Error().Err(err).Msg("Failed to add to history")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to history"})
 		return
 	}
 
-	ctx := c.Request.Context()
-	cacheKey := "history:" + strconv.Itoa(userID.(int))
-	ctrl.rdb.Del(ctx, cacheKey)
+	ctrl.cache.Invalidate(c.Request.Context(), historyCacheScope(userID.(int)))
+	if item.RemovedFromList != nil {
+		ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(*item.RemovedFromList))
+	}
+	if item.MovedToList != nil {
+		ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(*item.MovedToList))
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"success": true, "data": item})
 }
@@ -225,21 +558,20 @@ func (ctrl *HistoryController) RemoveFromHistory(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-	cacheKey := "history:" + strconv.Itoa(userID.(int))
-	ctrl.rdb.Del(ctx, cacheKey)
+	ctrl.cache.Invalidate(c.Request.Context(), historyCacheScope(userID.(int)))
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "History item removed"})
 }
 
 // HealthController handles service health checks
 type HealthController struct {
-	db  *sql.DB
-	rdb *redis.Client
+	db    *sql.DB
+	rdb   *redis.Client
+	cache *cache.Cache
 }
 
-func NewHealthController(db *sql.DB, rdb *redis.Client) *HealthController {
-	return &HealthController{db: db, rdb: rdb}
+func NewHealthController(db *sql.DB, rdb *redis.Client, cache *cache.Cache) *HealthController {
+	return &HealthController{db: db, rdb: rdb, cache: cache}
 }
 
 func (ctrl *HealthController) Health(c *gin.Context) {
@@ -279,3 +611,11 @@ func (ctrl *HealthController) Ready(c *gin.Context) {
 	})
 }
 
+// CacheStats reports the response cache hit and miss counters
+func (ctrl *HealthController) CacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"service":   "watchlist-service",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"cache":     ctrl.cache.Stats(),
+	})
+}
@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"errors"
+	"movie-microservices/watchlist-service/internal/cache"
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ListController handles creating, renaming, deleting and sharing named lists
+type ListController struct {
+	repo    repository.ListRepository
+	members repository.MemberRepository
+	cache   *cache.Cache
+}
+
+func NewListController(repo repository.ListRepository, members repository.MemberRepository, cache *cache.Cache) *ListController {
+	return &ListController{repo: repo, members: members, cache: cache}
+}
+
+// authorizedList loads the list named by :listId and checks that the user
+// holds at least minRole on it. It writes the error response itself and
+// returns nil on failure.
+func (ctrl *ListController) authorizedList(c *gin.Context, userID int, minRole string) *models.List {
+	listID, err := strconv.Atoi(c.Param("listId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid list ID"})
+		return nil
+	}
+
+	return authorizeList(c, ctrl.repo, ctrl.members, listID, userID, minRole)
+}
+
+func (ctrl *ListController) GetLists(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Make sure the default list shows up even before anything was added to it
+	if _, err := ctrl.repo.GetDefault(userID.(int)); err != nil {
+		log.Error().Err(err).Msg("Failed to get default list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lists"})
+		return
+	}
+
+	lists, err := ctrl.repo.GetByUserID(userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get lists")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lists"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": lists})
+}
+
+func (ctrl *ListController) GetList(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), models.RoleViewer)
+	if list == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": list})
+}
+
+func (ctrl *ListController) CreateList(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.CreateListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create the default list first so a custom list can't take its name
+	if _, err := ctrl.repo.GetDefault(userID.(int)); err != nil {
+		log.Error().Err(err).Msg("Failed to get default list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create list"})
+		return
+	}
+
+	list, err := ctrl.repo.Create(userID.(int), req.Name)
+	if err != nil {
+		if errors.Is(err, repository.ErrListNameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A list with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to create list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create list"})
+		return
+	}
+
+	list.Role = models.RoleOwner
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": list})
+}
+
+func (ctrl *ListController) RenameList(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.UpdateListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), models.RoleOwner)
+	if list == nil {
+		return
+	}
+
+	updated, err := ctrl.repo.Rename(list.ID, req.Name)
+	if err != nil {
+		if errors.Is(err, repository.ErrListNameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A list with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to rename list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename list"})
+		return
+	}
+
+	updated.Role = list.Role
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": updated})
+}
+
+func (ctrl *ListController) DeleteList(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), models.RoleOwner)
+	if list == nil {
+		return
+	}
+
+	if list.IsDefault {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The default list cannot be deleted"})
+		return
+	}
+
+	if err := ctrl.repo.Delete(list.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to delete list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete list"})
+		return
+	}
+
+	ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "List deleted"})
+}
+
+func (ctrl *ListController) GetMembers(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), models.RoleViewer)
+	if list == nil {
+		return
+	}
+
+	members, err := ctrl.members.GetByListID(list.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get list members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get list members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"ownerId": list.UserID,
+			"members": members,
+		},
+	})
+}
+
+func (ctrl *ListController) InviteMember(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.InviteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), models.RoleOwner)
+	if list == nil {
+		return
+	}
+
+	if req.UserID == list.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The list owner cannot be invited"})
+		return
+	}
+
+	existing, err := ctrl.members.Get(list.ID, req.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get list membership")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite member"})
+		return
+	}
+	if existing != nil && existing.Status != models.MemberDeclined {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member or has a pending invitation"})
+		return
+	}
+
+	member, err := ctrl.members.Invite(list.ID, req.UserID, req.Role, userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to invite member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite member"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": member})
+}
+
+func (ctrl *ListController) UpdateMember(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), models.RoleOwner)
+	if list == nil {
+		return
+	}
+
+	member, err := ctrl.members.Get(list.ID, memberID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get list membership")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+	if member == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	if err := ctrl.members.UpdateRole(list.ID, memberID, req.Role); err != nil {
+		log.Error().Err(err).Msg("Failed to update member role")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	member.Role = req.Role
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": member})
+}
+
+// RemoveMember lets the owner remove anyone from a list, and lets members
+// remove themselves.
+func (ctrl *ListController) RemoveMember(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	minRole := models.RoleOwner
+	if memberID == userID.(int) {
+		minRole = models.RoleViewer
+	}
+
+	list := ctrl.authorizedList(c, userID.(int), minRole)
+	if list == nil {
+		return
+	}
+
+	if err := ctrl.members.Remove(list.ID, memberID); err != nil {
+		log.Error().Err(err).Msg("Failed to remove list member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Member removed"})
+}
+
+func (ctrl *ListController) GetInvitations(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	invitations, err := ctrl.members.GetInvitations(userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get invitations")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": invitations})
+}
+
+func (ctrl *ListController) AcceptInvitation(c *gin.Context) {
+	ctrl.respondToInvitation(c, models.MemberAccepted)
+}
+
+func (ctrl *ListController) DeclineInvitation(c *gin.Context) {
+	ctrl.respondToInvitation(c, models.MemberDeclined)
+}
+
+func (ctrl *ListController) respondToInvitation(c *gin.Context, status string) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	listID, err := strconv.Atoi(c.Param("listId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid list ID"})
+		return
+	}
+
+	member, err := ctrl.members.Get(listID, userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get list membership")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to respond to invitation"})
+		return
+	}
+	if member == nil || member.Status != models.MemberPending {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+
+	if err := ctrl.members.Respond(listID, userID.(int), status); err != nil {
+		log.Error().Err(err).Msg("Failed to respond to invitation")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to respond to invitation"})
+		return
+	}
+
+	member.Status = status
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": member})
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"movie-microservices/watchlist-service/internal/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Limits for the continue watching row
+const (
+	defaultContinueLimit = 20
+	maxContinueLimit     = 100
+)
+
+// RecordProgress is the playback heartbeat, meant to be called every few
+// seconds while a movie plays. It only writes to Redis.
+func (ctrl *HistoryController) RecordProgress(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	movieID, err := strconv.Atoi(c.Param("movieId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+		return
+	}
+
+	var req models.ProgressHeartbeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	progress, err := ctrl.progress.Heartbeat(c.Request.Context(), userID.(int), movieID, *req.Position, req.Runtime, req.Device)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record playback progress")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": progress})
+}
+
+// GetProgress returns where the user left off in a movie
+func (ctrl *HistoryController) GetProgress(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	movieID, err := strconv.Atoi(c.Param("movieId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+		return
+	}
+
+	progress, err := ctrl.progress.Get(c.Request.Context(), userID.(int), movieID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get playback progress")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get progress"})
+		return
+	}
+
+	if progress == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No progress for this movie"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": progress})
+}
+
+// ContinueWatching lists the user's unfinished movies, most recent first
+func (ctrl *HistoryController) ContinueWatching(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	limit, ok := parseIntParam(c, "limit")
+	if !ok {
+		return
+	}
+	if limit == 0 {
+		limit = defaultContinueLimit
+	} else if limit > maxContinueLimit {
+		limit = maxContinueLimit
+	}
+
+	items, err := ctrl.progress.Continue(c.Request.Context(), userID.(int), limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get continue watching")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get continue watching"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": items})
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseWatchlistQuery reads the sort, pagination and filter parameters of a
+// list items request. It writes a 400 response and returns false on invalid
+// input.
+func parseWatchlistQuery(c *gin.Context) (repository.WatchlistQuery, bool) {
+	q := repository.WatchlistQuery{
+		Sort:   c.DefaultQuery("sort", repository.SortPosition),
+		Cursor: c.Query("cursor"),
+		Tag:    c.Query("tag"),
+	}
+
+	if q.Sort != repository.SortPosition && q.Sort != repository.SortPriority && q.Sort != repository.SortDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, expected position, priority or date"})
+		return q, false
+	}
+
+	var ok bool
+	if q.Limit, ok = parseIntParam(c, "limit"); !ok {
+		return q, false
+	}
+	if q.MovieID, ok = parseIntParam(c, "movieId"); !ok {
+		return q, false
+	}
+	if c.Query("priority") != "" {
+		priority, ok := parseIntParam(c, "priority")
+		if !ok {
+			return q, false
+		}
+		q.Priority = &priority
+	}
+	if q.From, q.To, ok = parseDateRange(c); !ok {
+		return q, false
+	}
+
+	return q, true
+}
+
+// parseHistoryQuery reads the pagination and filter parameters of a history
+// request. It writes a 400 response and returns false on invalid input.
+func parseHistoryQuery(c *gin.Context) (repository.HistoryQuery, bool) {
+	q := repository.HistoryQuery{
+		Cursor: c.Query("cursor"),
+		Action: c.Query("action"),
+	}
+
+	var ok bool
+	if q.Limit, ok = parseIntParam(c, "limit"); !ok {
+		return q, false
+	}
+	if q.MovieID, ok = parseIntParam(c, "movieId"); !ok {
+		return q, false
+	}
+	if q.From, q.To, ok = parseDateRange(c); !ok {
+		return q, false
+	}
+
+	return q, true
+}
+
+// parseSessionQuery reads the pagination and filter parameters of a watch
+// sessions request. It writes a 400 response and returns false on invalid
+// input.
+func parseSessionQuery(c *gin.Context) (repository.SessionQuery, bool) {
+	q := repository.SessionQuery{
+		Cursor: c.Query("cursor"),
+		Status: c.Query("status"),
+	}
+
+	switch q.Status {
+	case "", models.SessionPlaying, models.SessionPaused, models.SessionFinished, models.SessionAbandoned:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, expected playing, paused, finished or abandoned"})
+		return q, false
+	}
+
+	var ok bool
+	if q.Limit, ok = parseIntParam(c, "limit"); !ok {
+		return q, false
+	}
+	if q.MovieID, ok = parseIntParam(c, "movieId"); !ok {
+		return q, false
+	}
+	if q.From, q.To, ok = parseDateRange(c); !ok {
+		return q, false
+	}
+
+	return q, true
+}
+
+func parseIntParam(c *gin.Context, name string) (int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, true
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+		return 0, false
+	}
+	return v, true
+}
+
+// parseDateRange reads the from and to parameters as RFC 3339 timestamps or
+// plain dates. A plain "to" date includes the whole day.
+func parseDateRange(c *gin.Context) (*time.Time, *time.Time, bool) {
+	var from, to *time.Time
+	if raw := c.Query("from"); raw != "" {
+		t, _, err := parseTime(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC 3339 or YYYY-MM-DD"})
+			return nil, nil, false
+		}
+		from = &t
+	}
+	if raw := c.Query("to"); raw != "" {
+		t, dateOnly, err := parseTime(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC 3339 or YYYY-MM-DD"})
+			return nil, nil, false
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = &t
+	}
+	return from, to, true
+}
+
+func parseTime(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	return t, true, err
+}
+
+func watchlistQueryShape(q repository.WatchlistQuery) string {
+	return fmt.Sprintf("sort=%s&limit=%d&cursor=%s&movieId=%d&tag=%s&priority=%s&from=%s&to=%s",
+		q.Sort, q.Limit, q.Cursor, q.MovieID, q.Tag, formatIntPtr(q.Priority), formatTimePtr(q.From), formatTimePtr(q.To))
+}
+
+func historyQueryShape(q repository.HistoryQuery) string {
+	return fmt.Sprintf("limit=%d&cursor=%s&action=%s&movieId=%d&from=%s&to=%s",
+		q.Limit, q.Cursor, q.Action, q.MovieID, formatTimePtr(q.From), formatTimePtr(q.To))
+}
+
+func sessionQueryShape(q repository.SessionQuery) string {
+	return fmt.Sprintf("sessions?limit=%d&cursor=%s&status=%s&movieId=%d&from=%s&to=%s",
+		q.Limit, q.Cursor, q.Status, q.MovieID, formatTimePtr(q.From), formatTimePtr(q.To))
+}
+
+// statsQueryShape includes the day because the current streak changes with
+// it
+func statsQueryShape(q repository.StatsQuery) string {
+	return fmt.Sprintf("stats?from=%s&to=%s&today=%s",
+		formatTimePtr(q.From), formatTimePtr(q.To), q.Today.Format("2006-01-02"))
+}
+
+func formatIntPtr(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+type watchlistPage struct {
+	Items      []*models.WatchlistItem `json:"items"`
+	NextCursor string                  `json:"nextCursor"`
+}
+
+type historyPage struct {
+	Items      []*models.HistoryItem `json:"items"`
+	NextCursor string                `json:"nextCursor"`
+}
+
+type sessionPage struct {
+	Items      []*models.WatchSession `json:"items"`
+	NextCursor string                 `json:"nextCursor"`
+}
+
+// nullableCursor renders an empty next-page cursor as JSON null
+func nullableCursor(cursor string) interface{} {
+	if cursor == "" {
+		return nil
+	}
+	return cursor
+}
+
+func listCacheScope(listID int) string {
+	return "watchlist:list:" + strconv.Itoa(listID)
+}
+
+func historyCacheScope(userID int) string {
+	return "history:" + strconv.Itoa(userID)
+}
+
+// queryCacheKey derives the cache key for one query shape within a scope
+func queryCacheKey(shape string) string {
+	sum := sha1.Sum([]byte(shape))
+	return "q:" + hex.EncodeToString(sum[:8])
+}
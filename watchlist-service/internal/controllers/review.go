@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"errors"
+	"movie-microservices/watchlist-service/internal/review"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// minReviewYear is the earliest year a review can be asked for
+const minReviewYear = 2000
+
+// GetYearReview returns the user's year in movies, building and storing it
+// on first request
+func (ctrl *HistoryController) GetYearReview(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	year, ok := parseYearParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := ctrl.reviews.Get(c.Request.Context(), userID.(int), year)
+	if err != nil {
+		if errors.Is(err, review.ErrFutureYear) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Year has not started yet"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to get year in review")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get year in review"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
+
+// PrecomputeYearReviews starts building the year's reports for every user
+// with activity in it. Admin only.
+func (ctrl *HistoryController) PrecomputeYearReviews(c *gin.Context) {
+	year, ok := parseYearParam(c)
+	if !ok {
+		return
+	}
+
+	users, err := ctrl.reviews.Precompute(year)
+	if err != nil {
+		switch {
+		case errors.Is(err, review.ErrFutureYear):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Year has not started yet"})
+		case errors.Is(err, review.ErrPrecomputeRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": "A precompute run is already in progress"})
+		default:
+			log.Error().Err(err).Msg("Failed to start year in review precompute")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start precompute"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": gin.H{"year": year, "users": users}})
+}
+
+func parseYearParam(c *gin.Context) (int, bool) {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil || year < minReviewYear {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+		return 0, false
+	}
+	return year, true
+}
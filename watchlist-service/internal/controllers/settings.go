@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// SettingsController handles the user's watchlist-service preferences
+type SettingsController struct {
+	repo repository.SettingsRepository
+}
+
+func NewSettingsController(repo repository.SettingsRepository) *SettingsController {
+	return &SettingsController{repo: repo}
+}
+
+func (ctrl *SettingsController) GetSettings(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	settings, err := ctrl.repo.Get(userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get settings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": settings})
+}
+
+// UpdateSettings sets what happens to a movie on the default list when the
+// user finishes it: keep it, remove it, or move it to the Watched list
+func (ctrl *SettingsController) UpdateSettings(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := ctrl.repo.Update(userID.(int), req.OnFinish)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update settings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": settings})
+}
@@ -0,0 +1,420 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/movies"
+	"movie-microservices/watchlist-service/internal/repository"
+	"movie-microservices/watchlist-service/internal/transfer"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Size caps for uploaded import files. Viewing-activity exports cover
+// years of sessions, so history files get more room.
+const (
+	maxImportSize        = 5 << 20
+	maxHistoryImportSize = 25 << 20
+)
+
+// ExportWatchlist streams one list in the requested format
+func (ctrl *WatchlistController) ExportWatchlist(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	format := c.DefaultQuery("format", transfer.FormatJSON)
+	if !transfer.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": transfer.ErrUnknownFormat.Error()})
+		return
+	}
+
+	list := ctrl.resolveList(c, userID.(int), models.RoleViewer)
+	if list == nil {
+		return
+	}
+
+	ctrl.export(c, format, "watchlist-"+strconv.Itoa(list.ID), []*models.List{list})
+}
+
+// ExportLists streams every list the user owns or has joined. Only the
+// native JSON format can hold more than one list.
+func (ctrl *WatchlistController) ExportLists(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	format := c.DefaultQuery("format", transfer.FormatJSON)
+	if !transfer.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": transfer.ErrUnknownFormat.Error()})
+		return
+	}
+	if !transfer.MultiList(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV formats hold a single list, export it from /api/lists/:listId/export"})
+		return
+	}
+
+	lists, err := ctrl.lists.GetByUserID(userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get lists")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lists"})
+		return
+	}
+
+	ctrl.export(c, format, "lists", lists)
+}
+
+// export writes lists page by page. Errors before the first bytes reach the
+// client are reported as JSON; after that the response is cut short.
+func (ctrl *WatchlistController) export(c *gin.Context, format, filename string, lists []*models.List) {
+	c.Header("Content-Type", transfer.ContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+"."+transfer.Extension(format)+`"`)
+
+	fail := func(status int, message string, err error) {
+		log.Error().Err(err).Msg(message)
+		if c.Writer.Written() {
+			c.Abort()
+			return
+		}
+		c.Writer.Header().Del("Content-Disposition")
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.JSON(status, gin.H{"error": message})
+	}
+
+	exporter, err := transfer.NewExporter(format, c.Writer)
+	if err != nil {
+		fail(http.StatusBadRequest, "Failed to export watchlist", err)
+		return
+	}
+
+	for _, list := range lists {
+		if err := exporter.BeginList(list); err != nil {
+			fail(http.StatusInternalServerError, "Failed to export watchlist", err)
+			return
+		}
+
+		query := repository.WatchlistQuery{Sort: repository.SortPosition, Limit: repository.MaxPageLimit}
+		for {
+			items, next, err := ctrl.repo.GetByListID(list.ID, query)
+			if err != nil {
+				fail(http.StatusInternalServerError, "Failed to get watchlist", err)
+				return
+			}
+
+			var found map[int]*movies.Movie
+			if transfer.NeedsMovies(format) && len(items) > 0 {
+				ids := make([]int, len(items))
+				for i, item := range items {
+					ids[i] = item.MovieID
+				}
+				found, err = ctrl.movies.GetMany(c.Request.Context(), ids)
+				if err != nil {
+					fail(http.StatusBadGateway, "Failed to look up movies", err)
+					return
+				}
+			}
+
+			for _, item := range items {
+				if err := exporter.WriteItem(item, found[item.MovieID]); err != nil {
+					fail(http.StatusInternalServerError, "Failed to export watchlist", err)
+					return
+				}
+			}
+
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+	}
+
+	if err := exporter.Close(); err != nil {
+		fail(http.StatusInternalServerError, "Failed to export watchlist", err)
+	}
+}
+
+// ImportWatchlist adds the movies in an uploaded file to a list. The file
+// is sent as the "file" field of a multipart form or as the raw body. With
+// dryRun=true nothing is written and the report shows what would happen.
+func (ctrl *WatchlistController) ImportWatchlist(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	format := c.Query("format")
+	if !transfer.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": transfer.ErrUnknownFormat.Error()})
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dryRun value"})
+		return
+	}
+
+	list := ctrl.resolveList(c, userID.(int), models.RoleEditor)
+	if list == nil {
+		return
+	}
+
+	body, err := importBody(c, maxImportSize)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+	defer body.Close()
+
+	entries, err := transfer.Parse(format, body)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	// Resolve entries that don't already carry one of our movie IDs
+	var pending []*transfer.Entry
+	var refs []movies.Ref
+	for _, entry := range entries {
+		if entry.MovieID == 0 {
+			pending = append(pending, entry)
+			refs = append(refs, entry.Ref)
+		}
+	}
+	if len(refs) > 0 {
+		resolved, err := ctrl.movies.ResolveMany(c.Request.Context(), refs)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resolve imported movies")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to look up movies"})
+			return
+		}
+		for i, movie := range resolved {
+			if movie != nil {
+				pending[i].MovieID = movie.ID
+			}
+		}
+	}
+
+	report := transfer.NewReport(dryRun)
+	seen := make(map[int]bool, len(entries))
+	var candidates []*transfer.Entry
+	var movieIDs []int
+	for _, entry := range entries {
+		if entry.MovieID == 0 {
+			report.Unmapped = append(report.Unmapped, entry.ReportEntry(transfer.ReasonUnmapped))
+			continue
+		}
+		if seen[entry.MovieID] {
+			report.Skipped = append(report.Skipped, entry.ReportEntry(transfer.ReasonDuplicate))
+			continue
+		}
+		seen[entry.MovieID] = true
+		candidates = append(candidates, entry)
+		movieIDs = append(movieIDs, entry.MovieID)
+	}
+
+	existing := map[int]bool{}
+	if len(movieIDs) > 0 {
+		existing, err = ctrl.repo.ExistsMany(list.ID, movieIDs)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check watchlist existence")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check watchlist"})
+			return
+		}
+	}
+
+	var toAdd []*transfer.Entry
+	var items []*models.WatchlistItem
+	for _, entry := range candidates {
+		if existing[entry.MovieID] {
+			report.Skipped = append(report.Skipped, entry.ReportEntry(transfer.ReasonExists))
+			continue
+		}
+		item := &models.WatchlistItem{
+			MovieID:  entry.MovieID,
+			Note:     entry.Note,
+			Priority: entry.Priority,
+			Tags:     importTags(entry.Tags),
+		}
+		if entry.AddedAt != nil {
+			item.CreatedAt = *entry.AddedAt
+		}
+		toAdd = append(toAdd, entry)
+		items = append(items, item)
+	}
+
+	if dryRun || len(items) == 0 {
+		for _, entry := range toAdd {
+			report.Added = append(report.Added, entry.ReportEntry(""))
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+		return
+	}
+
+	inserted, err := ctrl.repo.Import(list.ID, userID.(int), items)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to import watchlist")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import watchlist"})
+		return
+	}
+
+	added := make(map[int]bool, len(inserted))
+	for _, item := range inserted {
+		added[item.MovieID] = true
+	}
+	for _, entry := range toAdd {
+		if added[entry.MovieID] {
+			report.Added = append(report.Added, entry.ReportEntry(""))
+		} else {
+			// Added by someone else since the existence check
+			report.Skipped = append(report.Skipped, entry.ReportEntry(transfer.ReasonExists))
+		}
+	}
+
+	if len(inserted) > 0 {
+		ctrl.cache.Invalidate(c.Request.Context(), listCacheScope(list.ID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
+
+// importBody returns the uploaded file, capped at limit bytes
+func importBody(c *gin.Context, limit int64) (io.ReadCloser, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		return c.Request.Body, nil
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, errors.New("multipart uploads need a \"file\" field")
+	}
+	return header.Open()
+}
+
+func respondImportError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file is too large"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// ImportHistory starts an asynchronous import of a Netflix ViewingActivity.csv
+// (format=netflix, optionally limited to one profile with profile=) or a
+// Trakt history export (format=trakt). The file is parsed up front so
+// malformed uploads are rejected immediately; the job is then polled
+// through GetImportJob.
+func (ctrl *HistoryController) ImportHistory(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	format := c.Query("format")
+	if !transfer.ValidHistoryFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": transfer.ErrUnknownHistoryFormat.Error()})
+		return
+	}
+
+	if _, err := ctrl.jobs.FailStale("Import stopped responding", transfer.StaleJobTimeout); err != nil {
+		log.Warn().Err(err).Msg("Failed to expire stale history imports")
+	}
+
+	active, err := ctrl.jobs.GetActive(userID.(int))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get history import jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+		return
+	}
+	// Checked up front so a second upload isn't parsed for nothing; the
+	// unique index on active jobs settles concurrent uploads
+	if active != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A history import is already running", "jobId": active.ID})
+		return
+	}
+
+	body, err := importBody(c, maxHistoryImportSize)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+	defer body.Close()
+
+	file, err := transfer.ParseHistory(format, body, c.Query("profile"))
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	job, err := ctrl.importer.Start(userID.(int), format, file)
+	if err != nil {
+		if errors.Is(err, repository.ErrImportRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A history import is already running"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to start history import")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": job})
+}
+
+// GetImportJob reports the progress of one of the user's history imports
+func (ctrl *HistoryController) GetImportJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	jobID, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := ctrl.jobs.Get(jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get history import job")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get import job"})
+		return
+	}
+	if job == nil || job.UserID != userID.(int) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": job})
+}
+
+// importTags applies the same limits as UpdateWatchlistItemRequest, dropping
+// what doesn't fit instead of rejecting the row
+func importTags(tags []string) []string {
+	normalized := normalizeTags(tags)
+	kept := normalized[:0]
+	for _, tag := range normalized {
+		if utf8.RuneCountInString(tag) <= 50 && len(kept) < 20 {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
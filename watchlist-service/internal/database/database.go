@@ -52,6 +52,282 @@ func RunMigrations(db *sql.DB) error {
         return fmt.Errorf("failed to create history table: %w", err)
     }
 
+    // Create lists table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS lists (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            name VARCHAR(100) NOT NULL,
+            is_default BOOLEAN NOT NULL DEFAULT false,
+            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(user_id, name)
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create lists table: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_lists_user_default ON lists(user_id) WHERE is_default;
+    `); err != nil {
+        return fmt.Errorf("failed to create lists default index: %w", err)
+    }
+
+    // Create list members table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS list_members (
+            id SERIAL PRIMARY KEY,
+            list_id INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+            user_id INTEGER NOT NULL,
+            role VARCHAR(20) NOT NULL,
+            status VARCHAR(20) NOT NULL DEFAULT 'pending',
+            invited_by INTEGER NOT NULL,
+            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            responded_at TIMESTAMP WITH TIME ZONE,
+            UNIQUE(list_id, user_id)
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create list_members table: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_list_members_user_id ON list_members(user_id);
+    `); err != nil {
+        return fmt.Errorf("failed to create list_members user_id index: %w", err)
+    }
+
+    // Create history import jobs table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS history_import_jobs (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            format VARCHAR(20) NOT NULL,
+            status VARCHAR(20) NOT NULL DEFAULT 'pending',
+            total INTEGER NOT NULL DEFAULT 0,
+            processed INTEGER NOT NULL DEFAULT 0,
+            imported INTEGER NOT NULL DEFAULT 0,
+            duplicates INTEGER NOT NULL DEFAULT 0,
+            skipped INTEGER NOT NULL DEFAULT 0,
+            unmapped INTEGER NOT NULL DEFAULT 0,
+            unmapped_titles TEXT[] NOT NULL DEFAULT '{}',
+            error TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            finished_at TIMESTAMP WITH TIME ZONE
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create history_import_jobs table: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_history_import_jobs_user ON history_import_jobs(user_id, created_at DESC);
+    `); err != nil {
+        return fmt.Errorf("failed to create history_import_jobs user_id index: %w", err)
+    }
+
+    // A user has at most one pending or running import. Fail all but the
+    // newest active job left over from before the index existed.
+    if _, err := db.Exec(`
+        UPDATE history_import_jobs j
+        SET status = 'failed', error = 'Superseded by a newer import', finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+        WHERE j.status IN ('pending', 'running') AND EXISTS (
+            SELECT 1 FROM history_import_jobs n
+            WHERE n.user_id = j.user_id AND n.status IN ('pending', 'running') AND n.id > j.id
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_history_import_jobs_active ON history_import_jobs(user_id)
+        WHERE status IN ('pending', 'running');
+    `); err != nil {
+        return fmt.Errorf("failed to create history_import_jobs active index: %w", err)
+    }
+
+    // Create playback progress table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS playback_progress (
+            user_id INTEGER NOT NULL,
+            movie_id INTEGER NOT NULL,
+            position_seconds INTEGER NOT NULL,
+            runtime_seconds INTEGER NOT NULL,
+            percent REAL NOT NULL,
+            device VARCHAR(100) NOT NULL DEFAULT '',
+            finished BOOLEAN NOT NULL DEFAULT false,
+            updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            PRIMARY KEY (user_id, movie_id)
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create playback_progress table: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_playback_progress_user_updated ON playback_progress(user_id, updated_at DESC) WHERE NOT finished;
+    `); err != nil {
+        return fmt.Errorf("failed to create playback_progress user_id index: %w", err)
+    }
+
+    // Create watch sessions table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS watch_sessions (
+            id SERIAL PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            movie_id INTEGER NOT NULL,
+            status VARCHAR(20) NOT NULL,
+            is_rewatch BOOLEAN NOT NULL DEFAULT false,
+            started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            ended_at TIMESTAMP WITH TIME ZONE,
+            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create watch_sessions table: %w", err)
+    }
+
+    // Restrict history to the action vocabulary. Free-form actions written
+    // before it existed are mapped onto it first; the constraint is added
+    // NOT VALID so rows that still don't fit are kept rather than blocking
+    // startup.
+    if _, err := db.Exec(`
+        ALTER TABLE history ADD COLUMN IF NOT EXISTS session_id INTEGER REFERENCES watch_sessions(id) ON DELETE SET NULL;
+        UPDATE history SET action = LOWER(TRIM(action)) WHERE action <> LOWER(TRIM(action));
+        UPDATE history SET action = 'finished' WHERE action IN ('watched', 'completed', 'complete', 'finish', 'seen');
+        UPDATE history SET action = 'started' WHERE action IN ('start', 'play', 'view', 'viewed', 'watching');
+        UPDATE history SET action = 'paused' WHERE action = 'pause';
+        UPDATE history SET action = 'resumed' WHERE action = 'resume';
+        UPDATE history SET action = 'abandoned' WHERE action IN ('abandon', 'stopped', 'dropped');
+        UPDATE history SET action = 'rewatched' WHERE action = 'rewatch';
+        DO $$
+        BEGIN
+            IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'history_action_check') THEN
+                ALTER TABLE history ADD CONSTRAINT history_action_check
+                    CHECK (action IN ('started', 'paused', 'resumed', 'finished', 'abandoned', 'rewatched')) NOT VALID;
+            END IF;
+        END $$;
+    `); err != nil {
+        return fmt.Errorf("failed to migrate history actions: %w", err)
+    }
+
+    // Create user settings table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS user_settings (
+            user_id INTEGER PRIMARY KEY,
+            on_finish VARCHAR(20) NOT NULL DEFAULT 'keep',
+            updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create user_settings table: %w", err)
+    }
+
+    // Create year in review reports table
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS year_reviews (
+            user_id INTEGER NOT NULL,
+            year INTEGER NOT NULL,
+            report JSONB NOT NULL,
+            generated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (user_id, year)
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create year_reviews table: %w", err)
+    }
+
+    // Create events outbox table, written in the same transaction as the
+    // change an event describes
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS outbox (
+            id BIGSERIAL PRIMARY KEY,
+            event_id UUID NOT NULL DEFAULT gen_random_uuid(),
+            event_type VARCHAR(100) NOT NULL,
+            version INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            movie_id INTEGER NOT NULL,
+            data JSONB NOT NULL DEFAULT '{}',
+            occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            last_error TEXT NOT NULL DEFAULT '',
+            published_at TIMESTAMP WITH TIME ZONE
+        );
+    `); err != nil {
+        return fmt.Errorf("failed to create outbox table: %w", err)
+    }
+
+    // Scope watchlist items to a list. Rows created before lists existed
+    // are moved into their owner's default list.
+    if _, err := db.Exec(`
+        ALTER TABLE watchlists ADD COLUMN IF NOT EXISTS list_id INTEGER REFERENCES lists(id) ON DELETE CASCADE;
+    `); err != nil {
+        return fmt.Errorf("failed to add watchlists list_id column: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        INSERT INTO lists (user_id, name, is_default)
+        SELECT DISTINCT user_id, 'Watchlist', true
+        FROM watchlists
+        WHERE list_id IS NULL
+        ON CONFLICT DO NOTHING;
+    `); err != nil {
+        return fmt.Errorf("failed to create default lists: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        UPDATE watchlists w
+        SET list_id = l.id
+        FROM lists l
+        WHERE w.list_id IS NULL AND l.user_id = w.user_id AND l.is_default;
+    `); err != nil {
+        return fmt.Errorf("failed to backfill watchlists list_id: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        ALTER TABLE watchlists ALTER COLUMN list_id SET NOT NULL;
+        ALTER TABLE watchlists DROP CONSTRAINT IF EXISTS watchlists_user_id_movie_id_key;
+    `); err != nil {
+        return fmt.Errorf("failed to update watchlists constraints: %w", err)
+    }
+
+    // Item annotations and manual ordering. Positions are sparse floats so a
+    // reorder only rewrites the moved row; existing rows keep their
+    // newest-first order.
+    if _, err := db.Exec(`
+        ALTER TABLE watchlists ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';
+        ALTER TABLE watchlists ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+        ALTER TABLE watchlists ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+        ALTER TABLE watchlists ADD COLUMN IF NOT EXISTS position DOUBLE PRECISION;
+        ALTER TABLE watchlists ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+    `); err != nil {
+        return fmt.Errorf("failed to add watchlists item columns: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        UPDATE watchlists w
+        SET position = r.rn
+        FROM (
+            SELECT id, ROW_NUMBER() OVER (PARTITION BY list_id ORDER BY created_at DESC) AS rn
+            FROM watchlists
+            WHERE position IS NULL
+        ) r
+        WHERE w.id = r.id;
+        ALTER TABLE watchlists ALTER COLUMN position SET NOT NULL;
+    `); err != nil {
+        return fmt.Errorf("failed to backfill watchlists position: %w", err)
+    }
+
+    // Log every addition to a watchlist, so stats and reviews still see
+    // movies that were removed or moved since. Items already on a list are
+    // backfilled; the log can't know about earlier removals.
+    if _, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS watchlist_additions (
+            id BIGSERIAL PRIMARY KEY,
+            user_id INTEGER NOT NULL,
+            movie_id INTEGER NOT NULL,
+            added_at TIMESTAMP WITH TIME ZONE NOT NULL,
+            UNIQUE (user_id, movie_id, added_at)
+        );
+        INSERT INTO watchlist_additions (user_id, movie_id, added_at)
+        SELECT user_id, movie_id, created_at
+        FROM watchlists
+        WHERE created_at IS NOT NULL
+        ON CONFLICT DO NOTHING;
+    `); err != nil {
+        return fmt.Errorf("failed to create watchlist_additions table: %w", err)
+    }
+
     // Create indexes
     if _, err := db.Exec(`
         CREATE INDEX IF NOT EXISTS idx_watchlists_user_id ON watchlists(user_id);
@@ -59,11 +335,65 @@ func RunMigrations(db *sql.DB) error {
         return fmt.Errorf("failed to create watchlists user_id index: %w", err)
     }
 
+    if _, err := db.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_watchlists_list_movie ON watchlists(list_id, movie_id);
+    `); err != nil {
+        return fmt.Errorf("failed to create watchlists list_id index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_watchlists_list_position ON watchlists(list_id, position);
+    `); err != nil {
+        return fmt.Errorf("failed to create watchlists position index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_watchlist_additions_added_at ON watchlist_additions(added_at);
+    `); err != nil {
+        return fmt.Errorf("failed to create watchlist_additions added_at index: %w", err)
+    }
+
     if _, err := db.Exec(`
         CREATE INDEX IF NOT EXISTS idx_history_user_id ON history(user_id);
     `); err != nil {
         return fmt.Errorf("failed to create history user_id index: %w", err)
     }
 
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_history_user_created ON history(user_id, created_at DESC, id DESC);
+    `); err != nil {
+        return fmt.Errorf("failed to create history user_id created_at index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_history_session_id ON history(session_id) WHERE session_id IS NOT NULL;
+    `); err != nil {
+        return fmt.Errorf("failed to create history session_id index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_watch_sessions_open ON watch_sessions(user_id, movie_id) WHERE status IN ('playing', 'paused');
+    `); err != nil {
+        return fmt.Errorf("failed to create watch_sessions open index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_watch_sessions_user_started ON watch_sessions(user_id, started_at DESC, id DESC);
+    `); err != nil {
+        return fmt.Errorf("failed to create watch_sessions user_id index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_outbox_unpublished ON outbox(id) WHERE published_at IS NULL;
+    `); err != nil {
+        return fmt.Errorf("failed to create outbox unpublished index: %w", err)
+    }
+
+    if _, err := db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_outbox_published ON outbox(published_at) WHERE published_at IS NOT NULL;
+    `); err != nil {
+        return fmt.Errorf("failed to create outbox published index: %w", err)
+    }
+
     return nil
 }
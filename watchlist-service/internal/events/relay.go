@@ -0,0 +1,120 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/config"
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxBackoff caps the wait between attempts while publishing fails
+	maxBackoff = time.Minute
+
+	// cleanupInterval is how often published outbox rows are pruned
+	cleanupInterval = time.Hour
+
+	// defaultPollInterval and defaultBatchSize replace settings that aren't
+	// positive, which would have the relay poll Postgres in a busy loop
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+)
+
+// Relay publishes outbox events to a Redis Stream. Each stream entry holds
+// the event's id, type and version as fields and the JSON envelope as
+// "payload". An event is marked published only after Redis accepted it, so
+// delivery is at least once: consumers should discard event IDs they have
+// already seen.
+type Relay struct {
+	outbox repository.OutboxRepository
+	rdb    *redis.Client
+	cfg    config.EventsConfig
+}
+
+func NewRelay(outbox repository.OutboxRepository, rdb *redis.Client, cfg config.EventsConfig) *Relay {
+	if cfg.PollInterval <= 0 {
+		log.Warn().Dur("pollInterval", cfg.PollInterval).Dur("default", defaultPollInterval).Msg("Outbox poll interval must be positive, using the default")
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		log.Warn().Int("batchSize", cfg.BatchSize).Int("default", defaultBatchSize).Msg("Outbox batch size must be positive, using the default")
+		cfg.BatchSize = defaultBatchSize
+	}
+	return &Relay{outbox: outbox, rdb: rdb, cfg: cfg}
+}
+
+// Run publishes pending events until ctx is cancelled. It polls every
+// PollInterval, keeps going without waiting while full batches come back,
+// and backs off exponentially while publishing fails. Anything left unsent
+// stays in the outbox for the next run.
+func (r *Relay) Run(ctx context.Context) {
+	backoff := r.cfg.PollInterval
+	lastCleanup := time.Time{}
+
+	for {
+		published, err := r.outbox.Publish(r.cfg.BatchSize, func(events []*models.Event) error {
+			return r.publish(ctx, events)
+		})
+
+		wait := r.cfg.PollInterval
+		if err != nil {
+			wait = backoff
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			log.Error().Err(err).Dur("retryIn", wait).Msg("Failed to publish outbox events")
+		} else {
+			backoff = r.cfg.PollInterval
+			if published == r.cfg.BatchSize {
+				wait = 0
+			}
+		}
+
+		if time.Since(lastCleanup) >= cleanupInterval {
+			lastCleanup = time.Now()
+			if deleted, err := r.outbox.DeletePublished(time.Now().Add(-r.cfg.Retention)); err != nil {
+				log.Warn().Err(err).Msg("Failed to prune published outbox events")
+			} else if deleted > 0 {
+				log.Info().Int64("deleted", deleted).Msg("Pruned published outbox events")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// publish adds events to the stream in order in one round trip
+func (r *Relay) publish(ctx context.Context, events []*models.Event) error {
+	pipe := r.rdb.Pipeline()
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.cfg.Stream,
+			MaxLen: r.cfg.MaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"id":      e.ID,
+				"type":    e.Type,
+				"version": strconv.Itoa(e.Version),
+				"payload": payload,
+			},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -47,7 +47,7 @@ func Logger() gin.HandlerFunc {
 func CORS() gin.HandlerFunc {
     return func(c *gin.Context) {
         c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-        c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+        c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
         c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
         if c.Request.Method == "OPTIONS" {
@@ -70,8 +70,9 @@ func RateLimiter() gin.HandlerFunc {
 
 
 
-// Authenticate validates JWT token from the Authorization header
-func Authenticate() gin.HandlerFunc {
+// Authenticate validates JWT token from the Authorization header. When roles
+// are given, the token's role claim must be one of them.
+func Authenticate(roles ...string) gin.HandlerFunc {
     return func(c *gin.Context) {
         tokenString := c.GetHeader("Authorization")
         if tokenString == "" {
@@ -115,6 +116,23 @@ func Authenticate() gin.HandlerFunc {
                 c.Abort()
                 return
             }
+
+            if len(roles) > 0 {
+                userRole, _ := claims["role"].(string)
+                roleAllowed := false
+                for _, role := range roles {
+                    if userRole == role {
+                        roleAllowed = true
+                        break
+                    }
+                }
+                if !roleAllowed {
+                    c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+                    c.Abort()
+                    return
+                }
+            }
+
             c.Set("userID", int(userID))
             c.Next()
         } else {
@@ -2,26 +2,336 @@ package models
 
 import "time"
 
+// Roles a user can hold on a list, from least to most privileged
+const (
+    RoleViewer = "viewer"
+    RoleEditor = "editor"
+    RoleOwner  = "owner"
+)
+
+// Membership states of an invitation to a shared list
+const (
+    MemberPending  = "pending"
+    MemberAccepted = "accepted"
+    MemberDeclined = "declined"
+)
+
+type List struct {
+    ID        int       `json:"id"`
+    UserID    int       `json:"userId"`
+    Name      string    `json:"name"`
+    IsDefault bool      `json:"isDefault"`
+    Role      string    `json:"role,omitempty"`
+    ItemCount int       `json:"itemCount"`
+    CreatedAt time.Time `json:"createdAt"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type ListMember struct {
+    ID          int        `json:"id"`
+    ListID      int        `json:"listId"`
+    ListName    string     `json:"listName,omitempty"`
+    UserID      int        `json:"userId"`
+    Role        string     `json:"role"`
+    Status      string     `json:"status"`
+    InvitedBy   int        `json:"invitedBy"`
+    CreatedAt   time.Time  `json:"createdAt"`
+    RespondedAt *time.Time `json:"respondedAt,omitempty"`
+}
+
+// Priority levels for watchlist items
+const (
+    PriorityNone   = 0
+    PriorityLow    = 1
+    PriorityMedium = 2
+    PriorityHigh   = 3
+)
+
 type WatchlistItem struct {
     ID        int       `json:"id"`
+    ListID    int       `json:"listId"`
     UserID    int       `json:"userId"`
     MovieID   int       `json:"movieId"`
+    Note      string    `json:"note"`
+    Priority  int       `json:"priority"`
+    Tags      []string  `json:"tags"`
+    Position  float64   `json:"position"`
     CreatedAt time.Time `json:"createdAt"`
+    UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// History actions, recorded as a movie is watched
+const (
+    ActionStarted   = "started"
+    ActionPaused    = "paused"
+    ActionResumed   = "resumed"
+    ActionFinished  = "finished"
+    ActionAbandoned = "abandoned"
+    ActionRewatched = "rewatched"
+)
+
+// States of a watch session. Playing and paused sessions are open; a user
+// has at most one open session per movie.
+const (
+    SessionPlaying   = "playing"
+    SessionPaused    = "paused"
+    SessionFinished  = "finished"
+    SessionAbandoned = "abandoned"
+)
+
 type HistoryItem struct {
     ID        int       `json:"id"`
     UserID    int       `json:"userId"`
     MovieID   int       `json:"movieId"`
     Action    string    `json:"action"`
+    SessionID *int      `json:"sessionId"`
     CreatedAt time.Time `json:"createdAt"`
+    // Set when finishing the movie took it off the user's default list,
+    // following their OnFinish setting
+    RemovedFromList *int `json:"removedFromList,omitempty"`
+    MovedToList     *int `json:"movedToList,omitempty"`
+}
+
+// What happens to a movie on the user's default list when they finish it
+const (
+    OnFinishKeep   = "keep"
+    OnFinishRemove = "remove"
+    OnFinishMove   = "move"
+)
+
+// WatchedListName is the list finished movies are moved to with
+// OnFinishMove
+const WatchedListName = "Watched"
+
+type UserSettings struct {
+    UserID    int       `json:"userId"`
+    OnFinish  string    `json:"onFinish"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WatchSession is one viewing of a movie, from started or rewatched to
+// finished or abandoned. WatchedSeconds counts only the time spent playing;
+// ElapsedSeconds runs from the first event to the last.
+type WatchSession struct {
+    ID             int            `json:"id"`
+    UserID         int            `json:"userId"`
+    MovieID        int            `json:"movieId"`
+    Status         string         `json:"status"`
+    IsRewatch      bool           `json:"isRewatch"`
+    StartedAt      time.Time      `json:"startedAt"`
+    EndedAt        *time.Time     `json:"endedAt"`
+    WatchedSeconds int64          `json:"watchedSeconds"`
+    ElapsedSeconds int64          `json:"elapsedSeconds"`
+    Events         []*HistoryItem `json:"events"`
+}
+
+// PeriodCount is the number of movies finished in one day, week or month.
+// Period is the day, the Monday starting the week, or the month, as
+// YYYY-MM-DD or YYYY-MM.
+type PeriodCount struct {
+    Period string `json:"period"`
+    Count  int    `json:"count"`
+}
+
+// Streak is a run of consecutive days with at least one finished movie
+type Streak struct {
+    Days  int    `json:"days"`
+    Start string `json:"start,omitempty"`
+    End   string `json:"end,omitempty"`
+}
+
+// HistoryStats summarises a user's finished movies over a date range. Days
+// are UTC. CurrentStreak ignores the range and runs up to today or
+// yesterday.
+type HistoryStats struct {
+    From                     *time.Time     `json:"from"`
+    To                       *time.Time     `json:"to"`
+    Finished                 int            `json:"finished"`
+    FinishedPerDay           []*PeriodCount `json:"finishedPerDay"`
+    FinishedPerWeek          []*PeriodCount `json:"finishedPerWeek"`
+    FinishedPerMonth         []*PeriodCount `json:"finishedPerMonth"`
+    CurrentStreak            Streak         `json:"currentStreak"`
+    LongestStreak            Streak         `json:"longestStreak"`
+    Rewatches                int            `json:"rewatches"`
+    RewatchedMovies          int            `json:"rewatchedMovies"`
+    AvgDaysWatchlistToFinish *float64       `json:"avgDaysWatchlistToFinish"`
+    WatchlistToFinishSamples int            `json:"watchlistToFinishSamples"`
+}
+
+// ReviewMovie is a movie highlighted in a year in review
+type ReviewMovie struct {
+    MovieID   int       `json:"movieId"`
+    Title     string    `json:"title,omitempty"`
+    WatchedAt time.Time `json:"watchedAt"`
+}
+
+// YearReview is a user's year in movies. Months has all twelve months;
+// TopMonths the busiest of them. CompletionRatio is the share of movies
+// added to a watchlist during the year that were finished by its end.
+type YearReview struct {
+    UserID              int            `json:"userId"`
+    Year                int            `json:"year"`
+    Finished            int            `json:"finished"`
+    DistinctMovies      int            `json:"distinctMovies"`
+    Rewatches           int            `json:"rewatches"`
+    Months              []*PeriodCount `json:"months"`
+    TopMonths           []*PeriodCount `json:"topMonths"`
+    FirstMovie          *ReviewMovie   `json:"firstMovie"`
+    LastMovie           *ReviewMovie   `json:"lastMovie"`
+    LongestStreak       Streak         `json:"longestStreak"`
+    Watchlisted         int            `json:"watchlisted"`
+    WatchlistedFinished int            `json:"watchlistedFinished"`
+    CompletionRatio     float64        `json:"completionRatio"`
+    GeneratedAt         time.Time      `json:"generatedAt"`
+}
+
+// EventVersion is the version of the Event envelope. It changes only when
+// a field is removed or changes meaning; new fields may be added within a
+// version.
+const EventVersion = 1
+
+// Event types published to the events stream. History events are
+// "history." followed by the action, e.g. "history.finished".
+const (
+    EventWatchlistItemAdded   = "watchlist.item_added"
+    EventWatchlistItemRemoved = "watchlist.item_removed"
+    EventHistoryPrefix        = "history."
+)
+
+// Event is the envelope of everything published to the events stream. ID is
+// unique per event, so consumers can discard redeliveries. Data carries
+// type-specific fields: listId for watchlist events, plus reason
+// "finished" and movedToListId when a finished movie left the list; action
+// and sessionId for history events.
+type Event struct {
+    Version    int                    `json:"version"`
+    ID         string                 `json:"id"`
+    Type       string                 `json:"type"`
+    UserID     int                    `json:"userId"`
+    MovieID    int                    `json:"movieId"`
+    OccurredAt time.Time              `json:"occurredAt"`
+    Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// Progress is how far a user got into a movie. Percent runs from 0 to 100.
+type Progress struct {
+    UserID    int       `json:"userId"`
+    MovieID   int       `json:"movieId"`
+    Position  int       `json:"position"`
+    Runtime   int       `json:"runtime"`
+    Percent   float64   `json:"percent"`
+    Device    string    `json:"device,omitempty"`
+    Finished  bool      `json:"finished"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// States of a history import job
+const (
+    JobPending   = "pending"
+    JobRunning   = "running"
+    JobCompleted = "completed"
+    JobFailed    = "failed"
+)
+
+// ImportJob tracks an asynchronous history import. Every parsed row ends up
+// in exactly one of Imported, Duplicates, Skipped or Unmapped.
+type ImportJob struct {
+    ID             int        `json:"id"`
+    UserID         int        `json:"userId"`
+    Format         string     `json:"format"`
+    Status         string     `json:"status"`
+    Total          int        `json:"total"`
+    Processed      int        `json:"processed"`
+    Imported       int        `json:"imported"`
+    Duplicates     int        `json:"duplicates"`
+    Skipped        int        `json:"skipped"`
+    Unmapped       int        `json:"unmapped"`
+    UnmappedTitles []string   `json:"unmappedTitles"`
+    Error          string     `json:"error,omitempty"`
+    CreatedAt      time.Time  `json:"createdAt"`
+    UpdatedAt      time.Time  `json:"updatedAt"`
+    FinishedAt     *time.Time `json:"finishedAt,omitempty"`
+}
+
+type CreateListRequest struct {
+    Name string `json:"name" binding:"required,max=100"`
+}
+
+type UpdateListRequest struct {
+    Name string `json:"name" binding:"required,max=100"`
+}
+
+type InviteMemberRequest struct {
+    UserID int    `json:"userId" binding:"required"`
+    Role   string `json:"role" binding:"required,oneof=viewer editor"`
+}
+
+type UpdateMemberRequest struct {
+    Role string `json:"role" binding:"required,oneof=viewer editor"`
 }
 
 type AddToWatchlistRequest struct {
     MovieID int `json:"movieId" binding:"required"`
 }
 
+// CheckWatchlistRequest asks which of several movies are in a list.
+type CheckWatchlistRequest struct {
+    MovieIDs []int `json:"movieIds" binding:"required,min=1,max=200,dive,min=1"`
+}
+
+// Batch operations and the per-item outcomes reported for them
+const (
+    BatchOpAdd    = "add"
+    BatchOpRemove = "remove"
+
+    BatchStatusAdded    = "added"
+    BatchStatusRemoved  = "removed"
+    BatchStatusExists   = "exists"
+    BatchStatusNotFound = "not_found"
+)
+
+type BatchOperation struct {
+    Op      string `json:"op" binding:"required,oneof=add remove"`
+    MovieID int    `json:"movieId" binding:"required,min=1"`
+}
+
+// BatchWatchlistRequest adds and removes movies in one transaction. Operations
+// are applied in order.
+type BatchWatchlistRequest struct {
+    Operations []BatchOperation `json:"operations" binding:"required,min=1,max=200,dive"`
+}
+
+type BatchResult struct {
+    Op      string         `json:"op"`
+    MovieID int            `json:"movieId"`
+    Status  string         `json:"status"`
+    Item    *WatchlistItem `json:"item,omitempty"`
+}
+
+type UpdateWatchlistItemRequest struct {
+    Note     *string   `json:"note" binding:"omitempty,max=1000"`
+    Priority *int      `json:"priority" binding:"omitempty,min=0,max=3"`
+    Tags     *[]string `json:"tags" binding:"omitempty,max=20,dive,min=1,max=50"`
+}
+
+// MoveWatchlistItemRequest places an item directly after another one.
+// A null afterMovieId moves the item to the top of the list.
+type MoveWatchlistItemRequest struct {
+    AfterMovieID *int `json:"afterMovieId"`
+}
+
+// ProgressHeartbeatRequest reports the playback position, in seconds
+type ProgressHeartbeatRequest struct {
+    Position *int   `json:"position" binding:"required,min=0"`
+    Runtime  int    `json:"runtime" binding:"required,min=1"`
+    Device   string `json:"device" binding:"max=100"`
+}
+
+type UpdateSettingsRequest struct {
+    OnFinish string `json:"onFinish" binding:"required,oneof=keep remove move"`
+}
+
 type AddToHistoryRequest struct {
     MovieID int    `json:"movieId" binding:"required"`
-    Action  string `json:"action" binding:"required"`
+    Action  string `json:"action" binding:"required,oneof=started paused resumed finished abandoned rewatched"`
 }
@@ -0,0 +1,230 @@
+package movies
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"movie-microservices/watchlist-service/internal/config"
+)
+
+// lookupConcurrency bounds the parallel requests made to movie-service
+const lookupConcurrency = 8
+
+// Movie is the part of a movie-service record watchlist-service needs
+type Movie struct {
+	ID            int    `json:"id"`
+	TmdbID        int    `json:"tmdbId"`
+	ImdbID        string `json:"imdbId"`
+	Title         string `json:"title"`
+	OriginalTitle string `json:"originalTitle"`
+	ReleaseYear   int    `json:"releaseYear"`
+}
+
+// Ref identifies a movie the way other services do: by title and year,
+// optionally with an IMDb or TMDB ID.
+type Ref struct {
+	Title  string
+	Year   int
+	ImdbID string
+	TmdbID int
+}
+
+// Client talks to movie-service over HTTP
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(cfg config.MovieServiceConfig) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(cfg.URL, "/"),
+		http:    &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// Get returns a movie by ID, or nil if movie-service doesn't know it
+func (c *Client) Get(ctx context.Context, id int) (*Movie, error) {
+	var movie Movie
+	found, err := c.get(ctx, "/api/movies/"+strconv.Itoa(id), &movie)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &movie, nil
+}
+
+// Search returns movies whose title contains title, optionally limited to
+// one release year
+func (c *Client) Search(ctx context.Context, title string, year int) ([]*Movie, error) {
+	params := url.Values{"q": {title}}
+	if year > 0 {
+		params.Set("year", strconv.Itoa(year))
+	}
+
+	var movies []*Movie
+	if _, err := c.get(ctx, "/api/movies/search?"+params.Encode(), &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// GetMany looks up several movies concurrently. IDs movie-service doesn't
+// know are absent from the result.
+func (c *Client) GetMany(ctx context.Context, ids []int) (map[int]*Movie, error) {
+	found := make([]*Movie, len(ids))
+	err := forEach(len(ids), func(i int) error {
+		movie, err := c.Get(ctx, ids[i])
+		found[i] = movie
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]*Movie, len(ids))
+	for i, movie := range found {
+		if movie != nil {
+			result[ids[i]] = movie
+		}
+	}
+	return result, nil
+}
+
+// Resolve maps a reference from another service to one of our movies.
+// Candidates come from a title search; an IMDb or TMDB ID on the reference
+// must match the candidate's when both are known. Without an ID match the
+// title (or original title) must match exactly, and the year too when
+// given. Ambiguous references resolve to nil rather than to a guess.
+func (c *Client) Resolve(ctx context.Context, ref Ref) (*Movie, error) {
+	title := strings.TrimSpace(ref.Title)
+	if title == "" {
+		return nil, nil
+	}
+
+	candidates, err := c.Search(ctx, title, ref.Year)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*Movie
+	for _, movie := range candidates {
+		if ref.ImdbID != "" && strings.EqualFold(movie.ImdbID, ref.ImdbID) {
+			return movie, nil
+		}
+		if ref.TmdbID != 0 && movie.TmdbID == ref.TmdbID {
+			return movie, nil
+		}
+		if ref.ImdbID != "" && movie.ImdbID != "" {
+			continue
+		}
+		if ref.TmdbID != 0 && movie.TmdbID != 0 {
+			continue
+		}
+		if !strings.EqualFold(movie.Title, title) && !strings.EqualFold(movie.OriginalTitle, title) {
+			continue
+		}
+		if ref.Year > 0 && movie.ReleaseYear != ref.Year {
+			continue
+		}
+		matches = append(matches, movie)
+	}
+
+	if len(matches) != 1 {
+		return nil, nil
+	}
+	return matches[0], nil
+}
+
+// ResolveMany resolves references concurrently; unresolved ones are nil
+func (c *Client) ResolveMany(ctx context.Context, refs []Ref) ([]*Movie, error) {
+	resolved := make([]*Movie, len(refs))
+	err := forEach(len(refs), func(i int) error {
+		movie, err := c.Resolve(ctx, refs[i])
+		resolved[i] = movie
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// get decodes the data of a movie-service response into dest. It reports
+// false without an error on 404.
+func (c *Client) get(ctx context.Context, path string, dest interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	var body envelope
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("movie-service %s: status %d: %w", path, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || !body.Success {
+		return false, fmt.Errorf("movie-service %s: status %d: %s", path, resp.StatusCode, body.Error)
+	}
+
+	if err := json.Unmarshal(body.Data, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// forEach runs fn for 0..n-1 with bounded concurrency and returns the first
+// error
+func forEach(n int, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, lookupConcurrency)
+
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	return firstErr
+}
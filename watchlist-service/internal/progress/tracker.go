@@ -0,0 +1,274 @@
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/config"
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/repository"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// FinishedPercent is the point at which a movie counts as watched; the
+	// rest is usually end credits
+	FinishedPercent = 90.0
+
+	// dirtyKey is the set of users with heartbeats not yet in Postgres
+	dirtyKey = "v1:progress:dirty"
+
+	// pendingTTL bounds how long unflushed heartbeats survive in Redis if
+	// no replica is flushing
+	pendingTTL = 24 * time.Hour
+
+	// flushBatch is the number of users flushed per transaction
+	flushBatch = 200
+
+	// defaultFlushInterval replaces a flush interval that isn't positive
+	defaultFlushInterval = 30 * time.Second
+)
+
+// deleteIfUnchanged removes a hash field only if it still holds the value
+// that was flushed, so a heartbeat that arrives during a flush is kept
+var deleteIfUnchanged = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// Tracker records playback progress. Heartbeats only touch Redis: each
+// user's latest positions live in a hash until Run writes them to Postgres
+// and removes them. Reads merge both, preferring the newer update.
+type Tracker struct {
+	rdb      *redis.Client
+	repo     repository.ProgressRepository
+	interval time.Duration
+}
+
+func NewTracker(rdb *redis.Client, repo repository.ProgressRepository, cfg config.ProgressConfig) *Tracker {
+	interval := cfg.FlushInterval
+	if interval <= 0 {
+		log.Warn().Dur("flushInterval", interval).Dur("default", defaultFlushInterval).Msg("Progress flush interval must be positive, using the default")
+		interval = defaultFlushInterval
+	}
+	return &Tracker{rdb: rdb, repo: repo, interval: interval}
+}
+
+// Heartbeat records the current playback position
+func (t *Tracker) Heartbeat(ctx context.Context, userID, movieID, position, runtime int, device string) (*models.Progress, error) {
+	if position > runtime {
+		position = runtime
+	}
+	percent := float64(position) * 100 / float64(runtime)
+
+	p := &models.Progress{
+		UserID:    userID,
+		MovieID:   movieID,
+		Position:  position,
+		Runtime:   runtime,
+		Percent:   percent,
+		Device:    device,
+		Finished:  percent >= FinishedPercent,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := t.rdb.TxPipeline()
+	pipe.HSet(ctx, userKey(userID), strconv.Itoa(movieID), data)
+	pipe.Expire(ctx, userKey(userID), pendingTTL)
+	pipe.SAdd(ctx, dirtyKey, userID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Get returns the user's progress on a movie, or nil if they haven't
+// started it
+func (t *Tracker) Get(ctx context.Context, userID, movieID int) (*models.Progress, error) {
+	data, err := t.rdb.HGet(ctx, userKey(userID), strconv.Itoa(movieID)).Bytes()
+	if err == nil {
+		var p models.Progress
+		if err := json.Unmarshal(data, &p); err == nil {
+			return &p, nil
+		}
+	} else if err != redis.Nil {
+		log.Warn().Err(err).Msg("Failed to read pending progress")
+	}
+
+	return t.repo.Get(userID, movieID)
+}
+
+// Continue returns up to limit unfinished movies, most recent first
+func (t *Tracker) Continue(ctx context.Context, userID, limit int) ([]*models.Progress, error) {
+	pending, err := t.pending(ctx, userID)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read pending progress")
+	}
+
+	// Fetch extra rows in case pending heartbeats finish some of them
+	stored, err := t.repo.GetInProgress(userID, limit+len(pending))
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[int]*models.Progress, len(stored)+len(pending))
+	for _, p := range append(stored, pending...) {
+		if current, ok := latest[p.MovieID]; !ok || p.UpdatedAt.After(current.UpdatedAt) {
+			latest[p.MovieID] = p
+		}
+	}
+
+	items := make([]*models.Progress, 0, len(latest))
+	for _, p := range latest {
+		if !p.Finished {
+			items = append(items, p)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].UpdatedAt.After(items[j].UpdatedAt)
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, nil
+}
+
+// Run flushes pending heartbeats to Postgres every interval until ctx is
+// cancelled, then flushes once more.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := t.Flush(flushCtx); err != nil {
+				log.Error().Err(err).Msg("Failed to flush playback progress on shutdown")
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := t.Flush(ctx); err != nil {
+				log.Error().Err(err).Msg("Failed to flush playback progress")
+			}
+		}
+	}
+}
+
+// Flush writes every pending heartbeat to Postgres. Users whose flush fails
+// are marked dirty again for the next run.
+func (t *Tracker) Flush(ctx context.Context) error {
+	for {
+		members, err := t.rdb.SPopN(ctx, dirtyKey, flushBatch).Result()
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return nil
+		}
+
+		if err := t.flushUsers(ctx, members); err != nil {
+			if restoreErr := t.rdb.SAdd(ctx, dirtyKey, toInterfaces(members)...).Err(); restoreErr != nil {
+				log.Error().Err(restoreErr).Msg("Failed to requeue playback progress")
+			}
+			return err
+		}
+
+		if len(members) < flushBatch {
+			return nil
+		}
+	}
+}
+
+type pendingField struct {
+	key   string
+	field string
+	raw   string
+}
+
+func (t *Tracker) flushUsers(ctx context.Context, members []string) error {
+	var items []*models.Progress
+	var flushed []pendingField
+
+	for _, member := range members {
+		userID, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		key := userKey(userID)
+		fields, err := t.rdb.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		for field, raw := range fields {
+			var p models.Progress
+			if err := json.Unmarshal([]byte(raw), &p); err != nil {
+				log.Warn().Err(err).Str("key", key).Msg("Dropping malformed playback progress")
+			} else {
+				items = append(items, &p)
+			}
+			flushed = append(flushed, pendingField{key: key, field: field, raw: raw})
+		}
+	}
+
+	if len(items) > 0 {
+		if err := t.repo.Upsert(items); err != nil {
+			return err
+		}
+	}
+
+	pipe := t.rdb.Pipeline()
+	for _, f := range flushed {
+		deleteIfUnchanged.Eval(ctx, pipe, []string{f.key}, f.field, f.raw)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		// The rows are in Postgres; leftovers are flushed again harmlessly
+		log.Warn().Err(err).Msg("Failed to clear flushed playback progress")
+	}
+
+	return nil
+}
+
+// pending returns the user's heartbeats that haven't been flushed yet
+func (t *Tracker) pending(ctx context.Context, userID int) ([]*models.Progress, error) {
+	fields, err := t.rdb.HGetAll(ctx, userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*models.Progress, 0, len(fields))
+	for _, raw := range fields {
+		var p models.Progress
+		if err := json.Unmarshal([]byte(raw), &p); err == nil {
+			items = append(items, &p)
+		}
+	}
+	return items, nil
+}
+
+func userKey(userID int) string {
+	return "v1:progress:user:" + strconv.Itoa(userID)
+}
+
+func toInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "movie-microservices/watchlist-service/internal/models"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// ErrImportRunning is returned when the user already has a pending or
+// running import
+var ErrImportRunning = errors.New("a history import is already running")
+
+type ImportJobRepository interface {
+    Create(userID int, format string, total, skipped int) (*models.ImportJob, error)
+    Get(id int) (*models.ImportJob, error)
+    GetActive(userID int) (*models.ImportJob, error)
+    Update(job *models.ImportJob) error
+    FailStale(reason string, idle time.Duration) (int64, error)
+}
+
+type importJobRepository struct {
+    db *sql.DB
+}
+
+func NewImportJobRepository(db *sql.DB) ImportJobRepository {
+    return &importJobRepository{db: db}
+}
+
+const importJobColumns = `id, user_id, format, status, total, processed, imported, duplicates, skipped,
+    unmapped, unmapped_titles, error, created_at, updated_at, finished_at`
+
+func scanImportJob(row rowScanner) (*models.ImportJob, error) {
+    job := &models.ImportJob{}
+    err := row.Scan(&job.ID, &job.UserID, &job.Format, &job.Status, &job.Total, &job.Processed,
+        &job.Imported, &job.Duplicates, &job.Skipped, &job.Unmapped, pq.Array(&job.UnmappedTitles),
+        &job.Error, &job.CreatedAt, &job.UpdatedAt, &job.FinishedAt)
+    if err != nil {
+        return nil, err
+    }
+    return job, nil
+}
+
+// Create records a pending job. Rows skipped while parsing count as
+// processed straight away. It returns ErrImportRunning when the user already
+// has an active job.
+func (r *importJobRepository) Create(userID int, format string, total, skipped int) (*models.ImportJob, error) {
+    job, err := scanImportJob(r.db.QueryRow(`
+        INSERT INTO history_import_jobs (user_id, format, status, total, processed, skipped)
+        VALUES ($1, $2, $3, $4, $5, $5)
+        RETURNING `+importJobColumns, userID, format, models.JobPending, total, skipped))
+    if err != nil {
+        var pqErr *pq.Error
+        if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+            return nil, ErrImportRunning
+        }
+        return nil, err
+    }
+
+    return job, nil
+}
+
+func (r *importJobRepository) Get(id int) (*models.ImportJob, error) {
+    job, err := scanImportJob(r.db.QueryRow(`
+        SELECT `+importJobColumns+`
+        FROM history_import_jobs
+        WHERE id = $1
+    `, id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return job, nil
+}
+
+// GetActive returns the user's pending or running job, if any
+func (r *importJobRepository) GetActive(userID int) (*models.ImportJob, error) {
+    job, err := scanImportJob(r.db.QueryRow(`
+        SELECT `+importJobColumns+`
+        FROM history_import_jobs
+        WHERE user_id = $1 AND status IN ($2, $3)
+        ORDER BY created_at DESC
+        LIMIT 1
+    `, userID, models.JobPending, models.JobRunning))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return job, nil
+}
+
+// Update saves a job's status, counters and outcome
+func (r *importJobRepository) Update(job *models.ImportJob) error {
+    return r.db.QueryRow(`
+        UPDATE history_import_jobs
+        SET status = $1, processed = $2, imported = $3, duplicates = $4, skipped = $5,
+            unmapped = $6, unmapped_titles = $7, error = $8, finished_at = $9,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $10
+        RETURNING updated_at
+    `, job.Status, job.Processed, job.Imported, job.Duplicates, job.Skipped, job.Unmapped,
+        pq.Array(job.UnmappedTitles), job.Error, job.FinishedAt, job.ID).Scan(&job.UpdatedAt)
+}
+
+// FailStale marks pending or running jobs that haven't reported progress
+// for idle as failed. Jobs run in the memory of the replica that accepted
+// them, so a job whose replica went away would otherwise never finish.
+func (r *importJobRepository) FailStale(reason string, idle time.Duration) (int64, error) {
+    res, err := r.db.Exec(`
+        UPDATE history_import_jobs
+        SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+        WHERE status IN ($3, $4) AND updated_at < $5
+    `, models.JobFailed, reason, models.JobPending, models.JobRunning, time.Now().Add(-idle))
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+    "database/sql"
+    "errors"
+    "movie-microservices/watchlist-service/internal/models"
+
+    "github.com/lib/pq"
+)
+
+// DefaultListName is the name given to the list that backs the legacy
+// /api/watchlist routes.
+const DefaultListName = "Watchlist"
+
+// ErrListNameTaken is returned when a user already owns a list with the
+// requested name.
+var ErrListNameTaken = errors.New("list name already in use")
+
+type ListRepository interface {
+    GetByUserID(userID int) ([]*models.List, error)
+    GetByID(id int) (*models.List, error)
+    GetDefault(userID int) (*models.List, error)
+    Create(userID int, name string) (*models.List, error)
+    Rename(id int, name string) (*models.List, error)
+    Delete(id int) error
+}
+
+type listRepository struct {
+    db *sql.DB
+}
+
+func NewListRepository(db *sql.DB) ListRepository {
+    return &listRepository{db: db}
+}
+
+// GetByUserID returns the lists a user owns followed by the lists shared
+// with them, each tagged with the user's role.
+func (r *listRepository) GetByUserID(userID int) ([]*models.List, error) {
+    rows, err := r.db.Query(`
+        SELECT l.id, l.user_id, l.name, l.is_default,
+               CASE WHEN l.user_id = $1 THEN 'owner' ELSE m.role END,
+               COUNT(w.id), l.created_at, l.updated_at
+        FROM lists l
+        LEFT JOIN list_members m ON m.list_id = l.id AND m.user_id = $1 AND m.status = 'accepted'
+        LEFT JOIN watchlists w ON w.list_id = l.id
+        WHERE l.user_id = $1 OR m.id IS NOT NULL
+        GROUP BY l.id, m.role
+        ORDER BY (l.user_id = $1) DESC, l.is_default DESC, l.created_at ASC
+    `, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var lists []*models.List
+    for rows.Next() {
+        l := &models.List{}
+        if err := rows.Scan(&l.ID, &l.UserID, &l.Name, &l.IsDefault, &l.Role, &l.ItemCount, &l.CreatedAt, &l.UpdatedAt); err != nil {
+            return nil, err
+        }
+        lists = append(lists, l)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return lists, nil
+}
+
+func (r *listRepository) GetByID(id int) (*models.List, error) {
+    l := &models.List{}
+    err := r.db.QueryRow(`
+        SELECT l.id, l.user_id, l.name, l.is_default, COUNT(w.id), l.created_at, l.updated_at
+        FROM lists l
+        LEFT JOIN watchlists w ON w.list_id = l.id
+        WHERE l.id = $1
+        GROUP BY l.id
+    `, id).Scan(&l.ID, &l.UserID, &l.Name, &l.IsDefault, &l.ItemCount, &l.CreatedAt, &l.UpdatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return l, nil
+}
+
+// GetDefault returns the user's default list, creating it on first use.
+func (r *listRepository) GetDefault(userID int) (*models.List, error) {
+    if _, err := r.db.Exec(`
+        INSERT INTO lists (user_id, name, is_default)
+        VALUES ($1, $2, true)
+        ON CONFLICT DO NOTHING
+    `, userID, DefaultListName); err != nil {
+        return nil, err
+    }
+
+    l := &models.List{}
+    err := r.db.QueryRow(`
+        SELECT l.id, l.user_id, l.name, l.is_default, COUNT(w.id), l.created_at, l.updated_at
+        FROM lists l
+        LEFT JOIN watchlists w ON w.list_id = l.id
+        WHERE l.user_id = $1 AND l.is_default
+        GROUP BY l.id
+    `, userID).Scan(&l.ID, &l.UserID, &l.Name, &l.IsDefault, &l.ItemCount, &l.CreatedAt, &l.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    return l, nil
+}
+
+func (r *listRepository) Create(userID int, name string) (*models.List, error) {
+    l := &models.List{UserID: userID, Name: name}
+    err := r.db.QueryRow(`
+        INSERT INTO lists (user_id, name)
+        VALUES ($1, $2)
+        RETURNING id, is_default, created_at, updated_at
+    `, userID, name).Scan(&l.ID, &l.IsDefault, &l.CreatedAt, &l.UpdatedAt)
+    if err != nil {
+        return nil, mapListError(err)
+    }
+
+    return l, nil
+}
+
+func (r *listRepository) Rename(id int, name string) (*models.List, error) {
+    if _, err := r.db.Exec(`
+        UPDATE lists
+        SET name = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, name, id); err != nil {
+        return nil, mapListError(err)
+    }
+
+    return r.GetByID(id)
+}
+
+func (r *listRepository) Delete(id int) error {
+    _, err := r.db.Exec(`
+        DELETE FROM lists
+        WHERE id = $1 AND NOT is_default
+    `, id)
+    return err
+}
+
+func mapListError(err error) error {
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+        return ErrListNameTaken
+    }
+    return err
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+    "database/sql"
+    "movie-microservices/watchlist-service/internal/models"
+)
+
+type MemberRepository interface {
+    GetByListID(listID int) ([]*models.ListMember, error)
+    Get(listID, userID int) (*models.ListMember, error)
+    GetInvitations(userID int) ([]*models.ListMember, error)
+    Invite(listID, userID int, role string, invitedBy int) (*models.ListMember, error)
+    UpdateRole(listID, userID int, role string) error
+    Respond(listID, userID int, status string) error
+    Remove(listID, userID int) error
+}
+
+type memberRepository struct {
+    db *sql.DB
+}
+
+func NewMemberRepository(db *sql.DB) MemberRepository {
+    return &memberRepository{db: db}
+}
+
+func (r *memberRepository) GetByListID(listID int) ([]*models.ListMember, error) {
+    rows, err := r.db.Query(`
+        SELECT id, list_id, user_id, role, status, invited_by, created_at, responded_at
+        FROM list_members
+        WHERE list_id = $1
+        ORDER BY created_at ASC
+    `, listID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var members []*models.ListMember
+    for rows.Next() {
+        m := &models.ListMember{}
+        if err := rows.Scan(&m.ID, &m.ListID, &m.UserID, &m.Role, &m.Status, &m.InvitedBy, &m.CreatedAt, &m.RespondedAt); err != nil {
+            return nil, err
+        }
+        members = append(members, m)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return members, nil
+}
+
+func (r *memberRepository) Get(listID, userID int) (*models.ListMember, error) {
+    m := &models.ListMember{}
+    err := r.db.QueryRow(`
+        SELECT id, list_id, user_id, role, status, invited_by, created_at, responded_at
+        FROM list_members
+        WHERE list_id = $1 AND user_id = $2
+    `, listID, userID).Scan(&m.ID, &m.ListID, &m.UserID, &m.Role, &m.Status, &m.InvitedBy, &m.CreatedAt, &m.RespondedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return m, nil
+}
+
+// GetInvitations returns the pending invitations addressed to a user
+func (r *memberRepository) GetInvitations(userID int) ([]*models.ListMember, error) {
+    rows, err := r.db.Query(`
+        SELECT m.id, m.list_id, l.name, m.user_id, m.role, m.status, m.invited_by, m.created_at, m.responded_at
+        FROM list_members m
+        JOIN lists l ON l.id = m.list_id
+        WHERE m.user_id = $1 AND m.status = 'pending'
+        ORDER BY m.created_at DESC
+    `, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var members []*models.ListMember
+    for rows.Next() {
+        m := &models.ListMember{}
+        if err := rows.Scan(&m.ID, &m.ListID, &m.ListName, &m.UserID, &m.Role, &m.Status, &m.InvitedBy, &m.CreatedAt, &m.RespondedAt); err != nil {
+            return nil, err
+        }
+        members = append(members, m)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return members, nil
+}
+
+// Invite creates a pending invitation. Inviting someone who previously
+// declined or left sends them a fresh invitation with the new role.
+func (r *memberRepository) Invite(listID, userID int, role string, invitedBy int) (*models.ListMember, error) {
+    m := &models.ListMember{}
+    err := r.db.QueryRow(`
+        INSERT INTO list_members (list_id, user_id, role, invited_by)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (list_id, user_id) DO UPDATE
+        SET role = EXCLUDED.role,
+            invited_by = EXCLUDED.invited_by,
+            status = 'pending',
+            created_at = CURRENT_TIMESTAMP,
+            responded_at = NULL
+        RETURNING id, list_id, user_id, role, status, invited_by, created_at, responded_at
+    `, listID, userID, role, invitedBy).Scan(&m.ID, &m.ListID, &m.UserID, &m.Role, &m.Status, &m.InvitedBy, &m.CreatedAt, &m.RespondedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    return m, nil
+}
+
+func (r *memberRepository) UpdateRole(listID, userID int, role string) error {
+    _, err := r.db.Exec(`
+        UPDATE list_members
+        SET role = $1
+        WHERE list_id = $2 AND user_id = $3
+    `, role, listID, userID)
+    return err
+}
+
+func (r *memberRepository) Respond(listID, userID int, status string) error {
+    _, err := r.db.Exec(`
+        UPDATE list_members
+        SET status = $1, responded_at = CURRENT_TIMESTAMP
+        WHERE list_id = $2 AND user_id = $3
+    `, status, listID, userID)
+    return err
+}
+
+func (r *memberRepository) Remove(listID, userID int) error {
+    _, err := r.db.Exec(`
+        DELETE FROM list_members
+        WHERE list_id = $1 AND user_id = $2
+    `, listID, userID)
+    return err
+}
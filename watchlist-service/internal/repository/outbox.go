@@ -0,0 +1,129 @@
+package repository
+
+import (
+    "database/sql"
+    "encoding/json"
+    "movie-microservices/watchlist-service/internal/models"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+type OutboxRepository interface {
+    Publish(limit int, publish func(events []*models.Event) error) (int, error)
+    DeletePublished(before time.Time) (int64, error)
+}
+
+type outboxRepository struct {
+    db *sql.DB
+}
+
+func NewOutboxRepository(db *sql.DB) OutboxRepository {
+    return &outboxRepository{db: db}
+}
+
+// writeEvent adds an event to the outbox as part of tx, so it is published
+// if and only if the change it describes commits
+func writeEvent(tx *sql.Tx, eventType string, userID, movieID int, occurredAt time.Time, data map[string]interface{}) error {
+    payload, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO outbox (event_type, version, user_id, movie_id, data, occurred_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `, eventType, models.EventVersion, userID, movieID, payload, occurredAt)
+    return err
+}
+
+// Publish claims up to limit unpublished events, oldest first, and hands
+// them to publish. They are marked published if it succeeds; otherwise
+// their attempt count and last error are recorded and they stay queued.
+// Rows claimed by another relay are skipped. Returns the number published.
+func (r *outboxRepository) Publish(limit int, publish func(events []*models.Event) error) (int, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    rows, err := tx.Query(`
+        SELECT id, event_id, event_type, version, user_id, movie_id, data, occurred_at
+        FROM outbox
+        WHERE published_at IS NULL
+        ORDER BY id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    `, limit)
+    if err != nil {
+        return 0, err
+    }
+
+    var ids []int64
+    var events []*models.Event
+    for rows.Next() {
+        var id int64
+        var data []byte
+        e := &models.Event{}
+        if err := rows.Scan(&id, &e.ID, &e.Type, &e.Version, &e.UserID, &e.MovieID, &data, &e.OccurredAt); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        if err := json.Unmarshal(data, &e.Data); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        ids = append(ids, id)
+        events = append(events, e)
+    }
+    rows.Close()
+
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    if len(events) == 0 {
+        return 0, nil
+    }
+
+    if publishErr := publish(events); publishErr != nil {
+        if _, err := tx.Exec(`
+            UPDATE outbox
+            SET attempts = attempts + 1, last_error = $2
+            WHERE id = ANY($1)
+        `, pq.Array(ids), publishErr.Error()); err != nil {
+            return 0, err
+        }
+        if err := tx.Commit(); err != nil {
+            return 0, err
+        }
+        return 0, publishErr
+    }
+
+    if _, err := tx.Exec(`
+        UPDATE outbox
+        SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = ''
+        WHERE id = ANY($1)
+    `, pq.Array(ids)); err != nil {
+        return 0, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+
+    return len(events), nil
+}
+
+// DeletePublished removes events published before the given time
+func (r *outboxRepository) DeletePublished(before time.Time) (int64, error) {
+    res, err := r.db.Exec(`
+        DELETE FROM outbox
+        WHERE published_at IS NOT NULL AND published_at < $1
+    `, before)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
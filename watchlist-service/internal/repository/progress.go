@@ -0,0 +1,114 @@
+package repository
+
+import (
+    "database/sql"
+    "movie-microservices/watchlist-service/internal/models"
+)
+
+type ProgressRepository interface {
+    Get(userID, movieID int) (*models.Progress, error)
+    GetInProgress(userID, limit int) ([]*models.Progress, error)
+    Upsert(items []*models.Progress) error
+}
+
+type progressRepository struct {
+    db *sql.DB
+}
+
+func NewProgressRepository(db *sql.DB) ProgressRepository {
+    return &progressRepository{db: db}
+}
+
+const progressColumns = `user_id, movie_id, position_seconds, runtime_seconds, percent, device, finished, updated_at`
+
+func scanProgress(row rowScanner) (*models.Progress, error) {
+    p := &models.Progress{}
+    err := row.Scan(&p.UserID, &p.MovieID, &p.Position, &p.Runtime, &p.Percent, &p.Device, &p.Finished, &p.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+func (r *progressRepository) Get(userID, movieID int) (*models.Progress, error) {
+    p, err := scanProgress(r.db.QueryRow(`
+        SELECT `+progressColumns+`
+        FROM playback_progress
+        WHERE user_id = $1 AND movie_id = $2
+    `, userID, movieID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return p, nil
+}
+
+// GetInProgress returns the user's unfinished movies, most recently watched
+// first
+func (r *progressRepository) GetInProgress(userID, limit int) ([]*models.Progress, error) {
+    rows, err := r.db.Query(`
+        SELECT `+progressColumns+`
+        FROM playback_progress
+        WHERE user_id = $1 AND NOT finished
+        ORDER BY updated_at DESC
+        LIMIT $2
+    `, userID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var items []*models.Progress
+    for rows.Next() {
+        p, err := scanProgress(rows)
+        if err != nil {
+            return nil, err
+        }
+        items = append(items, p)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return items, nil
+}
+
+// Upsert writes progress in one transaction. A row is only overwritten by a
+// newer update, so flushing the same heartbeat twice or out of order is
+// harmless.
+func (r *progressRepository) Upsert(items []*models.Progress) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    stmt, err := tx.Prepare(`
+        INSERT INTO playback_progress (` + progressColumns + `)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (user_id, movie_id) DO UPDATE
+        SET position_seconds = EXCLUDED.position_seconds,
+            runtime_seconds = EXCLUDED.runtime_seconds,
+            percent = EXCLUDED.percent,
+            device = EXCLUDED.device,
+            finished = EXCLUDED.finished,
+            updated_at = EXCLUDED.updated_at
+        WHERE playback_progress.updated_at < EXCLUDED.updated_at
+    `)
+    if err != nil {
+        return err
+    }
+    defer stmt.Close()
+
+    for _, p := range items {
+        if _, err := stmt.Exec(p.UserID, p.MovieID, p.Position, p.Runtime, p.Percent, p.Device, p.Finished, p.UpdatedAt); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Page size limits for paginated queries
+const (
+    DefaultPageLimit = 50
+    MaxPageLimit     = 200
+)
+
+// ErrInvalidCursor is returned when a cursor can't be decoded or was issued
+// for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// WatchlistQuery selects one page of a list's items. Zero values mean "no
+// filter".
+type WatchlistQuery struct {
+    Sort     string
+    Limit    int
+    Cursor   string
+    MovieID  int
+    Tag      string
+    Priority *int
+    From     *time.Time
+    To       *time.Time
+}
+
+// HistoryQuery selects one page of a user's history. Zero values mean "no
+// filter".
+type HistoryQuery struct {
+    Limit   int
+    Cursor  string
+    Action  string
+    MovieID int
+    From    *time.Time
+    To      *time.Time
+}
+
+// SessionQuery selects one page of a user's watch sessions, newest first.
+// Zero values mean "no filter".
+type SessionQuery struct {
+    Limit   int
+    Cursor  string
+    Status  string
+    MovieID int
+    From    *time.Time
+    To      *time.Time
+}
+
+// cursor is the keyset position of the last row on a page. It carries every
+// column the page was ordered by, plus the id as a tie-breaker.
+type cursor struct {
+    Sort      string    `json:"s"`
+    ID        int       `json:"i"`
+    Position  float64   `json:"p"`
+    Priority  int       `json:"r"`
+    CreatedAt time.Time `json:"t"`
+}
+
+func (c cursor) encode() string {
+    data, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s, sort string) (*cursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+    var c cursor
+    if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+        return nil, ErrInvalidCursor
+    }
+    return &c, nil
+}
+
+func pageLimit(limit int) int {
+    if limit <= 0 {
+        return DefaultPageLimit
+    }
+    if limit > MaxPageLimit {
+        return MaxPageLimit
+    }
+    return limit
+}
+
+// whereBuilder collects SQL conditions and their positional arguments
+type whereBuilder struct {
+    conds []string
+    args  []interface{}
+}
+
+// arg registers a query argument and returns its placeholder
+func (w *whereBuilder) arg(v interface{}) string {
+    w.args = append(w.args, v)
+    return "$" + strconv.Itoa(len(w.args))
+}
+
+func (w *whereBuilder) add(cond string) {
+    w.conds = append(w.conds, cond)
+}
+
+func (w *whereBuilder) String() string {
+    return strings.Join(w.conds, " AND ")
+}
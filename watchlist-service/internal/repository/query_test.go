@@ -0,0 +1,61 @@
+package repository
+
+import (
+    "encoding/base64"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+    createdAt := time.Date(2024, 3, 1, 12, 30, 45, 123456000, time.UTC)
+
+    tests := []struct {
+        name   string
+        cursor cursor
+    }{
+        {"date", cursor{Sort: SortDate, ID: 42, CreatedAt: createdAt}},
+        {"position", cursor{Sort: SortPosition, ID: 7, Position: 2.5}},
+        {"priority", cursor{Sort: SortPriority, ID: 9, Priority: 3, CreatedAt: createdAt}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            decoded, err := decodeCursor(tt.cursor.encode(), tt.cursor.Sort)
+            assert.NoError(t, err)
+            if assert.NotNil(t, decoded) {
+                assert.Equal(t, tt.cursor.ID, decoded.ID)
+                assert.Equal(t, tt.cursor.Position, decoded.Position)
+                assert.Equal(t, tt.cursor.Priority, decoded.Priority)
+                assert.True(t, tt.cursor.CreatedAt.Equal(decoded.CreatedAt), "createdAt %v, want %v", decoded.CreatedAt, tt.cursor.CreatedAt)
+            }
+        })
+    }
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+    tests := []struct {
+        name   string
+        cursor string
+        sort   string
+    }{
+        {"other sort order", cursor{Sort: SortDate, ID: 1}.encode(), SortPosition},
+        {"not base64", "not a cursor!", SortDate},
+        {"not JSON", base64.RawURLEncoding.EncodeToString([]byte("{")), SortDate},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := decodeCursor(tt.cursor, tt.sort)
+            assert.Equal(t, ErrInvalidCursor, err)
+        })
+    }
+}
+
+func TestPageLimit(t *testing.T) {
+    assert.Equal(t, DefaultPageLimit, pageLimit(0))
+    assert.Equal(t, DefaultPageLimit, pageLimit(-5))
+    assert.Equal(t, 10, pageLimit(10))
+    assert.Equal(t, MaxPageLimit, pageLimit(MaxPageLimit+1))
+}
@@ -2,20 +2,62 @@ package repository
 
 import (
     "database/sql"
+    "errors"
     "movie-microservices/watchlist-service/internal/models"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// Sort orders accepted by WatchlistRepository.GetByListID
+const (
+    SortPosition = "position"
+    SortPriority = "priority"
+    SortDate     = "date"
+)
+
+var watchlistOrderBy = map[string]string{
+    SortPosition: "position ASC, id DESC",
+    SortPriority: "priority DESC, position ASC, id DESC",
+    SortDate:     "created_at DESC, id DESC",
+}
+
+// minPositionGap is the smallest distance between two neighbouring items
+// before the list's positions are spread out again.
+const minPositionGap = 1e-9
+
+// ErrMoveTargetNotFound is returned by Move when the item to place the moved
+// item after is not in the list.
+var ErrMoveTargetNotFound = errors.New("move target not found in list")
+
+var errPositionGap = errors.New("no room between neighbouring positions")
+
+// Advisory lock classes, paired with a user ID as the second key
+const (
+    lockHistoryImport = 1
+    lockWatchSession  = 2
 )
 
 type WatchlistRepository interface {
-    GetByUserID(userID int) ([]*models.WatchlistItem, error)
-    Add(userID, movieID int) (*models.WatchlistItem, error)
-    Remove(userID, movieID int) error
-    Exists(userID, movieID int) (bool, error)
+    GetByListID(listID int, q WatchlistQuery) ([]*models.WatchlistItem, string, error)
+    Get(listID, movieID int) (*models.WatchlistItem, error)
+    Add(listID, userID, movieID int) (*models.WatchlistItem, error)
+    Update(item *models.WatchlistItem) (*models.WatchlistItem, error)
+    Move(listID, movieID int, afterMovieID *int) (*models.WatchlistItem, error)
+    Remove(listID, userID, movieID int) error
+    Exists(listID, movieID int) (bool, error)
+    ExistsMany(listID int, movieIDs []int) (map[int]bool, error)
+    Batch(listID, userID int, ops []models.BatchOperation) ([]*models.BatchResult, error)
+    Import(listID, userID int, items []*models.WatchlistItem) ([]*models.WatchlistItem, error)
 }
 
 type HistoryRepository interface {
-    GetByUserID(userID int) ([]*models.HistoryItem, error)
+    GetByUserID(userID int, q HistoryQuery) ([]*models.HistoryItem, string, error)
     Add(userID, movieID int, action string) (*models.HistoryItem, error)
+    AddMany(userID int, items []*models.HistoryItem) (int, error)
     Remove(userID, movieID int) error
+    GetSessions(userID int, q SessionQuery) ([]*models.WatchSession, string, error)
+    GetStats(userID int, q StatsQuery) (*models.HistoryStats, error)
 }
 
 type watchlistRepository struct {
@@ -26,67 +68,470 @@ func NewWatchlistRepository(db *sql.DB) WatchlistRepository {
     return &watchlistRepository{db: db}
 }
 
-func (r *watchlistRepository) GetByUserID(userID int) ([]*models.WatchlistItem, error) {
+const watchlistColumns = `id, list_id, user_id, movie_id, note, priority, tags, position, created_at, updated_at`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanWatchlistItem(row rowScanner) (*models.WatchlistItem, error) {
+    item := &models.WatchlistItem{}
+    err := row.Scan(&item.ID, &item.ListID, &item.UserID, &item.MovieID, &item.Note, &item.Priority,
+        pq.Array(&item.Tags), &item.Position, &item.CreatedAt, &item.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    return item, nil
+}
+
+// GetByListID returns one page of a list's items along with the cursor for
+// the next page, which is empty on the last page.
+func (r *watchlistRepository) GetByListID(listID int, q WatchlistQuery) ([]*models.WatchlistItem, string, error) {
+    orderBy, ok := watchlistOrderBy[q.Sort]
+    if !ok {
+        q.Sort = SortPosition
+        orderBy = watchlistOrderBy[SortPosition]
+    }
+    limit := pageLimit(q.Limit)
+
+    w := &whereBuilder{}
+    w.add("list_id = " + w.arg(listID))
+    if q.MovieID != 0 {
+        w.add("movie_id = " + w.arg(q.MovieID))
+    }
+    if q.Tag != "" {
+        w.add(w.arg(q.Tag) + " = ANY(tags)")
+    }
+    if q.Priority != nil {
+        w.add("priority = " + w.arg(*q.Priority))
+    }
+    if q.From != nil {
+        w.add("created_at >= " + w.arg(*q.From))
+    }
+    if q.To != nil {
+        w.add("created_at < " + w.arg(*q.To))
+    }
+    if q.Cursor != "" {
+        c, err := decodeCursor(q.Cursor, q.Sort)
+        if err != nil {
+            return nil, "", err
+        }
+        switch q.Sort {
+        case SortPosition:
+            p, id := w.arg(c.Position), w.arg(c.ID)
+            w.add("(position > " + p + " OR (position = " + p + " AND id < " + id + "))")
+        case SortPriority:
+            pr, p, id := w.arg(c.Priority), w.arg(c.Position), w.arg(c.ID)
+            w.add("(priority < " + pr + " OR (priority = " + pr + " AND (position > " + p +
+                " OR (position = " + p + " AND id < " + id + "))))")
+        case SortDate:
+            w.add("(created_at, id) < (" + w.arg(c.CreatedAt) + ", " + w.arg(c.ID) + ")")
+        }
+    }
+
     rows, err := r.db.Query(`
-        SELECT id, user_id, movie_id, created_at
+        SELECT `+watchlistColumns+`
         FROM watchlists
-        WHERE user_id = $1
-        ORDER BY created_at DESC
-    `, userID)
+        WHERE `+w.String()+`
+        ORDER BY `+orderBy+`
+        LIMIT `+w.arg(limit+1), w.args...)
     if err != nil {
-        return nil, err
+        return nil, "", err
     }
     defer rows.Close()
 
     var items []*models.WatchlistItem
     for rows.Next() {
-        item := &models.WatchlistItem{}
-        if err := rows.Scan(&item.ID, &item.UserID, &item.MovieID, &item.CreatedAt); err != nil {
-            return nil, err
+        item, err := scanWatchlistItem(rows)
+        if err != nil {
+            return nil, "", err
         }
         items = append(items, item)
     }
 
     if err := rows.Err(); err != nil {
+        return nil, "", err
+    }
+
+    var next string
+    if len(items) > limit {
+        items = items[:limit]
+        last := items[limit-1]
+        next = cursor{Sort: q.Sort, ID: last.ID, Position: last.Position, Priority: last.Priority, CreatedAt: last.CreatedAt}.encode()
+    }
+
+    return items, next, nil
+}
+
+func (r *watchlistRepository) Get(listID, movieID int) (*models.WatchlistItem, error) {
+    item, err := scanWatchlistItem(r.db.QueryRow(`
+        SELECT `+watchlistColumns+`
+        FROM watchlists
+        WHERE list_id = $1 AND movie_id = $2
+    `, listID, movieID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
         return nil, err
     }
 
-    return items, nil
+    return item, nil
 }
 
-func (r *watchlistRepository) Add(userID, movieID int) (*models.WatchlistItem, error) {
-    var item models.WatchlistItem
-    err := r.db.QueryRow(`
-        INSERT INTO watchlists (user_id, movie_id)
-        VALUES ($1, $2)
-        RETURNING id, user_id, movie_id, created_at
-    `, userID, movieID).Scan(&item.ID, &item.UserID, &item.MovieID, &item.CreatedAt)
+// recordAddition logs that the user added a movie to a watchlist in tx. The
+// log outlives the item, so stats and reviews can count additions that were
+// removed since.
+func recordAddition(tx *sql.Tx, userID, movieID int, addedAt time.Time) error {
+    _, err := tx.Exec(`
+        INSERT INTO watchlist_additions (user_id, movie_id, added_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT DO NOTHING
+    `, userID, movieID, addedAt)
+    return err
+}
+
+// Add inserts a movie at the top of the list
+func (r *watchlistRepository) Add(listID, userID, movieID int) (*models.WatchlistItem, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    item, err := scanWatchlistItem(tx.QueryRow(`
+        INSERT INTO watchlists (list_id, user_id, movie_id, position)
+        VALUES ($1, $2, $3, (SELECT COALESCE(MIN(position), 1) - 1 FROM watchlists WHERE list_id = $1))
+        RETURNING `+watchlistColumns, listID, userID, movieID))
+    if err != nil {
+        return nil, err
+    }
+
+    if err := recordAddition(tx, userID, movieID, item.CreatedAt); err != nil {
+        return nil, err
+    }
+
+    if err := writeEvent(tx, models.EventWatchlistItemAdded, userID, movieID, item.CreatedAt, map[string]interface{}{"listId": listID}); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return item, nil
+}
+
+func (r *watchlistRepository) Update(item *models.WatchlistItem) (*models.WatchlistItem, error) {
+    return scanWatchlistItem(r.db.QueryRow(`
+        UPDATE watchlists
+        SET note = $1, priority = $2, tags = $3, updated_at = CURRENT_TIMESTAMP
+        WHERE list_id = $4 AND movie_id = $5
+        RETURNING `+watchlistColumns, item.Note, item.Priority, pq.Array(item.Tags), item.ListID, item.MovieID))
+}
+
+// Move places an item directly after afterMovieID, or at the top of the list
+// when afterMovieID is nil. Only the moved row is rewritten unless the gap
+// between its new neighbours has become too small, in which case the whole
+// list is renumbered once.
+func (r *watchlistRepository) Move(listID, movieID int, afterMovieID *int) (*models.WatchlistItem, error) {
+    tx, err := r.db.Begin()
     if err != nil {
         return nil, err
     }
+    defer tx.Rollback()
 
-    return &item, nil
+    // Serialise reorders within a list
+    if _, err := tx.Exec(`SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID); err != nil {
+        return nil, err
+    }
+
+    position, err := movePosition(tx, listID, movieID, afterMovieID)
+    if err == errPositionGap {
+        if _, err := tx.Exec(`
+            UPDATE watchlists w
+            SET position = r.rn
+            FROM (
+                SELECT id, ROW_NUMBER() OVER (ORDER BY position ASC, id DESC) AS rn
+                FROM watchlists
+                WHERE list_id = $1
+            ) r
+            WHERE w.id = r.id
+        `, listID); err != nil {
+            return nil, err
+        }
+        position, err = movePosition(tx, listID, movieID, afterMovieID)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    item, err := scanWatchlistItem(tx.QueryRow(`
+        UPDATE watchlists
+        SET position = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE list_id = $2 AND movie_id = $3
+        RETURNING `+watchlistColumns, position, listID, movieID))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return item, nil
+}
+
+func movePosition(tx *sql.Tx, listID, movieID int, afterMovieID *int) (float64, error) {
+    var next sql.NullFloat64
+    if afterMovieID == nil {
+        if err := tx.QueryRow(`
+            SELECT MIN(position) FROM watchlists
+            WHERE list_id = $1 AND movie_id <> $2
+        `, listID, movieID).Scan(&next); err != nil {
+            return 0, err
+        }
+        if !next.Valid {
+            return 0, nil
+        }
+        return next.Float64 - 1, nil
+    }
+
+    var prev float64
+    err := tx.QueryRow(`
+        SELECT position FROM watchlists
+        WHERE list_id = $1 AND movie_id = $2
+    `, listID, *afterMovieID).Scan(&prev)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return 0, ErrMoveTargetNotFound
+        }
+        return 0, err
+    }
+
+    if err := tx.QueryRow(`
+        SELECT MIN(position) FROM watchlists
+        WHERE list_id = $1 AND movie_id <> $2 AND position > $3
+    `, listID, movieID, prev).Scan(&next); err != nil {
+        return 0, err
+    }
+    if !next.Valid {
+        return prev + 1, nil
+    }
+    if next.Float64-prev < minPositionGap {
+        return 0, errPositionGap
+    }
+    return (prev + next.Float64) / 2, nil
 }
 
-func (r *watchlistRepository) Remove(userID, movieID int) error {
-    _, err := r.db.Exec(`
+// Remove deletes a movie from a list on behalf of userID, who is recorded
+// on the removal event
+func (r *watchlistRepository) Remove(listID, userID, movieID int) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec(`
         DELETE FROM watchlists
-        WHERE user_id = $1 AND movie_id = $2
-    `, userID, movieID)
-    return err
+        WHERE list_id = $1 AND movie_id = $2
+    `, listID, movieID)
+    if err != nil {
+        return err
+    }
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if affected > 0 {
+        if err := writeEvent(tx, models.EventWatchlistItemRemoved, userID, movieID, time.Now().UTC(), map[string]interface{}{"listId": listID}); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
 }
 
-func (r *watchlistRepository) Exists(userID, movieID int) (bool, error) {
+func (r *watchlistRepository) Exists(listID, movieID int) (bool, error) {
     var exists bool
     err := r.db.QueryRow(`
         SELECT EXISTS(
             SELECT 1 FROM watchlists
-            WHERE user_id = $1 AND movie_id = $2
+            WHERE list_id = $1 AND movie_id = $2
         )
-    `, userID, movieID).Scan(&exists)
+    `, listID, movieID).Scan(&exists)
     return exists, err
 }
 
+// ExistsMany reports for each movie whether it is in the list, using a
+// single query.
+func (r *watchlistRepository) ExistsMany(listID int, movieIDs []int) (map[int]bool, error) {
+    found := make(map[int]bool, len(movieIDs))
+    for _, id := range movieIDs {
+        found[id] = false
+    }
+
+    rows, err := r.db.Query(`
+        SELECT movie_id FROM watchlists
+        WHERE list_id = $1 AND movie_id = ANY($2)
+    `, listID, pq.Array(movieIDs))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var movieID int
+        if err := rows.Scan(&movieID); err != nil {
+            return nil, err
+        }
+        found[movieID] = true
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return found, nil
+}
+
+// Batch applies adds and removes in order within one transaction. Adding a
+// movie that is already in the list or removing one that isn't is reported
+// in its result rather than failing the batch; any other error rolls back
+// every operation.
+func (r *watchlistRepository) Batch(listID, userID int, ops []models.BatchOperation) ([]*models.BatchResult, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    // Serialise with reorders so new items get distinct top positions
+    if _, err := tx.Exec(`SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID); err != nil {
+        return nil, err
+    }
+
+    results := make([]*models.BatchResult, 0, len(ops))
+    for _, op := range ops {
+        result := &models.BatchResult{Op: op.Op, MovieID: op.MovieID}
+
+        switch op.Op {
+        case models.BatchOpAdd:
+            item, err := scanWatchlistItem(tx.QueryRow(`
+                INSERT INTO watchlists (list_id, user_id, movie_id, position)
+                VALUES ($1, $2, $3, (SELECT COALESCE(MIN(position), 1) - 1 FROM watchlists WHERE list_id = $1))
+                ON CONFLICT (list_id, movie_id) DO NOTHING
+                RETURNING `+watchlistColumns, listID, userID, op.MovieID))
+            if err == sql.ErrNoRows {
+                result.Status = models.BatchStatusExists
+            } else if err != nil {
+                return nil, err
+            } else {
+                result.Status = models.BatchStatusAdded
+                result.Item = item
+                if err := recordAddition(tx, userID, op.MovieID, item.CreatedAt); err != nil {
+                    return nil, err
+                }
+                if err := writeEvent(tx, models.EventWatchlistItemAdded, userID, op.MovieID, item.CreatedAt, map[string]interface{}{"listId": listID}); err != nil {
+                    return nil, err
+                }
+            }
+
+        case models.BatchOpRemove:
+            res, err := tx.Exec(`
+                DELETE FROM watchlists
+                WHERE list_id = $1 AND movie_id = $2
+            `, listID, op.MovieID)
+            if err != nil {
+                return nil, err
+            }
+            affected, err := res.RowsAffected()
+            if err != nil {
+                return nil, err
+            }
+            if affected == 0 {
+                result.Status = models.BatchStatusNotFound
+            } else {
+                result.Status = models.BatchStatusRemoved
+                if err := writeEvent(tx, models.EventWatchlistItemRemoved, userID, op.MovieID, time.Now().UTC(), map[string]interface{}{"listId": listID}); err != nil {
+                    return nil, err
+                }
+            }
+
+        default:
+            return nil, errors.New("unknown batch operation: " + op.Op)
+        }
+
+        results = append(results, result)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return results, nil
+}
+
+// Import inserts items in one transaction above the current top of the list,
+// keeping their order, and returns the rows inserted. Movies already in the
+// list are left untouched and are missing from the result. An item's
+// CreatedAt is kept when set so imported lists sort by their original dates.
+func (r *watchlistRepository) Import(listID, userID int, items []*models.WatchlistItem) ([]*models.WatchlistItem, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID); err != nil {
+        return nil, err
+    }
+
+    var top float64
+    if err := tx.QueryRow(`
+        SELECT COALESCE(MIN(position), 1) FROM watchlists WHERE list_id = $1
+    `, listID).Scan(&top); err != nil {
+        return nil, err
+    }
+
+    var inserted []*models.WatchlistItem
+    for i, item := range items {
+        var createdAt interface{}
+        if !item.CreatedAt.IsZero() {
+            createdAt = item.CreatedAt
+        }
+
+        row, err := scanWatchlistItem(tx.QueryRow(`
+            INSERT INTO watchlists (list_id, user_id, movie_id, note, priority, tags, position, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8::timestamptz, CURRENT_TIMESTAMP))
+            ON CONFLICT (list_id, movie_id) DO NOTHING
+            RETURNING `+watchlistColumns,
+            listID, userID, item.MovieID, item.Note, item.Priority, pq.Array(item.Tags),
+            top-float64(len(items)-i), createdAt))
+        if err == sql.ErrNoRows {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        if err := recordAddition(tx, userID, row.MovieID, row.CreatedAt); err != nil {
+            return nil, err
+        }
+        inserted = append(inserted, row)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return inserted, nil
+}
+
 type historyRepository struct {
     db *sql.DB
 }
@@ -95,53 +540,143 @@ func NewHistoryRepository(db *sql.DB) HistoryRepository {
     return &historyRepository{db: db}
 }
 
-func (r *historyRepository) GetByUserID(userID int) ([]*models.HistoryItem, error) {
+// GetByUserID returns one page of a user's history, newest first, along with
+// the cursor for the next page, which is empty on the last page.
+func (r *historyRepository) GetByUserID(userID int, q HistoryQuery) ([]*models.HistoryItem, string, error) {
+    limit := pageLimit(q.Limit)
+
+    w := &whereBuilder{}
+    w.add("user_id = " + w.arg(userID))
+    if q.Action != "" {
+        w.add("action = " + w.arg(q.Action))
+    }
+    if q.MovieID != 0 {
+        w.add("movie_id = " + w.arg(q.MovieID))
+    }
+    if q.From != nil {
+        w.add("created_at >= " + w.arg(*q.From))
+    }
+    if q.To != nil {
+        w.add("created_at < " + w.arg(*q.To))
+    }
+    if q.Cursor != "" {
+        c, err := decodeCursor(q.Cursor, SortDate)
+        if err != nil {
+            return nil, "", err
+        }
+        w.add("(created_at, id) < (" + w.arg(c.CreatedAt) + ", " + w.arg(c.ID) + ")")
+    }
+
     rows, err := r.db.Query(`
-        SELECT id, user_id, movie_id, action, created_at
+        SELECT id, user_id, movie_id, action, session_id, created_at
         FROM history
-        WHERE user_id = $1
-        ORDER BY created_at DESC
-        LIMIT 50
-    `, userID)
+        WHERE `+w.String()+`
+        ORDER BY created_at DESC, id DESC
+        LIMIT `+w.arg(limit+1), w.args...)
     if err != nil {
-        return nil, err
+        return nil, "", err
     }
     defer rows.Close()
 
     var items []*models.HistoryItem
     for rows.Next() {
         item := &models.HistoryItem{}
-        if err := rows.Scan(&item.ID, &item.UserID, &item.MovieID, &item.Action, &item.CreatedAt); err != nil {
-            return nil, err
+        if err := rows.Scan(&item.ID, &item.UserID, &item.MovieID, &item.Action, &item.SessionID, &item.CreatedAt); err != nil {
+            return nil, "", err
         }
         items = append(items, item)
     }
 
     if err := rows.Err(); err != nil {
-        return nil, err
+        return nil, "", err
+    }
+
+    var next string
+    if len(items) > limit {
+        items = items[:limit]
+        last := items[limit-1]
+        next = cursor{Sort: SortDate, ID: last.ID, CreatedAt: last.CreatedAt}.encode()
     }
 
-    return items, nil
+    return items, next, nil
 }
 
-func (r *historyRepository) Add(userID, movieID int, action string) (*models.HistoryItem, error) {
-    var item models.HistoryItem
-    err := r.db.QueryRow(`
-        INSERT INTO history (user_id, movie_id, action)
-        VALUES ($1, $2, $3)
-        RETURNING id, user_id, movie_id, action, created_at
-    `, userID, movieID, action).Scan(&item.ID, &item.UserID, &item.MovieID, &item.Action, &item.CreatedAt)
+// AddMany inserts items with their own timestamps in one transaction and
+// returns how many were written. Items matching an existing row on movie,
+// action and time are skipped, so importing the same file twice is
+// harmless.
+func (r *historyRepository) AddMany(userID int, items []*models.HistoryItem) (int, error) {
+    tx, err := r.db.Begin()
     if err != nil {
-        return nil, err
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    // Serialise imports for a user so concurrent batches can't both miss
+    // the same existing row
+    if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockHistoryImport, userID); err != nil {
+        return 0, err
+    }
+
+    stmt, err := tx.Prepare(`
+        INSERT INTO history (user_id, movie_id, action, created_at)
+        SELECT $1, $2, $3, $4
+        WHERE NOT EXISTS (
+            SELECT 1 FROM history
+            WHERE user_id = $1 AND movie_id = $2 AND action = $3 AND created_at = $4
+        )
+    `)
+    if err != nil {
+        return 0, err
+    }
+    defer stmt.Close()
+
+    inserted := 0
+    for _, item := range items {
+        res, err := stmt.Exec(userID, item.MovieID, item.Action, item.CreatedAt)
+        if err != nil {
+            return 0, err
+        }
+        affected, err := res.RowsAffected()
+        if err != nil {
+            return 0, err
+        }
+        inserted += int(affected)
     }
 
-    return &item, nil
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+
+    return inserted, nil
 }
 
+// Remove deletes the user's history for a movie along with its watch
+// sessions
 func (r *historyRepository) Remove(userID, movieID int) error {
-    _, err := r.db.Exec(`
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockWatchSession, userID); err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`
         DELETE FROM history
         WHERE user_id = $1 AND movie_id = $2
-    `, userID, movieID)
-    return err
+    `, userID, movieID); err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`
+        DELETE FROM watch_sessions
+        WHERE user_id = $1 AND movie_id = $2
+    `, userID, movieID); err != nil {
+        return err
+    }
+
+    return tx.Commit()
 }
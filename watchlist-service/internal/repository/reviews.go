@@ -0,0 +1,166 @@
+package repository
+
+import (
+    "database/sql"
+    "encoding/json"
+    "movie-microservices/watchlist-service/internal/models"
+    "time"
+)
+
+type YearReviewRepository interface {
+    Get(userID, year int) (*models.YearReview, error)
+    Save(review *models.YearReview) error
+    Summarize(userID, year int) (*models.YearReview, error)
+    UserIDs(year int) ([]int, error)
+}
+
+type yearReviewRepository struct {
+    db *sql.DB
+}
+
+func NewYearReviewRepository(db *sql.DB) YearReviewRepository {
+    return &yearReviewRepository{db: db}
+}
+
+// YearBounds returns the start of year and of the next year in UTC
+func YearBounds(year int) (time.Time, time.Time) {
+    from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+    return from, from.AddDate(1, 0, 0)
+}
+
+func (r *yearReviewRepository) Get(userID, year int) (*models.YearReview, error) {
+    var data []byte
+    err := r.db.QueryRow(`
+        SELECT report
+        FROM year_reviews
+        WHERE user_id = $1 AND year = $2
+    `, userID, year).Scan(&data)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    var review models.YearReview
+    if err := json.Unmarshal(data, &review); err != nil {
+        return nil, err
+    }
+
+    return &review, nil
+}
+
+// Save stores a report, replacing any earlier one for the same year
+func (r *yearReviewRepository) Save(review *models.YearReview) error {
+    data, err := json.Marshal(review)
+    if err != nil {
+        return err
+    }
+
+    _, err = r.db.Exec(`
+        INSERT INTO year_reviews (user_id, year, report, generated_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, year) DO UPDATE
+        SET report = EXCLUDED.report, generated_at = EXCLUDED.generated_at
+    `, review.UserID, review.Year, data, review.GeneratedAt)
+    return err
+}
+
+// Summarize fills in the parts of a report that the history stats don't
+// cover: distinct movies, the first and last movie finished and how much of
+// the year's watchlist additions were finished.
+func (r *yearReviewRepository) Summarize(userID, year int) (*models.YearReview, error) {
+    from, to := YearBounds(year)
+    review := &models.YearReview{UserID: userID, Year: year}
+
+    err := r.db.QueryRow(`
+        SELECT COUNT(DISTINCT movie_id)
+        FROM history
+        WHERE user_id = $1 AND action = $2 AND created_at >= $3 AND created_at < $4
+    `, userID, models.ActionFinished, from, to).Scan(&review.DistinctMovies)
+    if err != nil {
+        return nil, err
+    }
+
+    if review.FirstMovie, err = r.finishedMovie(userID, from, to, "ASC"); err != nil {
+        return nil, err
+    }
+    if review.LastMovie, err = r.finishedMovie(userID, from, to, "DESC"); err != nil {
+        return nil, err
+    }
+
+    err = r.db.QueryRow(`
+        SELECT COUNT(*), COUNT(*) FILTER (WHERE EXISTS (
+            SELECT 1 FROM history h
+            WHERE h.user_id = $1 AND h.movie_id = a.movie_id AND h.action = $2 AND h.created_at < $4
+        ))
+        FROM (
+            SELECT DISTINCT movie_id
+            FROM watchlist_additions
+            WHERE user_id = $1 AND added_at >= $3 AND added_at < $4
+        ) a
+    `, userID, models.ActionFinished, from, to).Scan(&review.Watchlisted, &review.WatchlistedFinished)
+    if err != nil {
+        return nil, err
+    }
+    if review.Watchlisted > 0 {
+        review.CompletionRatio = float64(review.WatchlistedFinished) / float64(review.Watchlisted)
+    }
+
+    return review, nil
+}
+
+// finishedMovie returns the first or last movie finished in the range,
+// depending on order, or nil if there is none
+func (r *yearReviewRepository) finishedMovie(userID int, from, to time.Time, order string) (*models.ReviewMovie, error) {
+    var movie models.ReviewMovie
+    err := r.db.QueryRow(`
+        SELECT movie_id, created_at
+        FROM history
+        WHERE user_id = $1 AND action = $2 AND created_at >= $3 AND created_at < $4
+        ORDER BY created_at `+order+`, id `+order+`
+        LIMIT 1
+    `, userID, models.ActionFinished, from, to).Scan(&movie.MovieID, &movie.WatchedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    return &movie, nil
+}
+
+// UserIDs returns every user who finished a movie or added one to a
+// watchlist during the year
+func (r *yearReviewRepository) UserIDs(year int) ([]int, error) {
+    from, to := YearBounds(year)
+
+    rows, err := r.db.Query(`
+        SELECT user_id FROM history
+        WHERE action = $1 AND created_at >= $2 AND created_at < $3
+        UNION
+        SELECT user_id FROM watchlist_additions
+        WHERE added_at >= $2 AND added_at < $3
+        ORDER BY user_id
+    `, models.ActionFinished, from, to)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return ids, nil
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+    "database/sql"
+    "fmt"
+    "movie-microservices/watchlist-service/internal/models"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// sessionTransitions maps the state of a user's open session for a movie and
+// an action to the state the session moves to. The empty state means there
+// is no open session.
+var sessionTransitions = map[string]map[string]string{
+    "": {
+        models.ActionStarted:   models.SessionPlaying,
+        models.ActionRewatched: models.SessionPlaying,
+    },
+    models.SessionPlaying: {
+        models.ActionPaused:    models.SessionPaused,
+        models.ActionFinished:  models.SessionFinished,
+        models.ActionAbandoned: models.SessionAbandoned,
+    },
+    models.SessionPaused: {
+        models.ActionResumed:   models.SessionPlaying,
+        models.ActionFinished:  models.SessionFinished,
+        models.ActionAbandoned: models.SessionAbandoned,
+    },
+}
+
+// TransitionError is returned by Add when an action isn't valid for the
+// state of the user's watch session for the movie.
+type TransitionError struct {
+    State  string
+    Action string
+}
+
+func (e *TransitionError) Error() string {
+    switch {
+    case e.State == "" && e.Action == models.ActionRewatched:
+        return "cannot rewatch a movie that was never finished"
+    case e.State == "":
+        return fmt.Sprintf("cannot record %s without a watch session in progress", e.Action)
+    default:
+        return fmt.Sprintf("cannot record %s while the watch session is %s", e.Action, e.State)
+    }
+}
+
+// Add records an action and applies it to the user's watch session for the
+// movie: started and rewatched open a session, the other actions move the
+// open one along. Events are timestamped after the user's lock is taken so
+// they are ordered the way they were applied. Finishing a movie also applies
+// the user's OnFinish setting to their default list in the same
+// transaction.
+func (r *historyRepository) Add(userID, movieID int, action string) (*models.HistoryItem, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockWatchSession, userID); err != nil {
+        return nil, err
+    }
+
+    var sessionID int
+    var state string
+    err = tx.QueryRow(`
+        SELECT id, status
+        FROM watch_sessions
+        WHERE user_id = $1 AND movie_id = $2 AND status IN ($3, $4)
+    `, userID, movieID, models.SessionPlaying, models.SessionPaused).Scan(&sessionID, &state)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, err
+    }
+
+    next, ok := sessionTransitions[state][action]
+    if !ok {
+        return nil, &TransitionError{State: state, Action: action}
+    }
+
+    if action == models.ActionRewatched {
+        var finished bool
+        if err := tx.QueryRow(`
+            SELECT EXISTS (
+                SELECT 1 FROM history
+                WHERE user_id = $1 AND movie_id = $2 AND action = $3
+            )
+        `, userID, movieID, models.ActionFinished).Scan(&finished); err != nil {
+            return nil, err
+        }
+        if !finished {
+            return nil, &TransitionError{State: state, Action: action}
+        }
+    }
+
+    var now time.Time
+    if err := tx.QueryRow(`SELECT clock_timestamp()`).Scan(&now); err != nil {
+        return nil, err
+    }
+
+    if state == "" {
+        err = tx.QueryRow(`
+            INSERT INTO watch_sessions (user_id, movie_id, status, is_rewatch, started_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, $5)
+            RETURNING id
+        `, userID, movieID, next, action == models.ActionRewatched, now).Scan(&sessionID)
+    } else {
+        var endedAt *time.Time
+        if next == models.SessionFinished || next == models.SessionAbandoned {
+            endedAt = &now
+        }
+        _, err = tx.Exec(`
+            UPDATE watch_sessions
+            SET status = $2, ended_at = $3, updated_at = $4
+            WHERE id = $1
+        `, sessionID, next, endedAt, now)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var item models.HistoryItem
+    err = tx.QueryRow(`
+        INSERT INTO history (user_id, movie_id, action, session_id, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, user_id, movie_id, action, session_id, created_at
+    `, userID, movieID, action, sessionID, now).Scan(&item.ID, &item.UserID, &item.MovieID, &item.Action, &item.SessionID, &item.CreatedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := writeEvent(tx, models.EventHistoryPrefix+action, userID, movieID, now, map[string]interface{}{
+        "action":    action,
+        "sessionId": sessionID,
+    }); err != nil {
+        return nil, err
+    }
+
+    if next == models.SessionFinished {
+        if err := applyOnFinish(tx, &item); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    return &item, nil
+}
+
+// GetSessions returns one page of the user's watch sessions, newest first,
+// each with its events and durations.
+func (r *historyRepository) GetSessions(userID int, q SessionQuery) ([]*models.WatchSession, string, error) {
+    limit := pageLimit(q.Limit)
+
+    w := &whereBuilder{}
+    w.add("user_id = " + w.arg(userID))
+    if q.Status != "" {
+        w.add("status = " + w.arg(q.Status))
+    }
+    if q.MovieID != 0 {
+        w.add("movie_id = " + w.arg(q.MovieID))
+    }
+    if q.From != nil {
+        w.add("started_at >= " + w.arg(*q.From))
+    }
+    if q.To != nil {
+        w.add("started_at < " + w.arg(*q.To))
+    }
+    if q.Cursor != "" {
+        c, err := decodeCursor(q.Cursor, SortDate)
+        if err != nil {
+            return nil, "", err
+        }
+        w.add("(started_at, id) < (" + w.arg(c.CreatedAt) + ", " + w.arg(c.ID) + ")")
+    }
+
+    rows, err := r.db.Query(`
+        SELECT id, user_id, movie_id, status, is_rewatch, started_at, ended_at
+        FROM watch_sessions
+        WHERE `+w.String()+`
+        ORDER BY started_at DESC, id DESC
+        LIMIT `+w.arg(limit+1), w.args...)
+    if err != nil {
+        return nil, "", err
+    }
+    defer rows.Close()
+
+    var sessions []*models.WatchSession
+    for rows.Next() {
+        s := &models.WatchSession{Events: []*models.HistoryItem{}}
+        if err := rows.Scan(&s.ID, &s.UserID, &s.MovieID, &s.Status, &s.IsRewatch, &s.StartedAt, &s.EndedAt); err != nil {
+            return nil, "", err
+        }
+        sessions = append(sessions, s)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, "", err
+    }
+
+    var next string
+    if len(sessions) > limit {
+        sessions = sessions[:limit]
+        last := sessions[limit-1]
+        next = cursor{Sort: SortDate, ID: last.ID, CreatedAt: last.StartedAt}.encode()
+    }
+
+    if err := r.loadSessionEvents(sessions); err != nil {
+        return nil, "", err
+    }
+
+    return sessions, next, nil
+}
+
+func (r *historyRepository) loadSessionEvents(sessions []*models.WatchSession) error {
+    if len(sessions) == 0 {
+        return nil
+    }
+
+    byID := make(map[int]*models.WatchSession, len(sessions))
+    ids := make([]int64, len(sessions))
+    for i, s := range sessions {
+        byID[s.ID] = s
+        ids[i] = int64(s.ID)
+    }
+
+    rows, err := r.db.Query(`
+        SELECT id, user_id, movie_id, action, session_id, created_at
+        FROM history
+        WHERE session_id = ANY($1)
+        ORDER BY created_at, id
+    `, pq.Array(ids))
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        item := &models.HistoryItem{}
+        if err := rows.Scan(&item.ID, &item.UserID, &item.MovieID, &item.Action, &item.SessionID, &item.CreatedAt); err != nil {
+            return err
+        }
+        if s := byID[*item.SessionID]; s != nil {
+            s.Events = append(s.Events, item)
+        }
+    }
+
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, s := range sessions {
+        measureSession(s)
+    }
+    return nil
+}
+
+// measureSession reconstructs a session's durations from its events. Time
+// between started, resumed or rewatched and the next paused, finished or
+// abandoned counts as watched. A session still in progress is measured up
+// to its latest event.
+func measureSession(s *models.WatchSession) {
+    var watched time.Duration
+    var playingSince *time.Time
+    end := s.StartedAt
+
+    for _, e := range s.Events {
+        switch e.Action {
+        case models.ActionStarted, models.ActionResumed, models.ActionRewatched:
+            if playingSince == nil {
+                at := e.CreatedAt
+                playingSince = &at
+            }
+        case models.ActionPaused, models.ActionFinished, models.ActionAbandoned:
+            if playingSince != nil {
+                watched += e.CreatedAt.Sub(*playingSince)
+                playingSince = nil
+            }
+        }
+        if e.CreatedAt.After(end) {
+            end = e.CreatedAt
+        }
+    }
+    if s.EndedAt != nil {
+        end = *s.EndedAt
+    }
+
+    s.WatchedSeconds = int64(watched / time.Second)
+    s.ElapsedSeconds = int64(end.Sub(s.StartedAt) / time.Second)
+}
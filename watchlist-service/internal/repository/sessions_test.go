@@ -0,0 +1,26 @@
+package repository
+
+import (
+    "movie-microservices/watchlist-service/internal/models"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestTransitionErrorMessage(t *testing.T) {
+    tests := []struct {
+        state  string
+        action string
+        want   string
+    }{
+        {"", models.ActionRewatched, "cannot rewatch a movie that was never finished"},
+        {"", models.ActionPaused, "cannot record paused without a watch session in progress"},
+        {models.SessionPlaying, models.ActionStarted, "cannot record started while the watch session is playing"},
+        {models.SessionPaused, models.ActionPaused, "cannot record paused while the watch session is paused"},
+    }
+
+    for _, tt := range tests {
+        err := &TransitionError{State: tt.state, Action: tt.action}
+        assert.Equal(t, tt.want, err.Error())
+    }
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+    "database/sql"
+    "movie-microservices/watchlist-service/internal/models"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+type SettingsRepository interface {
+    Get(userID int) (*models.UserSettings, error)
+    Update(userID int, onFinish string) (*models.UserSettings, error)
+}
+
+type settingsRepository struct {
+    db *sql.DB
+}
+
+func NewSettingsRepository(db *sql.DB) SettingsRepository {
+    return &settingsRepository{db: db}
+}
+
+// Get returns the user's settings, or the defaults if they never changed
+// them
+func (r *settingsRepository) Get(userID int) (*models.UserSettings, error) {
+    s := &models.UserSettings{UserID: userID}
+    err := r.db.QueryRow(`
+        SELECT on_finish, updated_at
+        FROM user_settings
+        WHERE user_id = $1
+    `, userID).Scan(&s.OnFinish, &s.UpdatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            s.OnFinish = models.OnFinishKeep
+            return s, nil
+        }
+        return nil, err
+    }
+
+    return s, nil
+}
+
+func (r *settingsRepository) Update(userID int, onFinish string) (*models.UserSettings, error) {
+    s := &models.UserSettings{UserID: userID}
+    err := r.db.QueryRow(`
+        INSERT INTO user_settings (user_id, on_finish)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET on_finish = EXCLUDED.on_finish, updated_at = CURRENT_TIMESTAMP
+        RETURNING on_finish, updated_at
+    `, userID, onFinish).Scan(&s.OnFinish, &s.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    return s, nil
+}
+
+// applyOnFinish takes a finished movie off the user's default list in tx
+// when their settings ask for it, moving it to the Watched list with its
+// note, priority and tags for OnFinishMove. It records what changed on item
+// and as a removal event; the move into the Watched list isn't published as
+// an addition.
+func applyOnFinish(tx *sql.Tx, item *models.HistoryItem) error {
+    var onFinish string
+    err := tx.QueryRow(`
+        SELECT on_finish
+        FROM user_settings
+        WHERE user_id = $1
+    `, item.UserID).Scan(&onFinish)
+    if err == sql.ErrNoRows || onFinish == models.OnFinishKeep {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    var listID, priority int
+    var note string
+    var tags []string
+    var addedAt time.Time
+    err = tx.QueryRow(`
+        DELETE FROM watchlists w
+        USING lists l
+        WHERE l.id = w.list_id AND l.user_id = $1 AND l.is_default AND w.movie_id = $2
+        RETURNING w.list_id, w.note, w.priority, w.tags, w.created_at
+    `, item.UserID, item.MovieID).Scan(&listID, &note, &priority, pq.Array(&tags), &addedAt)
+    if err == sql.ErrNoRows {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    item.RemovedFromList = &listID
+
+    removed := map[string]interface{}{"listId": listID, "reason": "finished"}
+    if onFinish != models.OnFinishMove {
+        return writeEvent(tx, models.EventWatchlistItemRemoved, item.UserID, item.MovieID, item.CreatedAt, removed)
+    }
+
+    var watchedID int
+    err = tx.QueryRow(`
+        INSERT INTO lists (user_id, name)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id, name) DO UPDATE SET updated_at = lists.updated_at
+        RETURNING id
+    `, item.UserID, models.WatchedListName).Scan(&watchedID)
+    if err != nil {
+        return err
+    }
+
+    // The moved item keeps its add date, so it still says when the movie was
+    // first put on a list
+    if _, err := tx.Exec(`
+        INSERT INTO watchlists (list_id, user_id, movie_id, note, priority, tags, position, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, (SELECT COALESCE(MIN(position), 1) - 1 FROM watchlists WHERE list_id = $1), $7)
+        ON CONFLICT (list_id, movie_id) DO NOTHING
+    `, watchedID, item.UserID, item.MovieID, note, priority, pq.Array(tags), addedAt); err != nil {
+        return err
+    }
+    item.MovedToList = &watchedID
+
+    removed["movedToListId"] = watchedID
+    return writeEvent(tx, models.EventWatchlistItemRemoved, item.UserID, item.MovieID, item.CreatedAt, removed)
+}
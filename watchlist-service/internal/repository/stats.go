@@ -0,0 +1,219 @@
+package repository
+
+import (
+    "database/sql"
+    "movie-microservices/watchlist-service/internal/models"
+    "time"
+)
+
+const (
+    dayLayout   = "2006-01-02"
+    monthLayout = "2006-01"
+)
+
+// StatsQuery selects the range of history to summarise. Today anchors the
+// current streak, so callers caching the result should key it by day.
+type StatsQuery struct {
+    From  *time.Time
+    To    *time.Time
+    Today time.Time
+}
+
+// rangeFilter appends the range conditions on column to w
+func (q StatsQuery) rangeFilter(w *whereBuilder, column string) {
+    if q.From != nil {
+        w.add(column + " >= " + w.arg(*q.From))
+    }
+    if q.To != nil {
+        w.add(column + " < " + w.arg(*q.To))
+    }
+}
+
+// GetStats summarises the movies the user finished in the query's range
+func (r *historyRepository) GetStats(userID int, q StatsQuery) (*models.HistoryStats, error) {
+    stats := &models.HistoryStats{
+        From:             q.From,
+        To:               q.To,
+        FinishedPerDay:   []*models.PeriodCount{},
+        FinishedPerWeek:  []*models.PeriodCount{},
+        FinishedPerMonth: []*models.PeriodCount{},
+    }
+
+    days, err := r.finishedDays(userID, q)
+    if err != nil {
+        return nil, err
+    }
+    summarizeDays(stats, days)
+
+    if stats.CurrentStreak, err = r.currentStreak(userID, q.Today); err != nil {
+        return nil, err
+    }
+
+    w := &whereBuilder{}
+    w.add("n > 1")
+    q.rangeFilter(w, "created_at")
+    err = r.db.QueryRow(`
+        SELECT COUNT(*), COUNT(DISTINCT movie_id)
+        FROM (
+            SELECT movie_id, created_at,
+                ROW_NUMBER() OVER (PARTITION BY movie_id ORDER BY created_at, id) AS n
+            FROM history
+            WHERE user_id = `+w.arg(userID)+` AND action = `+w.arg(models.ActionFinished)+`
+        ) f
+        WHERE `+w.String(), w.args...).Scan(&stats.Rewatches, &stats.RewatchedMovies)
+    if err != nil {
+        return nil, err
+    }
+
+    // Time from the first add to any watchlist to the first finish after it
+    w = &whereBuilder{}
+    w.add("user_id = " + w.arg(userID))
+    w.add("action = " + w.arg(models.ActionFinished))
+    q.rangeFilter(w, "created_at")
+    var avg sql.NullFloat64
+    err = r.db.QueryRow(`
+        SELECT AVG(EXTRACT(EPOCH FROM f.finished_at - a.added_at) / 86400), COUNT(*)
+        FROM (
+            SELECT movie_id, MIN(added_at) AS added_at
+            FROM watchlist_additions
+            WHERE user_id = $1
+            GROUP BY movie_id
+        ) a
+        JOIN LATERAL (
+            SELECT MIN(created_at) AS finished_at
+            FROM history
+            WHERE `+w.String()+` AND movie_id = a.movie_id AND created_at >= a.added_at
+        ) f ON f.finished_at IS NOT NULL
+    `, w.args...).Scan(&avg, &stats.WatchlistToFinishSamples)
+    if err != nil {
+        return nil, err
+    }
+    if avg.Valid {
+        stats.AvgDaysWatchlistToFinish = &avg.Float64
+    }
+
+    return stats, nil
+}
+
+type dayCount struct {
+    day   time.Time
+    count int
+}
+
+// finishedDays returns the number of movies finished on each UTC day in
+// range, oldest first
+func (r *historyRepository) finishedDays(userID int, q StatsQuery) ([]dayCount, error) {
+    w := &whereBuilder{}
+    w.add("user_id = " + w.arg(userID))
+    w.add("action = " + w.arg(models.ActionFinished))
+    q.rangeFilter(w, "created_at")
+
+    rows, err := r.db.Query(`
+        SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*)
+        FROM history
+        WHERE `+w.String()+`
+        GROUP BY day
+        ORDER BY day
+    `, w.args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var days []dayCount
+    for rows.Next() {
+        var d dayCount
+        if err := rows.Scan(&d.day, &d.count); err != nil {
+            return nil, err
+        }
+        days = append(days, d)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return days, nil
+}
+
+// currentStreak counts back from today, or from yesterday if nothing was
+// finished today yet
+func (r *historyRepository) currentStreak(userID int, today time.Time) (models.Streak, error) {
+    today = today.UTC().Truncate(24 * time.Hour)
+
+    rows, err := r.db.Query(`
+        SELECT DISTINCT (created_at AT TIME ZONE 'UTC')::date AS day
+        FROM history
+        WHERE user_id = $1 AND action = $2 AND created_at < $3
+        ORDER BY day DESC
+    `, userID, models.ActionFinished, today.AddDate(0, 0, 1))
+    if err != nil {
+        return models.Streak{}, err
+    }
+    defer rows.Close()
+
+    var streak models.Streak
+    expected := today
+    for rows.Next() {
+        var day time.Time
+        if err := rows.Scan(&day); err != nil {
+            return models.Streak{}, err
+        }
+        day = day.UTC()
+        if streak.Days == 0 && day.Equal(today.AddDate(0, 0, -1)) {
+            expected = day
+        }
+        if !day.Equal(expected) {
+            break
+        }
+        if streak.Days == 0 {
+            streak.End = day.Format(dayLayout)
+        }
+        streak.Days++
+        streak.Start = day.Format(dayLayout)
+        expected = day.AddDate(0, 0, -1)
+    }
+
+    if err := rows.Err(); err != nil {
+        return models.Streak{}, err
+    }
+
+    return streak, nil
+}
+
+// summarizeDays fills in the totals, per-period counts and longest streak
+// from per-day counts sorted by day
+func summarizeDays(stats *models.HistoryStats, days []dayCount) {
+    var week, month *models.PeriodCount
+    var run models.Streak
+    var prev time.Time
+
+    for _, d := range days {
+        stats.Finished += d.count
+        stats.FinishedPerDay = append(stats.FinishedPerDay, &models.PeriodCount{Period: d.day.Format(dayLayout), Count: d.count})
+
+        monday := d.day.AddDate(0, 0, -((int(d.day.Weekday()) + 6) % 7)).Format(dayLayout)
+        if week == nil || week.Period != monday {
+            week = &models.PeriodCount{Period: monday}
+            stats.FinishedPerWeek = append(stats.FinishedPerWeek, week)
+        }
+        week.Count += d.count
+
+        if m := d.day.Format(monthLayout); month == nil || month.Period != m {
+            month = &models.PeriodCount{Period: m}
+            stats.FinishedPerMonth = append(stats.FinishedPerMonth, month)
+        }
+        month.Count += d.count
+
+        if run.Days > 0 && d.day.Equal(prev.AddDate(0, 0, 1)) {
+            run.Days++
+        } else {
+            run = models.Streak{Days: 1, Start: d.day.Format(dayLayout)}
+        }
+        run.End = d.day.Format(dayLayout)
+        if run.Days > stats.LongestStreak.Days {
+            stats.LongestStreak = run
+        }
+        prev = d.day
+    }
+}
@@ -0,0 +1,215 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/movies"
+	"movie-microservices/watchlist-service/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// topMonthCount is the number of busiest months highlighted in a report
+	topMonthCount = 3
+
+	// currentYearMaxAge is how long a report for a year still in progress
+	// is served before it is built again
+	currentYearMaxAge = 24 * time.Hour
+
+	// precomputeWorkers bounds how many reports a precompute run builds at
+	// once
+	precomputeWorkers = 4
+)
+
+var (
+	ErrFutureYear        = errors.New("year has not started yet")
+	ErrPrecomputeRunning = errors.New("a precompute run is already in progress")
+)
+
+// Generator builds year in review reports and stores them, so each is
+// computed once. A report built before its year ended is rebuilt once it is
+// a day old.
+type Generator struct {
+	reviews repository.YearReviewRepository
+	history repository.HistoryRepository
+	movies  *movies.Client
+	running int32
+}
+
+func NewGenerator(reviews repository.YearReviewRepository, history repository.HistoryRepository, movies *movies.Client) *Generator {
+	return &Generator{reviews: reviews, history: history, movies: movies}
+}
+
+// Get returns the user's stored report for year, building it if there is
+// none yet or the stored one is out of date
+func (g *Generator) Get(ctx context.Context, userID, year int) (*models.YearReview, error) {
+	now := time.Now().UTC()
+	if year > now.Year() {
+		return nil, ErrFutureYear
+	}
+
+	review, err := g.reviews.Get(userID, year)
+	if err != nil {
+		return nil, err
+	}
+	if review != nil {
+		_, end := repository.YearBounds(year)
+		if !review.GeneratedAt.Before(end) || now.Sub(review.GeneratedAt) < currentYearMaxAge {
+			return review, nil
+		}
+	}
+
+	return g.Generate(ctx, userID, year)
+}
+
+// Generate builds the user's report for year and stores it
+func (g *Generator) Generate(ctx context.Context, userID, year int) (*models.YearReview, error) {
+	now := time.Now().UTC()
+	from, to := repository.YearBounds(year)
+
+	stats, err := g.history.GetStats(userID, repository.StatsQuery{From: &from, To: &to, Today: now})
+	if err != nil {
+		return nil, err
+	}
+
+	review, err := g.reviews.Summarize(userID, year)
+	if err != nil {
+		return nil, err
+	}
+
+	review.Finished = stats.Finished
+	review.Rewatches = stats.Rewatches
+	review.LongestStreak = stats.LongestStreak
+	review.Months = fillMonths(year, stats.FinishedPerMonth)
+	review.TopMonths = topMonths(review.Months)
+	review.GeneratedAt = now
+	g.addTitles(ctx, review)
+
+	if err := g.reviews.Save(review); err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
+
+// Precompute builds and stores the report for year for every user with
+// activity in it, in the background. It returns the number of users, or
+// ErrPrecomputeRunning if a run started earlier hasn't finished.
+func (g *Generator) Precompute(year int) (int, error) {
+	if year > time.Now().UTC().Year() {
+		return 0, ErrFutureYear
+	}
+	if !atomic.CompareAndSwapInt32(&g.running, 0, 1) {
+		return 0, ErrPrecomputeRunning
+	}
+
+	userIDs, err := g.reviews.UserIDs(year)
+	if err != nil {
+		atomic.StoreInt32(&g.running, 0)
+		return 0, err
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&g.running, 0)
+		g.precompute(year, userIDs)
+	}()
+
+	return len(userIDs), nil
+}
+
+func (g *Generator) precompute(year int, userIDs []int) {
+	ctx := context.Background()
+	started := time.Now()
+
+	ids := make(chan int)
+	var failed int32
+	var wg sync.WaitGroup
+	for i := 0; i < precomputeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range ids {
+				if _, err := g.Generate(ctx, userID, year); err != nil {
+					atomic.AddInt32(&failed, 1)
+					log.Error().Err(err).Int("userId", userID).Int("year", year).Msg("Failed to generate year in review")
+				}
+			}
+		}()
+	}
+	for _, userID := range userIDs {
+		ids <- userID
+	}
+	close(ids)
+	wg.Wait()
+
+	log.Info().
+		Int("year", year).
+		Int("users", len(userIDs)).
+		Int32("failed", failed).
+		Dur("duration", time.Since(started)).
+		Msg("Year in review precompute finished")
+}
+
+// addTitles looks up the titles of the first and last movie. Reports are
+// still stored without them if movie-service is unavailable.
+func (g *Generator) addTitles(ctx context.Context, review *models.YearReview) {
+	var ids []int
+	for _, m := range []*models.ReviewMovie{review.FirstMovie, review.LastMovie} {
+		if m != nil {
+			ids = append(ids, m.MovieID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	found, err := g.movies.GetMany(ctx, ids)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to look up year in review titles")
+		return
+	}
+	for _, m := range []*models.ReviewMovie{review.FirstMovie, review.LastMovie} {
+		if m != nil && found[m.MovieID] != nil {
+			m.Title = found[m.MovieID].Title
+		}
+	}
+}
+
+// fillMonths returns a count for each month of year, including empty ones
+func fillMonths(year int, counts []*models.PeriodCount) []*models.PeriodCount {
+	byMonth := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byMonth[c.Period] = c.Count
+	}
+
+	months := make([]*models.PeriodCount, 12)
+	for i := range months {
+		period := time.Date(year, time.Month(i+1), 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+		months[i] = &models.PeriodCount{Period: period, Count: byMonth[period]}
+	}
+	return months
+}
+
+// topMonths returns the busiest months, earliest first among equals
+func topMonths(months []*models.PeriodCount) []*models.PeriodCount {
+	var top []*models.PeriodCount
+	for _, m := range months {
+		if m.Count > 0 {
+			top = append(top, m)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Count > top[j].Count
+	})
+	if len(top) > topMonthCount {
+		top = top[:topMonthCount]
+	}
+	return top
+}
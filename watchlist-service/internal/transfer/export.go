@@ -0,0 +1,205 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/movies"
+)
+
+// ErrSingleList is returned when a second list is written to a CSV export
+var ErrSingleList = errors.New("CSV formats hold a single list")
+
+// Exporter streams lists to a writer in one of the supported formats. Items
+// are written as they arrive so large lists are never held in memory.
+type Exporter interface {
+	BeginList(list *models.List) error
+	// WriteItem writes one item of the current list. movie may be nil when
+	// movie-service doesn't know the movie or the format doesn't need it.
+	WriteItem(item *models.WatchlistItem, movie *movies.Movie) error
+	Close() error
+}
+
+func NewExporter(format string, w io.Writer) (Exporter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonExporter{w: bufio.NewWriter(w)}, nil
+	case FormatLetterboxd, FormatIMDb:
+		return &csvExporter{w: csv.NewWriter(w), format: format}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// NeedsMovies reports whether the format identifies movies by title and
+// external IDs, which have to be looked up in movie-service
+func NeedsMovies(format string) bool {
+	return format == FormatLetterboxd || format == FormatIMDb
+}
+
+// MultiList reports whether the format can hold more than one list
+func MultiList(format string) bool {
+	return format == FormatJSON
+}
+
+func ContentType(format string) string {
+	if format == FormatJSON {
+		return "application/json"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+func Extension(format string) string {
+	if format == FormatJSON {
+		return "json"
+	}
+	return "csv"
+}
+
+// jsonExporter writes a Document without building it in memory
+type jsonExporter struct {
+	w       *bufio.Writer
+	err     error
+	started bool
+	lists   int
+	items   int
+}
+
+func (e *jsonExporter) BeginList(list *models.List) error {
+	e.start()
+	if e.lists > 0 {
+		e.write(`]},`)
+	}
+
+	name, err := json.Marshal(list.Name)
+	if err != nil {
+		return err
+	}
+	e.write(`{"name":` + string(name))
+	if list.IsDefault {
+		e.write(`,"isDefault":true`)
+	}
+	e.write(`,"items":[`)
+
+	e.lists++
+	e.items = 0
+	return e.err
+}
+
+func (e *jsonExporter) WriteItem(item *models.WatchlistItem, movie *movies.Movie) error {
+	addedAt := item.CreatedAt
+	data, err := json.Marshal(DocumentItem{
+		MovieID:  item.MovieID,
+		Note:     item.Note,
+		Priority: item.Priority,
+		Tags:     item.Tags,
+		AddedAt:  &addedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	if e.items > 0 {
+		e.write(",")
+	}
+	e.write(string(data))
+	e.items++
+	return e.err
+}
+
+func (e *jsonExporter) Close() error {
+	e.start()
+	if e.lists > 0 {
+		e.write(`]}`)
+	}
+	e.write("]}\n")
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// start writes the document header the first time it is called
+func (e *jsonExporter) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+	exportedAt, _ := json.Marshal(time.Now().UTC())
+	e.write(`{"version":` + strconv.Itoa(DocumentVersion) + `,"exportedAt":` + string(exportedAt) + `,"lists":[`)
+}
+
+// write remembers the first error so callers can check once per item
+func (e *jsonExporter) write(s string) {
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+// csvExporter writes a list in the column layout of a Letterboxd watchlist
+// export or an IMDb list export, which both services can import again.
+// Movies movie-service doesn't know are still written, with the movie
+// columns left empty, so the row count matches the list.
+type csvExporter struct {
+	w        *csv.Writer
+	format   string
+	started  bool
+	position int
+}
+
+var csvHeaders = map[string][]string{
+	FormatLetterboxd: {"Date", "Name", "Year", "tmdbID", "imdbID", "Tags"},
+	FormatIMDb:       {"Position", "Const", "Created", "Modified", "Description", "Title", "Title Type", "Year"},
+}
+
+func (e *csvExporter) BeginList(list *models.List) error {
+	if e.started {
+		return ErrSingleList
+	}
+	e.started = true
+	return e.w.Write(csvHeaders[e.format])
+}
+
+func (e *csvExporter) WriteItem(item *models.WatchlistItem, movie *movies.Movie) error {
+	var title, year, imdbID, tmdbID string
+	if movie != nil {
+		title = movie.Title
+		imdbID = movie.ImdbID
+		if movie.ReleaseYear > 0 {
+			year = strconv.Itoa(movie.ReleaseYear)
+		}
+		if movie.TmdbID > 0 {
+			tmdbID = strconv.Itoa(movie.TmdbID)
+		}
+	}
+
+	e.position++
+	switch e.format {
+	case FormatLetterboxd:
+		return e.w.Write([]string{
+			item.CreatedAt.Format("2006-01-02"), title, year, tmdbID, imdbID, strings.Join(item.Tags, ", "),
+		})
+	default:
+		return e.w.Write([]string{
+			strconv.Itoa(e.position), imdbID, item.CreatedAt.Format("2006-01-02"), item.UpdatedAt.Format("2006-01-02"),
+			item.Note, title, "Movie", year,
+		})
+	}
+}
+
+func (e *csvExporter) Close() error {
+	if !e.started {
+		if err := e.w.Write(csvHeaders[e.format]); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
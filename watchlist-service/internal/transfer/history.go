@@ -0,0 +1,197 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/movies"
+)
+
+// History import formats
+const (
+	FormatNetflix = "netflix"
+	FormatTrakt   = "trakt"
+)
+
+// MaxHistoryEntries caps the number of rows in one history import file
+const MaxHistoryEntries = 100000
+
+// HistoryAction is the action recorded for imported views. Imported views
+// are single events outside any watch session.
+const HistoryAction = models.ActionFinished
+
+var ErrUnknownHistoryFormat = errors.New("unknown format, expected netflix or trakt")
+
+// HistoryEntry is one view read from a history import file
+type HistoryEntry struct {
+	Row       int
+	Ref       movies.Ref
+	WatchedAt time.Time
+}
+
+// HistoryFile is the result of parsing a history export. Skipped counts rows
+// that are not movie views, such as episodes and trailers.
+type HistoryFile struct {
+	Entries []*HistoryEntry
+	Skipped int
+}
+
+// ValidHistoryFormat reports whether format is a history import format
+func ValidHistoryFormat(format string) bool {
+	return format == FormatNetflix || format == FormatTrakt
+}
+
+// ParseHistory reads a Netflix ViewingActivity.csv or a Trakt history JSON
+// export. For Netflix, profile limits the import to one profile's rows.
+func ParseHistory(format string, r io.Reader, profile string) (*HistoryFile, error) {
+	switch format {
+	case FormatNetflix:
+		return parseNetflix(r, profile)
+	case FormatTrakt:
+		return parseTrakt(r)
+	default:
+		return nil, ErrUnknownHistoryFormat
+	}
+}
+
+// netflixEpisodeMarkers appear in the titles Netflix gives series episodes,
+// e.g. "Dark: Season 1: Secrets"
+var netflixEpisodeMarkers = []string{": Season ", ": Series ", ": Limited Series", ": Episode "}
+
+// parseNetflix reads ViewingActivity.csv, where each row is one playback
+// session. Sessions with a Supplemental Video Type are trailers and teasers.
+func parseNetflix(r io.Reader, profile string) (*HistoryFile, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &HistoryFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, required := range []string{"Title", "Start Time"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("invalid ViewingActivity.csv: no %s column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	file := &HistoryFile{}
+	row := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		row++
+		if row > MaxHistoryEntries {
+			return nil, fmt.Errorf("history import files are limited to %d rows", MaxHistoryEntries)
+		}
+
+		if profile != "" && !strings.EqualFold(field(record, "Profile Name"), profile) {
+			continue
+		}
+
+		title := field(record, "Title")
+		if field(record, "Supplemental Video Type") != "" || title == "" || isNetflixEpisode(title) {
+			file.Skipped++
+			continue
+		}
+
+		watchedAt, err := time.Parse("2006-01-02 15:04:05", field(record, "Start Time"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid Start Time %q", row, field(record, "Start Time"))
+		}
+
+		file.Entries = append(file.Entries, &HistoryEntry{
+			Row:       row,
+			Ref:       movies.Ref{Title: title},
+			WatchedAt: watchedAt,
+		})
+	}
+
+	return file, nil
+}
+
+func isNetflixEpisode(title string) bool {
+	for _, marker := range netflixEpisodeMarkers {
+		if strings.Contains(title, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// traktItem is one entry of a Trakt history export
+type traktItem struct {
+	WatchedAt time.Time `json:"watched_at"`
+	Type      string    `json:"type"`
+	Movie     *struct {
+		Title string `json:"title"`
+		Year  int    `json:"year"`
+		IDs   struct {
+			Imdb string `json:"imdb"`
+			Tmdb int    `json:"tmdb"`
+		} `json:"ids"`
+	} `json:"movie"`
+}
+
+// parseTrakt reads the history array from a Trakt export. Only movie views
+// are imported; episodes are counted as skipped.
+func parseTrakt(r io.Reader) (*HistoryFile, error) {
+	var items []traktItem
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("invalid Trakt history JSON: %w", err)
+	}
+	if len(items) > MaxHistoryEntries {
+		return nil, fmt.Errorf("history import files are limited to %d rows", MaxHistoryEntries)
+	}
+
+	file := &HistoryFile{}
+	for i, item := range items {
+		if item.Type != "movie" || item.Movie == nil {
+			file.Skipped++
+			continue
+		}
+		if item.WatchedAt.IsZero() {
+			return nil, fmt.Errorf("item %d: watched_at is required", i+1)
+		}
+
+		file.Entries = append(file.Entries, &HistoryEntry{
+			Row: i + 1,
+			Ref: movies.Ref{
+				Title:  item.Movie.Title,
+				Year:   item.Movie.Year,
+				ImdbID: item.Movie.IDs.Imdb,
+				TmdbID: item.Movie.IDs.Tmdb,
+			},
+			WatchedAt: item.WatchedAt,
+		})
+	}
+
+	return file, nil
+}
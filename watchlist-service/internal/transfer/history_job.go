@@ -0,0 +1,176 @@
+package transfer
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"movie-microservices/watchlist-service/internal/models"
+	"movie-microservices/watchlist-service/internal/movies"
+	"movie-microservices/watchlist-service/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// historyBatchSize is the number of rows resolved and written per step;
+	// the job's progress is saved after each one
+	historyBatchSize = 500
+
+	// maxUnmappedTitles caps the unmapped titles kept on a job for display
+	maxUnmappedTitles = 100
+
+	// StaleJobTimeout is how long a job may go without progress before it is
+	// considered abandoned
+	StaleJobTimeout = 15 * time.Minute
+)
+
+// HistoryImporter runs history import jobs in the background
+type HistoryImporter struct {
+	jobs    repository.ImportJobRepository
+	history repository.HistoryRepository
+	movies  *movies.Client
+	// imported is called after a job wrote at least one row
+	imported func(userID int)
+}
+
+func NewHistoryImporter(jobs repository.ImportJobRepository, history repository.HistoryRepository, movies *movies.Client, imported func(userID int)) *HistoryImporter {
+	return &HistoryImporter{jobs: jobs, history: history, movies: movies, imported: imported}
+}
+
+// Start records a job for a parsed file and processes it in the background
+func (imp *HistoryImporter) Start(userID int, format string, file *HistoryFile) (*models.ImportJob, error) {
+	job, err := imp.jobs.Create(userID, format, len(file.Entries)+file.Skipped, file.Skipped)
+	if err != nil {
+		return nil, err
+	}
+
+	go imp.run(job, file.Entries)
+
+	return job, nil
+}
+
+func (imp *HistoryImporter) run(job *models.ImportJob, entries []*HistoryEntry) {
+	ctx := context.Background()
+	logger := log.With().Int("jobId", job.ID).Int("userId", job.UserID).Logger()
+
+	job.Status = models.JobRunning
+	if err := imp.jobs.Update(job); err != nil {
+		logger.Error().Err(err).Msg("Failed to start history import")
+		return
+	}
+
+	err := imp.process(ctx, job, entries)
+
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		logger.Error().Err(err).Msg("History import failed")
+		job.Status = models.JobFailed
+		job.Error = "Import stopped before finishing; rows counted as imported were saved"
+	} else {
+		job.Status = models.JobCompleted
+	}
+	if err := imp.jobs.Update(job); err != nil {
+		logger.Error().Err(err).Msg("Failed to save history import result")
+	}
+
+	if job.Imported > 0 && imp.imported != nil {
+		imp.imported(job.UserID)
+	}
+
+	logger.Info().
+		Str("status", job.Status).
+		Int("imported", job.Imported).
+		Int("duplicates", job.Duplicates).
+		Int("unmapped", job.Unmapped).
+		Int("skipped", job.Skipped).
+		Msg("History import finished")
+}
+
+// process resolves and writes entries batch by batch, saving progress on
+// job after each batch
+func (imp *HistoryImporter) process(ctx context.Context, job *models.ImportJob, entries []*HistoryEntry) error {
+	resolved := make(map[string]int)
+	unmapped := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for start := 0; start < len(entries); start += historyBatchSize {
+		end := start + historyBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+
+		// Look up each distinct title once per job
+		var refs []movies.Ref
+		var keys []string
+		for _, entry := range batch {
+			key := refKey(entry.Ref)
+			if _, ok := resolved[key]; ok {
+				continue
+			}
+			resolved[key] = 0
+			refs = append(refs, entry.Ref)
+			keys = append(keys, key)
+		}
+		if len(refs) > 0 {
+			found, err := imp.movies.ResolveMany(ctx, refs)
+			if err != nil {
+				return err
+			}
+			for i, movie := range found {
+				if movie != nil {
+					resolved[keys[i]] = movie.ID
+				}
+			}
+		}
+
+		var items []*models.HistoryItem
+		for _, entry := range batch {
+			movieID := resolved[refKey(entry.Ref)]
+			if movieID == 0 {
+				job.Unmapped++
+				if !unmapped[entry.Ref.Title] && len(job.UnmappedTitles) < maxUnmappedTitles {
+					unmapped[entry.Ref.Title] = true
+					job.UnmappedTitles = append(job.UnmappedTitles, entry.Ref.Title)
+				}
+				continue
+			}
+
+			viewKey := strconv.Itoa(movieID) + "@" + strconv.FormatInt(entry.WatchedAt.UnixNano(), 10)
+			if seen[viewKey] {
+				job.Duplicates++
+				continue
+			}
+			seen[viewKey] = true
+
+			items = append(items, &models.HistoryItem{
+				UserID:    job.UserID,
+				MovieID:   movieID,
+				Action:    HistoryAction,
+				CreatedAt: entry.WatchedAt,
+			})
+		}
+
+		if len(items) > 0 {
+			inserted, err := imp.history.AddMany(job.UserID, items)
+			if err != nil {
+				return err
+			}
+			job.Imported += inserted
+			job.Duplicates += len(items) - inserted
+		}
+
+		job.Processed += len(batch)
+		if err := imp.jobs.Update(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func refKey(ref movies.Ref) string {
+	return strings.ToLower(ref.Title) + "|" + strconv.Itoa(ref.Year) + "|" + strings.ToLower(ref.ImdbID) + "|" + strconv.Itoa(ref.TmdbID)
+}
@@ -0,0 +1,276 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"movie-microservices/watchlist-service/internal/movies"
+)
+
+// Import and export formats
+const (
+	FormatJSON       = "json"
+	FormatLetterboxd = "letterboxd"
+	FormatIMDb       = "imdb"
+)
+
+// MaxEntries caps the number of movies in one import file
+const MaxEntries = 5000
+
+// DocumentVersion is the version of the native JSON format written by export
+const DocumentVersion = 1
+
+var (
+	ErrUnknownFormat  = errors.New("unknown format, expected json, letterboxd or imdb")
+	ErrTooManyEntries = fmt.Errorf("import files are limited to %d movies", MaxEntries)
+)
+
+// Reasons an entry is reported as skipped or unmapped
+const (
+	ReasonExists    = "already in list"
+	ReasonDuplicate = "duplicate in file"
+	ReasonUnmapped  = "no matching movie"
+)
+
+// Document is the native JSON format
+type Document struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	Lists      []DocumentList `json:"lists"`
+}
+
+type DocumentList struct {
+	Name      string         `json:"name"`
+	IsDefault bool           `json:"isDefault,omitempty"`
+	Items     []DocumentItem `json:"items"`
+}
+
+type DocumentItem struct {
+	MovieID  int        `json:"movieId"`
+	Note     string     `json:"note,omitempty"`
+	Priority int        `json:"priority,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	AddedAt  *time.Time `json:"addedAt,omitempty"`
+}
+
+// Entry is one movie read from an import file. Native entries carry our
+// movie ID; entries from other services carry a Ref to resolve.
+type Entry struct {
+	Row      int
+	MovieID  int
+	Ref      movies.Ref
+	Note     string
+	Priority int
+	Tags     []string
+	AddedAt  *time.Time
+}
+
+// Report describes the outcome of an import, or what it would do in dry-run
+// mode.
+type Report struct {
+	DryRun   bool          `json:"dryRun"`
+	Added    []ReportEntry `json:"added"`
+	Skipped  []ReportEntry `json:"skipped"`
+	Unmapped []ReportEntry `json:"unmapped"`
+}
+
+// ReportEntry points back at the row of the file it came from: the data row
+// for CSV (the header is not counted) or the item's position for JSON.
+type ReportEntry struct {
+	Row     int    `json:"row"`
+	MovieID int    `json:"movieId,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Year    int    `json:"year,omitempty"`
+	ImdbID  string `json:"imdbId,omitempty"`
+	TmdbID  int    `json:"tmdbId,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func NewReport(dryRun bool) *Report {
+	return &Report{
+		DryRun:   dryRun,
+		Added:    []ReportEntry{},
+		Skipped:  []ReportEntry{},
+		Unmapped: []ReportEntry{},
+	}
+}
+
+// ReportEntry builds the report line for an entry
+func (e *Entry) ReportEntry(reason string) ReportEntry {
+	return ReportEntry{
+		Row:     e.Row,
+		MovieID: e.MovieID,
+		Title:   e.Ref.Title,
+		Year:    e.Ref.Year,
+		ImdbID:  e.Ref.ImdbID,
+		TmdbID:  e.Ref.TmdbID,
+		Reason:  reason,
+	}
+}
+
+// ValidFormat reports whether format is one we can import and export
+func ValidFormat(format string) bool {
+	return format == FormatJSON || format == FormatLetterboxd || format == FormatIMDb
+}
+
+// Parse reads every entry of an import file
+func Parse(format string, r io.Reader) ([]*Entry, error) {
+	switch format {
+	case FormatJSON:
+		return parseJSON(r)
+	case FormatLetterboxd, FormatIMDb:
+		return parseCSV(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+func parseJSON(r io.Reader) ([]*Entry, error) {
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if doc.Version != DocumentVersion {
+		return nil, fmt.Errorf("unsupported document version %d", doc.Version)
+	}
+
+	var entries []*Entry
+	for _, list := range doc.Lists {
+		for _, item := range list.Items {
+			row := len(entries) + 1
+			if row > MaxEntries {
+				return nil, ErrTooManyEntries
+			}
+			if item.MovieID <= 0 {
+				return nil, fmt.Errorf("item %d: movieId is required", row)
+			}
+			if item.Priority < 0 || item.Priority > 3 {
+				return nil, fmt.Errorf("item %d: priority must be between 0 and 3", row)
+			}
+			if utf8.RuneCountInString(item.Note) > 1000 {
+				return nil, fmt.Errorf("item %d: note is longer than 1000 characters", row)
+			}
+			entries = append(entries, &Entry{
+				Row:      row,
+				MovieID:  item.MovieID,
+				Note:     item.Note,
+				Priority: item.Priority,
+				Tags:     item.Tags,
+				AddedAt:  item.AddedAt,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// csvColumns maps normalised header names used by Letterboxd and IMDb
+// exports to the field they hold
+var csvColumns = map[string]string{
+	"name":        "title",
+	"title":       "title",
+	"year":        "year",
+	"const":       "imdb",
+	"imdbid":      "imdb",
+	"tmdbid":      "tmdb",
+	"date":        "date",
+	"created":     "date",
+	"description": "note",
+	"tags":        "tags",
+}
+
+// parseCSV reads a Letterboxd or IMDb list export. Both identify movies by
+// title and year; IMDb adds its own ID, and Letterboxd's import format
+// allows IMDb and TMDB IDs too, so one header-driven reader serves both.
+func parseCSV(r io.Reader) ([]*Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", ""))
+		if field, ok := csvColumns[name]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("invalid CSV: no Name or Title column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []*Entry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		row := len(entries) + 1
+		if row > MaxEntries {
+			return nil, ErrTooManyEntries
+		}
+
+		entry := &Entry{
+			Row: row,
+			Ref: movies.Ref{
+				Title:  field(record, "title"),
+				ImdbID: field(record, "imdb"),
+			},
+			Note:    field(record, "note"),
+			AddedAt: parseDate(field(record, "date")),
+		}
+		entry.Ref.Year, _ = strconv.Atoi(field(record, "year"))
+		entry.Ref.TmdbID, _ = strconv.Atoi(field(record, "tmdb"))
+		if note := []rune(entry.Note); len(note) > 1000 {
+			entry.Note = string(note[:1000])
+		}
+		if tags := field(record, "tags"); tags != "" {
+			entry.Tags = strings.Split(tags, ",")
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func parseDate(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
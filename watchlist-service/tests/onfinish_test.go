@@ -0,0 +1,83 @@
+package tests
+
+import (
+    "movie-microservices/watchlist-service/internal/models"
+    "movie-microservices/watchlist-service/internal/repository"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// Users only the on-finish tests touch
+var onFinishTestUsers = map[string]int{
+    models.OnFinishRemove: 910001,
+    models.OnFinishMove:   910002,
+}
+
+const onFinishTestMovie = 424242
+
+// Finishing a movie that onFinish takes off the default list must not lose
+// when it was added: the stats and the year review still count it
+func TestOnFinishKeepsWatchlistAddition(t *testing.T) {
+    db := connect(t)
+
+    for onFinish, userID := range onFinishTestUsers {
+        t.Run(onFinish, func(t *testing.T) {
+            resetUser(t, db, userID)
+
+            lists := repository.NewListRepository(db)
+            watchlist := repository.NewWatchlistRepository(db)
+            history := repository.NewHistoryRepository(db)
+            settings := repository.NewSettingsRepository(db)
+            reviews := repository.NewYearReviewRepository(db)
+
+            _, err := settings.Update(userID, onFinish)
+            require.NoError(t, err)
+            list, err := lists.GetDefault(userID)
+            require.NoError(t, err)
+            _, err = watchlist.Add(list.ID, userID, onFinishTestMovie)
+            require.NoError(t, err)
+
+            // Pretend the movie was added ten days ago, within this year
+            now := time.Now().UTC()
+            addedAt := now.AddDate(0, 0, -10).Truncate(time.Second)
+            if yearStart, _ := repository.YearBounds(now.Year()); addedAt.Before(yearStart) {
+                addedAt = yearStart
+            }
+            _, err = db.Exec(`UPDATE watchlists SET created_at = $2 WHERE user_id = $1`, userID, addedAt)
+            require.NoError(t, err)
+            _, err = db.Exec(`UPDATE watchlist_additions SET added_at = $2 WHERE user_id = $1`, userID, addedAt)
+            require.NoError(t, err)
+
+            _, err = history.Add(userID, onFinishTestMovie, models.ActionStarted)
+            require.NoError(t, err)
+            finished, err := history.Add(userID, onFinishTestMovie, models.ActionFinished)
+            require.NoError(t, err)
+            require.NotNil(t, finished.RemovedFromList, "movie was not taken off the default list")
+
+            if onFinish == models.OnFinishMove {
+                require.NotNil(t, finished.MovedToList)
+                item, err := watchlist.Get(*finished.MovedToList, onFinishTestMovie)
+                require.NoError(t, err)
+                require.NotNil(t, item)
+                assert.True(t, item.CreatedAt.Equal(addedAt), "moved item added at %v, want %v", item.CreatedAt, addedAt)
+            }
+
+            stats, err := history.GetStats(userID, repository.StatsQuery{Today: now})
+            require.NoError(t, err)
+            assert.Equal(t, 1, stats.WatchlistToFinishSamples)
+            if assert.NotNil(t, stats.AvgDaysWatchlistToFinish) {
+                want := now.Sub(addedAt).Hours() / 24
+                assert.InDelta(t, want, *stats.AvgDaysWatchlistToFinish, 0.1)
+            }
+
+            review, err := reviews.Summarize(userID, now.Year())
+            require.NoError(t, err)
+            assert.Equal(t, 1, review.Watchlisted)
+            assert.Equal(t, 1, review.WatchlistedFinished)
+            assert.Equal(t, 1.0, review.CompletionRatio)
+        })
+    }
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+    "database/sql"
+    "movie-microservices/watchlist-service/internal/config"
+    "movie-microservices/watchlist-service/internal/database"
+    "movie-microservices/watchlist-service/internal/models"
+    "movie-microservices/watchlist-service/internal/repository"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// Repository tests run against the database in the service's configuration,
+// so each one works with its own user IDs and removes their rows before and
+// after running.
+const sessionTestUser = 910101
+
+const sessionTestMovie = 424242
+
+// connect returns a migrated database, or skips the test without one
+func connect(t *testing.T) *sql.DB {
+    t.Helper()
+    cfg, err := config.LoadConfig()
+    require.NoError(t, err)
+
+    db, err := database.Connect(cfg.Database)
+    if err != nil {
+        t.Skipf("database not available: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    require.NoError(t, database.RunMigrations(db))
+    return db
+}
+
+// userTables are the tables holding rows for a test user. The outbox is
+// among them so the relay never publishes the tests' events.
+var userTables = []string{"history", "watch_sessions", "watchlists", "lists", "user_settings", "watchlist_additions", "outbox", "year_reviews"}
+
+// resetUser deletes every row of userID now and again when the test ends
+func resetUser(t *testing.T, db *sql.DB, userID int) {
+    t.Helper()
+    reset := func() {
+        for _, table := range userTables {
+            _, err := db.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userID)
+            require.NoError(t, err, "clearing %s for user %d", table, userID)
+        }
+    }
+    reset()
+    t.Cleanup(reset)
+}
+
+func TestHistoryAddSessionTransitions(t *testing.T) {
+    db := connect(t)
+    history := repository.NewHistoryRepository(db)
+
+    tests := []struct {
+        name   string
+        before []string
+        action string
+        // rejectedIn is the session state the action is rejected in, or
+        // "-" when it is accepted
+        rejectedIn string
+    }{
+        {"start", nil, models.ActionStarted, "-"},
+        {"pause", []string{models.ActionStarted}, models.ActionPaused, "-"},
+        {"resume", []string{models.ActionStarted, models.ActionPaused}, models.ActionResumed, "-"},
+        {"finish while playing", []string{models.ActionStarted}, models.ActionFinished, "-"},
+        {"finish while paused", []string{models.ActionStarted, models.ActionPaused}, models.ActionFinished, "-"},
+        {"abandon", []string{models.ActionStarted}, models.ActionAbandoned, "-"},
+        {"rewatch after finishing", []string{models.ActionStarted, models.ActionFinished}, models.ActionRewatched, "-"},
+        {"start again after abandoning", []string{models.ActionStarted, models.ActionAbandoned}, models.ActionStarted, "-"},
+
+        {"pause without a session", nil, models.ActionPaused, ""},
+        {"finish without a session", nil, models.ActionFinished, ""},
+        {"rewatch never watched", nil, models.ActionRewatched, ""},
+        {"rewatch after abandoning", []string{models.ActionStarted, models.ActionAbandoned}, models.ActionRewatched, ""},
+        {"start while playing", []string{models.ActionStarted}, models.ActionStarted, models.SessionPlaying},
+        {"resume while playing", []string{models.ActionStarted}, models.ActionResumed, models.SessionPlaying},
+        {"rewatch while playing", []string{models.ActionStarted}, models.ActionRewatched, models.SessionPlaying},
+        {"pause while paused", []string{models.ActionStarted, models.ActionPaused}, models.ActionPaused, models.SessionPaused},
+        {"pause after finishing", []string{models.ActionStarted, models.ActionFinished}, models.ActionPaused, ""},
+        {"unknown action", []string{models.ActionStarted}, "rated", models.SessionPlaying},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resetUser(t, db, sessionTestUser)
+            for _, action := range tt.before {
+                _, err := history.Add(sessionTestUser, sessionTestMovie, action)
+                require.NoError(t, err, "setting up with %s", action)
+            }
+
+            item, err := history.Add(sessionTestUser, sessionTestMovie, tt.action)
+            if tt.rejectedIn == "-" {
+                require.NoError(t, err)
+                assert.Equal(t, tt.action, item.Action)
+                return
+            }
+
+            var transitionErr *repository.TransitionError
+            require.ErrorAs(t, err, &transitionErr)
+            assert.Equal(t, tt.rejectedIn, transitionErr.State)
+            assert.Equal(t, tt.action, transitionErr.Action)
+
+            // A rejected action leaves nothing behind
+            var events int
+            require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM history WHERE user_id = $1`, sessionTestUser).Scan(&events))
+            assert.Equal(t, len(tt.before), events)
+        })
+    }
+}